@@ -0,0 +1,201 @@
+// Package archive reads mail exported from other clients (.eml files, mbox files and Maildir
+// folders) so old mailboxes can be fed through the same extraction as live fetches.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Walk calls fn with every message stored under path, which may be a single .eml file, an mbox
+// file, a Maildir, or a folder containing any of these (searched recursively). source names the
+// message for logs, e.g. "inbox.mbox #12". Walking stops at the first error returned by fn.
+func Walk(path string, fn func(source string, body []byte) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return readFile(path, fn)
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isMaildir(p) {
+				if err := readMaildir(p, fn); err != nil {
+					return err
+				}
+				// Maildir++ subfolders (".Sent", ...) are siblings of cur/new, keep walking
+				// but never descend into the message directories themselves.
+			}
+			switch d.Name() {
+			case "cur", "new", "tmp":
+				if isMaildir(filepath.Dir(p)) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if ok, err := isMailFile(p); err != nil || !ok {
+			return err
+		}
+		return readFile(p, fn)
+	})
+}
+
+// isMaildir reports whether dir has the cur and new subdirectories of a Maildir.
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// isMailFile reports whether a file found while walking a folder should be imported:
+// .eml/.mbox files, and extensionless files that start like an mbox.
+func isMailFile(path string) (bool, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".eml", ".mbox", ".mbx":
+		return true, nil
+	case "":
+		return startsWithFromLine(path)
+	}
+	return false, nil
+}
+
+func startsWithFromLine(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, 5)
+	n, _ := io.ReadFull(f, head)
+	return string(head[:n]) == "From ", nil
+}
+
+// readFile reads a single file as an mbox if it starts with a "From " line, else as one message.
+func readFile(path string, fn func(source string, body []byte) error) error {
+	isMbox, err := startsWithFromLine(path)
+	if err != nil {
+		return err
+	}
+	if isMbox {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return ReadMbox(f, func(n int, body []byte) error {
+			return fn(fmt.Sprintf("%s #%d", path, n), body)
+		})
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return fn(path, body)
+}
+
+// readMaildir reads the messages in new/ and cur/ (tmp/ holds incomplete deliveries).
+func readMaildir(dir string, fn func(source string, body []byte) error) error {
+	for _, sub := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+				names = append(names, e.Name())
+			}
+		}
+		// Maildir names start with the delivery time, so this is roughly chronological
+		sort.Strings(names)
+		for _, name := range names {
+			p := filepath.Join(dir, sub, name)
+			body, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if err := fn(p, body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadMbox splits an mbox stream into messages and calls fn with each (numbered from 1).
+// The "From " separator lines are dropped and ">From " quoting (mboxrd) is undone.
+func ReadMbox(r io.Reader, fn func(n int, body []byte) error) error {
+	br := bufio.NewReader(r)
+	var msg bytes.Buffer
+	n := 0
+	started := false
+
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		n++
+		body := msg.Bytes()
+		// The blank line before the next separator belongs to the mbox format, not the message
+		if bytes.HasSuffix(body, []byte("\r\n")) {
+			body = body[:len(body)-2]
+		} else if bytes.HasSuffix(body, []byte("\n")) {
+			body = body[:len(body)-1]
+		}
+		err := fn(n, append([]byte(nil), body...))
+		msg.Reset()
+		return err
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")):
+				if err := flush(); err != nil {
+					return err
+				}
+				started = true
+			case started:
+				msg.Write(unquoteFrom(line))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// unquoteFrom removes one ">" from lines like ">From " or ">>From ".
+func unquoteFrom(line []byte) []byte {
+	i := 0
+	for i < len(line) && line[i] == '>' {
+		i++
+	}
+	if i > 0 && bytes.HasPrefix(line[i:], []byte("From ")) {
+		return line[1:]
+	}
+	return line
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMbox(t *testing.T) {
+	tests := []struct {
+		name string
+		mbox string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"no separator", "Subject: x\n\nbody\n", nil},
+		{"one message", "From a@b Sat May 11 10:00:00 2024\nSubject: 1\n\nbody\n", []string{"Subject: 1\n\nbody"}},
+		{
+			name: "separator blank lines dropped",
+			mbox: "From a@b Sat May 11 10:00:00 2024\nSubject: 1\n\none\n\nFrom c@d Sun May 12 10:00:00 2024\nSubject: 2\n\ntwo\n",
+			want: []string{"Subject: 1\n\none\n", "Subject: 2\n\ntwo"},
+		},
+		{
+			name: "mboxrd quoting undone",
+			mbox: "From a@b Sat May 11 10:00:00 2024\nSubject: 1\n\n>From here\n>>From there\n> From mail\n",
+			want: []string{"Subject: 1\n\nFrom here\n>From there\n> From mail"},
+		},
+		{
+			name: "CRLF",
+			mbox: "From a@b Sat May 11 10:00:00 2024\r\nSubject: 1\r\n\r\nbody\r\n\r\nFrom c@d Sun May 12 10:00:00 2024\r\nSubject: 2\r\n",
+			want: []string{"Subject: 1\r\n\r\nbody\r\n", "Subject: 2"},
+		},
+		{"text before the first separator", "junk\nFrom a@b\nSubject: 1\n", []string{"Subject: 1"}},
+		{"no final newline", "From a@b\nSubject: 1\n\nbody", []string{"Subject: 1\n\nbody"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			n := 0
+			err := ReadMbox(strings.NewReader(tt.mbox), func(num int, body []byte) error {
+				n++
+				if num != n {
+					t.Errorf("message numbered %d, want %d", num, n)
+				}
+				got = append(got, string(body))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMboxStops(t *testing.T) {
+	mbox := "From a@b\nSubject: 1\n\nFrom c@d\nSubject: 2\n"
+	stop := errors.New("stop")
+	calls := 0
+	err := ReadMbox(strings.NewReader(mbox), func(int, []byte) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("ReadMbox = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+}
+
+// writeFiles creates the files (forward-slash paths, relative to dir) and any parent folders.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"single.eml":                  "Subject: eml\n",
+		"export/inbox.mbox":           "From a@b\nSubject: mbox 1\n\nFrom c@d\nSubject: mbox 2\n",
+		"export/Sent":                 "From a@b\nSubject: extensionless mbox\n",
+		"export/notes.txt":            "Subject: not mail\n",
+		"export/README":               "Subject: no From line\n",
+		"export/.hidden.eml":          "Subject: hidden\n",
+		"Maildir/cur/2_1:2,S":         "Subject: maildir cur\n",
+		"Maildir/new/1_1":             "Subject: maildir new\n",
+		"Maildir/new/.lock":           "Subject: dot file\n",
+		"Maildir/tmp/3_1":             "Subject: incomplete\n",
+		"Maildir/.Flights/cur/4_1:2,": "Subject: maildir++ folder\n",
+		"Maildir/.Flights/new/.keep":  "",
+	})
+
+	var subjects []string
+	sources := make(map[string]string)
+	err := Walk(dir, func(source string, body []byte) error {
+		s := strings.TrimSpace(strings.TrimPrefix(string(body), "Subject: "))
+		subjects = append(subjects, s)
+		sources[s] = source
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lexical walk order ("Maildir" before "export"); Maildir cur/ before new/
+	want := []string{"maildir cur", "maildir new", "maildir++ folder", "extensionless mbox", "mbox 1", "mbox 2", "eml"}
+	if !reflect.DeepEqual(subjects, want) {
+		t.Errorf("walked %q, want %q", subjects, want)
+	}
+	if got, want := sources["mbox 2"], filepath.Join(dir, "export", "inbox.mbox")+" #2"; got != want {
+		t.Errorf("source = %q, want %q", got, want)
+	}
+}
+
+func TestWalkFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"message.txt": "Subject: any name\n",
+		"box":         "From a@b\nSubject: 1\n\nFrom c@d\nSubject: 2\n",
+	})
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"message.txt", 1}, // named explicitly, so read whatever its extension
+		{"box", 2},
+	}
+	for _, tt := range tests {
+		n := 0
+		if err := Walk(filepath.Join(dir, tt.file), func(string, []byte) error { n++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.want {
+			t.Errorf("%s: %d messages, want %d", tt.file, n, tt.want)
+		}
+	}
+	if err := Walk(filepath.Join(dir, "missing"), func(string, []byte) error { return nil }); err == nil {
+		t.Error("Walk of a missing path succeeded")
+	}
+}
@@ -0,0 +1,343 @@
+// Package cli implements the one-shot subcommands (fetch-once, extract, config get/set, ...)
+// shared by the desktop binary and the headless daemon.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"igcmailimap/config"
+	"igcmailimap/engine"
+	"igcmailimap/history"
+	"igcmailimap/logger"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// Exit codes returned by Run, for cron jobs and scripts.
+const (
+	ExitOK      = 0 // success
+	ExitFailure = 1 // the operation failed (connection, login, extraction, ...)
+	ExitUsage   = 2 // invalid command line
+	ExitConfig  = 3 // config or state could not be loaded, or required settings are missing
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"fetch-once":      {"fetch-once", "fetch new mail once, extract attachments and exit", runFetchOnce},
+		"test-connection": {"test-connection", "log in to the mail server and count the messages", runTestConnection},
+		"list-folders":    {"list-folders", "list the mailboxes on the IMAP server", runListFolders},
+		"reset-state":     {"reset-state --uid N", "set the last processed UID of the configured account (0 = re-import everything on the next poll)", runResetState},
+		"extract":         {"extract [--out DIR] PATH...", "extract attachments from .eml files, mbox files or Maildir folders", runExtract},
+		"history":         {"history [--limit N] [--outcome O] [--csv]", "list processed messages, newest first", runHistory},
+		"reprocess":       {"reprocess ID...", "fetch the messages of history records again and extract them", runReprocess},
+		"config":          {"config get [KEY] | config set KEY VALUE", "show or change settings", runConfig},
+	}
+}
+
+// IsCommand reports whether name is a subcommand handled by Run.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help"
+}
+
+// Run executes the subcommand in args[0] with the remaining arguments and returns the exit code.
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		Usage(os.Stdout)
+		return ExitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		Usage(os.Stderr)
+		return ExitUsage
+	}
+	return cmd.run(args[1:])
+}
+
+// Usage prints the list of subcommands.
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Commands (all accept --config-dir DIR):")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-45s %s\n", commands[name].usage, commands[name].help)
+	}
+}
+
+// flags returns a flag set for the command with the shared --config-dir flag.
+func flags(name string, configDir *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(configDir, "config-dir", "", "directory holding config.json and state.json (default: OS-specific)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the command line and applies --config-dir. It returns false on invalid arguments.
+func parse(fs *flag.FlagSet, args []string, configDir *string) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if *configDir != "" {
+		config.SetDir(*configDir)
+	}
+	return true
+}
+
+func loadConfig() (*config.Config, bool) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Load config: "+err.Error())
+		return nil, false
+	}
+	return cfg, true
+}
+
+// loadState loads the saved state of the configured account.
+func loadState(cfg *config.Config) (string, *state.State, bool) {
+	path, err := config.StatePath()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "State: "+err.Error())
+		return "", nil, false
+	}
+	st, err := state.Load(path, cfg.StateKey())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Load state: "+err.Error())
+		return "", nil, false
+	}
+	return path, st, true
+}
+
+// requireAccount reports (on stderr) whether the settings needed to connect are present.
+func requireAccount(cfg *config.Config) bool {
+	if cfg.IMAPServer == "" || (cfg.IMAPUser == "" && !cfg.IsJMAP()) || cfg.IMAPPassword == "" {
+		path, _ := config.ConfigPath()
+		fmt.Fprintf(os.Stderr, "Mail server, user and password must be set in %s\n", path)
+		return false
+	}
+	return true
+}
+
+// newEngine returns an engine logging to stdout and recording to the history database;
+// *failed is set when it reports an error. Call the returned function when done.
+func newEngine(cfg *config.Config, st *state.State, failed *bool) (*engine.Engine, func()) {
+	e := engine.New(cfg, st, logger.NewWriter(os.Stdout, cfg.LogOptions()), engine.Hooks{
+		Error: func(string) { *failed = true },
+	})
+	hist, ok := openHistory()
+	if !ok {
+		// Still process mail, just without dedupe and history
+		return e, func() {}
+	}
+	e.SetHistory(hist)
+	return e, func() { hist.Close() }
+}
+
+// openHistory opens the history database, reporting errors on stderr.
+func openHistory() (*history.DB, bool) {
+	path, err := config.HistoryPath()
+	if err == nil {
+		var hist *history.DB
+		if hist, err = history.Open(path); err == nil {
+			return hist, true
+		}
+	}
+	fmt.Fprintln(os.Stderr, "History: "+err.Error())
+	return nil, false
+}
+
+func runFetchOnce(args []string) int {
+	var configDir string
+	fs := flags("fetch-once", &configDir)
+	if !parse(fs, args, &configDir) || fs.NArg() != 0 {
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok || !requireAccount(cfg) {
+		return ExitConfig
+	}
+	if cfg.OutputFolder == "" {
+		fmt.Fprintln(os.Stderr, "Output folder is not set")
+		return ExitConfig
+	}
+	_, st, ok := loadState(cfg)
+	if !ok {
+		return ExitConfig
+	}
+	failed := false
+	e, done := newEngine(cfg, st, &failed)
+	defer done()
+	ctx, stop := interruptContext()
+	defer stop()
+	records := e.FetchOnce(ctx)
+	if n := countFailed(records); n > 0 {
+		fmt.Fprintf(os.Stderr, "%d message(s) could not be processed, see the log\n", n)
+		failed = true
+	}
+	if failed {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// countFailed returns the number of records of messages that could not be processed.
+func countFailed(records []*history.Record) int {
+	n := 0
+	for _, r := range records {
+		if r.Outcome == history.OutcomeFailed {
+			n++
+		}
+	}
+	return n
+}
+
+func runTestConnection(args []string) int {
+	var configDir string
+	fs := flags("test-connection", &configDir)
+	if !parse(fs, args, &configDir) || fs.NArg() != 0 {
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok || !requireAccount(cfg) {
+		return ExitConfig
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	count, err := engine.NewSource(cfg, &state.State{}, nil).TestConnection(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Connection: "+err.Error())
+		return ExitFailure
+	}
+	fmt.Printf("Connected to %s as %s, mailbox holds %d messages\n", cfg.IMAPServer, cfg.IMAPUser, count)
+	return ExitOK
+}
+
+func runListFolders(args []string) int {
+	var configDir string
+	fs := flags("list-folders", &configDir)
+	if !parse(fs, args, &configDir) || fs.NArg() != 0 {
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok || !requireAccount(cfg) {
+		return ExitConfig
+	}
+	lister, ok := engine.NewSource(cfg, &state.State{}, nil).(source.FolderLister)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Folders are only available over IMAP")
+		return ExitFailure
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	folders, err := lister.ListFolders(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "IMAP: "+err.Error())
+		return ExitFailure
+	}
+	for _, name := range folders {
+		fmt.Println(name)
+	}
+	return ExitOK
+}
+
+func runResetState(args []string) int {
+	var configDir, uid string
+	fs := flags("reset-state", &configDir)
+	fs.StringVar(&uid, "uid", "", "last processed UID; messages with a higher UID are fetched on the next poll")
+	if !parse(fs, args, &configDir) || fs.NArg() != 0 {
+		return ExitUsage
+	}
+	n, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--uid must be a non-negative number")
+		fs.Usage()
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok {
+		return ExitConfig
+	}
+	path, st, ok := loadState(cfg)
+	if !ok {
+		return ExitConfig
+	}
+	old := st.LastUID
+	// Explicitly chosen start point: the first-run backfill must not override it
+	st.LastUID = uint32(n)
+	st.Initialized = true
+	if err := state.Save(path, st); err != nil {
+		fmt.Fprintln(os.Stderr, "Save state: "+err.Error())
+		return ExitFailure
+	}
+	fmt.Printf("Last UID of %s changed from %d to %d\n", st.Key(), old, n)
+	return ExitOK
+}
+
+func runExtract(args []string) int {
+	var configDir, out string
+	fs := flags("extract", &configDir)
+	fs.StringVar(&out, "out", "", "output folder (default: the configured output folder)")
+	if !parse(fs, args, &configDir) {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok {
+		return ExitConfig
+	}
+	if out != "" {
+		cfg.OutputFolder = out
+	}
+	if cfg.OutputFolder == "" {
+		fmt.Fprintln(os.Stderr, "Output folder is not set, use --out")
+		return ExitConfig
+	}
+
+	failed := false
+	e, done := newEngine(cfg, &state.State{}, &failed)
+	defer done()
+	ctx, stop := interruptContext()
+	defer stop()
+	for _, path := range fs.Args() {
+		// Offline extraction never touches the IMAP state
+		if _, err := e.ImportArchive(ctx, path); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// interruptContext returns a context cancelled by Ctrl+C or SIGTERM, so a one-shot command
+// closes its connection and leaves no half-written files behind.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"igcmailimap/config"
+	"igcmailimap/filter"
+)
+
+// maskedKeys are hidden in the full "config get" listing; asking for the key prints it.
+var maskedKeys = map[string]bool{"imap_password": true}
+
+func runConfig(args []string) int {
+	var configDir string
+	fs := flags("config", &configDir)
+	if !parse(fs, args, &configDir) {
+		return ExitUsage
+	}
+	rest := fs.Args()
+	switch {
+	case len(rest) == 1 && rest[0] == "get":
+		return configGetAll()
+	case len(rest) == 2 && rest[0] == "get":
+		return configGet(rest[1])
+	case len(rest) == 3 && rest[0] == "set":
+		return configSet(rest[1], rest[2])
+	}
+	fs.Usage()
+	fmt.Fprintln(os.Stderr, "Keys: "+strings.Join(configKeys(), ", "))
+	return ExitUsage
+}
+
+// configKeys returns the JSON names of the config fields, in declaration order.
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(config.Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// configField returns the config struct field with the given JSON name.
+func configField(key string) (reflect.StructField, bool) {
+	t := reflect.TypeOf(config.Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func configGetAll() int {
+	cfg, ok := loadConfig()
+	if !ok {
+		return ExitConfig
+	}
+	values, err := configValues(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return ExitFailure
+	}
+	for _, key := range configKeys() {
+		v := formatValue(values[key])
+		if maskedKeys[key] && v != "" {
+			v = "********"
+		}
+		fmt.Printf("%s = %s\n", key, v)
+	}
+	return ExitOK
+}
+
+func configGet(key string) int {
+	if _, ok := configField(key); !ok {
+		fmt.Fprintf(os.Stderr, "unknown key %q (keys: %s)\n", key, strings.Join(configKeys(), ", "))
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok {
+		return ExitConfig
+	}
+	values, err := configValues(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return ExitFailure
+	}
+	fmt.Println(formatValue(values[key]))
+	return ExitOK
+}
+
+// configSet changes one setting. Strings are taken literally, lists may be given as JSON or
+// comma-separated, anything else (numbers, booleans, filter_rules) must be JSON.
+func configSet(key, value string) int {
+	field, ok := configField(key)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown key %q (keys: %s)\n", key, strings.Join(configKeys(), ", "))
+		return ExitUsage
+	}
+	cfg, ok := loadConfig()
+	if !ok {
+		return ExitConfig
+	}
+
+	var raw json.RawMessage
+	switch {
+	case field.Type.Kind() == reflect.String:
+		raw, _ = json.Marshal(value)
+	case field.Type == reflect.TypeOf([]string(nil)) && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		raw, _ = json.Marshal(splitComma(value))
+	default:
+		if !json.Valid([]byte(value)) {
+			fmt.Fprintf(os.Stderr, "invalid value for %s: expected JSON such as 60, true or [\"a\", \"b\"]\n", key)
+			return ExitUsage
+		}
+		raw = json.RawMessage(value)
+	}
+	values, err := configValues(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return ExitFailure
+	}
+	values[key] = raw
+	data, err := json.Marshal(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value for %s: %v\n", key, err)
+		return ExitUsage
+	}
+	var updated config.Config
+	if err := json.Unmarshal(data, &updated); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value for %s: %v\n", key, err)
+		return ExitUsage
+	}
+	if err := validateConfig(&updated); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value for %s: %v\n", key, err)
+		return ExitUsage
+	}
+	if err := config.Save(&updated); err != nil {
+		fmt.Fprintln(os.Stderr, "Save config: "+err.Error())
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// validateConfig rejects settings the GUI would not let through.
+func validateConfig(c *config.Config) error {
+	if c.IntervalSec <= 0 {
+		return fmt.Errorf("interval must be a positive number of seconds")
+	}
+	if c.BackfillMode == config.BackfillSince {
+		if _, err := c.BackfillSinceDate(); err != nil {
+			return fmt.Errorf("backfill_since must be a %s date", config.DateFormat)
+		}
+	}
+	_, err := filter.New(c.FilterAllow, c.FilterDeny, c.FilterRules)
+	return err
+}
+
+// configValues returns the config as JSON values by key (omitted empty fields are missing).
+func configValues(cfg *config.Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	err = json.Unmarshal(data, &values)
+	return values, err
+}
+
+// formatValue prints strings without quotes and everything else as JSON.
+func formatValue(raw json.RawMessage) string {
+	if raw == nil {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func splitComma(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"igcmailimap/history"
+)
+
+func runHistory(args []string) int {
+	var configDir, outcome string
+	var limit int
+	var asCSV bool
+	fs := flags("history", &configDir)
+	fs.IntVar(&limit, "limit", 50, "number of records to show (0 = all)")
+	fs.StringVar(&outcome, "outcome", "", "only show records with this outcome (extracted, no_files, skipped, duplicate, failed)")
+	fs.BoolVar(&asCSV, "csv", false, "write CSV with all fields, for reporting")
+	if !parse(fs, args, &configDir) || fs.NArg() != 0 {
+		return ExitUsage
+	}
+	hist, ok := openHistory()
+	if !ok {
+		return ExitFailure
+	}
+	defer hist.Close()
+
+	// Filter before limiting, so --limit counts matching records
+	records, err := hist.List(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "History: "+err.Error())
+		return ExitFailure
+	}
+	var shown []history.Record
+	for _, r := range records {
+		if outcome != "" && r.Outcome != outcome {
+			continue
+		}
+		shown = append(shown, r)
+		if limit > 0 && len(shown) == limit {
+			break
+		}
+	}
+
+	if asCSV {
+		return writeHistoryCSV(shown)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPROCESSED\tOUTCOME\tACCOUNT\tUID\tFROM\tSUBJECT\tFILES")
+	for _, r := range shown {
+		uid := r.SourceID // POP3 and JMAP mail has no UID
+		if r.UID != 0 {
+			uid = strconv.FormatUint(uint64(r.UID), 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.ProcessedAt.Format("2006-01-02 15:04"),
+			r.Outcome, r.Account, uid, r.From, r.Subject, strings.Join(fileNames(r), " "))
+	}
+	tw.Flush()
+	return ExitOK
+}
+
+func writeHistoryCSV(records []history.Record) int {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"id", "processed_at", "server", "account", "folder", "uid", "source_id", "message_id", "date",
+		"from", "subject", "outcome", "reason", "files", "sha256", "errors"})
+	for _, r := range records {
+		var hashes []string
+		for _, f := range r.Files {
+			hashes = append(hashes, f.SHA256)
+		}
+		date := ""
+		if !r.Date.IsZero() {
+			date = r.Date.Format(time.RFC3339)
+		}
+		w.Write([]string{strconv.FormatUint(r.ID, 10), r.ProcessedAt.Format(time.RFC3339), r.Server, r.Account,
+			r.Folder, strconv.FormatUint(uint64(r.UID), 10), r.SourceID, r.MessageID, date, r.From, r.Subject, r.Outcome,
+			r.Reason, strings.Join(fileNames(r), ";"), strings.Join(hashes, ";"), strings.Join(r.Errors, ";")})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+func fileNames(r history.Record) []string {
+	names := make([]string, len(r.Files))
+	for i, f := range r.Files {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func runReprocess(args []string) int {
+	var configDir string
+	fs := flags("reprocess", &configDir)
+	if !parse(fs, args, &configDir) {
+		return ExitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	var ids []uint64
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid record ID %q\n", arg)
+			return ExitUsage
+		}
+		ids = append(ids, id)
+	}
+	cfg, ok := loadConfig()
+	if !ok || !requireAccount(cfg) {
+		return ExitConfig
+	}
+	_, st, ok := loadState(cfg)
+	if !ok {
+		return ExitConfig
+	}
+
+	failed := false
+	e, done := newEngine(cfg, st, &failed)
+	defer done()
+	ctx, stop := interruptContext()
+	defer stop()
+	n, err := e.Reprocess(ctx, ids)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Reprocess: "+err.Error())
+		return ExitFailure
+	}
+	fmt.Printf("Reprocessed %d messages\n", n)
+	if failed {
+		return ExitFailure
+	}
+	return ExitOK
+}
@@ -0,0 +1,35 @@
+// Command igcmailimapd is the headless poller: same engine as the desktop app, without
+// the Fyne GUI, so it builds and runs on servers without a display (e.g. a Raspberry Pi).
+// It also accepts the one-shot subcommands of package cli (fetch-once, extract, ...).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"igcmailimap/cli"
+	"igcmailimap/daemon"
+)
+
+func main() {
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
+	var opts daemon.Options
+	flag.StringVar(&opts.ConfigDir, "config-dir", "", "directory holding config.json and state.json (default: OS-specific)")
+	flag.StringVar(&opts.LogFile, "log-file", "", "also append logs to this file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]      run the poller until SIGINT/SIGTERM\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "   or: %s COMMAND [args]\n", os.Args[0])
+		cli.Usage(flag.CommandLine.Output())
+	}
+	flag.Parse()
+
+	if err := daemon.Run(opts); err != nil {
+		log.Fatal(err)
+	}
+}
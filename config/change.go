@@ -0,0 +1,21 @@
+package config
+
+// Change tells which groups of settings differ between two configs, so a running poller can
+// apply each group the way it needs instead of being restarted.
+type Change struct {
+	Interval bool // IntervalSec: the next poll is rescheduled
+	Account  bool // protocol, server or user (see StateKey): another mailbox, with a state of its own
+	Output   bool // OutputFolder: files extracted so far are recorded under the old folder
+	Logging  bool // logging settings or the folder the log is written to
+}
+
+// Diff returns the changes from old to cfg.
+func Diff(old, cfg *Config) Change {
+	return Change{
+		Interval: old.IntervalSec != cfg.IntervalSec,
+		Account:  old.StateKey() != cfg.StateKey(),
+		Output:   old.OutputFolder != cfg.OutputFolder,
+		Logging: old.LoggingEnabled != cfg.LoggingEnabled || old.LogOptions() != cfg.LogOptions() ||
+			old.LogFolder() != cfg.LogFolder(),
+	}
+}
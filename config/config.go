@@ -2,25 +2,139 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
+
+	"igcmailimap/filter"
+	"igcmailimap/logger"
 )
 
 const appName = "igcMailImap"
 
+// Backfill modes: what the first poll imports when there is no saved state yet.
+const (
+	BackfillNow   = "now"   // skip existing mail, only process mail arriving from now on
+	BackfillSince = "since" // process existing mail received on or after BackfillSince
+	BackfillAll   = "all"   // process every message in the mailbox
+)
+
+// Mail protocols (Config.Protocol).
+const (
+	ProtocolIMAP = "imap"
+	ProtocolPOP3 = "pop3"
+	ProtocolJMAP = "jmap"
+)
+
+// Connection security (Config.Security).
+const (
+	SecurityTLS      = "tls"      // implicit TLS (IMAPS 993, POP3S 995)
+	SecurityStartTLS = "starttls" // plain connection upgraded with STARTTLS/STLS (IMAP 143, POP3 110)
+)
+
+// DateFormat is the layout of date settings such as BackfillSince.
+const DateFormat = "2006-01-02"
+
 // Config holds application settings (saved to a single JSON file).
 type Config struct {
-	IMAPServer           string `json:"imap_server"` // host:port, e.g. "imap.gmail.com:993"
+	IMAPServer           string `json:"imap_server"` // host:port, e.g. "imap.gmail.com:993" (the POP3 server when Protocol is POP3)
 	IMAPUser             string `json:"imap_user"`
 	IMAPPassword         string `json:"imap_password"`
 	OutputFolder         string `json:"output_folder"`    // local folder for extracted IGC files
 	IntervalSec          int    `json:"interval_seconds"` // poll every N seconds
 	RunAtStartup         bool   `json:"run_at_startup"`
-	PollingEnabled       bool   `json:"polling_enabled"`       // if true, polling runs at launch and stays on until Stop
-	LoggingEnabled       bool   `json:"logging_enabled"`       // if true, logging is enabled
-	NotificationsEnabled bool   `json:"notifications_enabled"` // if true, desktop notifications are enabled
+	PollingEnabled       bool   `json:"polling_enabled"`             // if true, polling runs at launch and stays on until Stop
+	LoggingEnabled       bool   `json:"logging_enabled"`             // if true, logging is enabled
+	NotificationsEnabled bool   `json:"notifications_enabled"`       // if true, desktop notifications are enabled
+	LogLevel             string `json:"log_level,omitempty"`         // minimum level: "debug", "info" (default), "warn" or "error"
+	LogFormat            string `json:"log_format,omitempty"`        // "text" (default) or "json"
+	LogMaxSizeMB         int    `json:"log_max_size_mb,omitempty"`   // rotate igcmailimap.log above this size (default logger.DefaultMaxSizeMB)
+	LogMaxAgeDays        int    `json:"log_max_age_days,omitempty"`  // also rotate when the log is this many days old (0 = never)
+	LogKeep              int    `json:"log_keep,omitempty"`          // compressed archives kept (default logger.DefaultKeep)
+	LogInConfigDir       bool   `json:"log_in_config_dir,omitempty"` // if true, logs go to the config folder instead of OutputFolder
+
+	ConvertFormats  []string `json:"convert_formats,omitempty"`  // extra formats written next to each .igc ("gpx", "kml", "geojson")
+	AcceptedFormats []string `json:"accepted_formats,omitempty"` // attachment formats to extract (see extract.Formats); empty means IGC only
+	ConvertToIGC    bool     `json:"convert_to_igc,omitempty"`   // if true, non-IGC tracks are converted to .igc when saved
+
+	FilterAllow []string      `json:"filter_allow,omitempty"` // sender addresses/domains to accept (empty = everyone)
+	FilterDeny  []string      `json:"filter_deny,omitempty"`  // sender addresses/domains to ignore
+	FilterRules []filter.Rule `json:"filter_rules,omitempty"` // evaluated in order before extraction, see filter.Engine
+
+	PilotDirectory   string `json:"pilot_directory,omitempty"`   // CSV or JSON pilot directory; if set, files go to per-pilot folders
+	FilenameTemplate string `json:"filename_template,omitempty"` // save path template, see pilots.Route (default "{pilot}/{filename}")
+
+	Protocol   string `json:"protocol,omitempty"`    // ProtocolIMAP (default), ProtocolPOP3 or ProtocolJMAP
+	Security   string `json:"security,omitempty"`    // SecurityTLS (default) or SecurityStartTLS
+	POP3Delete bool   `json:"pop3_delete,omitempty"` // if true, POP3 messages are deleted from the server once processed
+
+	BackfillMode  string `json:"backfill_mode,omitempty"`  // first-run import: BackfillNow, BackfillSince or BackfillAll (default)
+	BackfillSince string `json:"backfill_since,omitempty"` // DateFormat date used with BackfillSince
+}
+
+// BackfillSinceDate parses BackfillSince as a local date.
+func (c *Config) BackfillSinceDate() (time.Time, error) {
+	return time.ParseInLocation(DateFormat, c.BackfillSince, time.Local)
+}
+
+// LogOptions returns the logger settings.
+func (c *Config) LogOptions() logger.Options {
+	return logger.Options{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		MaxSizeMB:  c.LogMaxSizeMB,
+		MaxAgeDays: c.LogMaxAgeDays,
+		Keep:       c.LogKeep,
+	}
+}
+
+// LogFolder returns the folder igcmailimap.log is written to: OutputFolder, or the config
+// folder when LogInConfigDir is set.
+func (c *Config) LogFolder() string {
+	if c.LogInConfigDir {
+		if dir, err := configDir(); err == nil {
+			return dir
+		}
+	}
+	return c.OutputFolder
+}
+
+// IsJMAP reports whether mail is fetched over JMAP; IMAPServer then holds the session URL or host.
+func (c *Config) IsJMAP() bool {
+	return c.Protocol == ProtocolJMAP
+}
+
+// IsPOP3 reports whether mail is fetched over POP3 instead of IMAP.
+func (c *Config) IsPOP3() bool {
+	return c.Protocol == ProtocolPOP3
+}
+
+// StateKey identifies the mailbox the saved state (last UID, failed messages...) belongs to:
+// protocol, user, server and folder, e.g. "imap://pilot@example.com@imap.example.com:993/INBOX".
+func (c *Config) StateKey() string {
+	protocol := c.Protocol
+	if protocol == "" {
+		protocol = ProtocolIMAP
+	}
+	return protocol + "://" + c.IMAPUser + "@" + c.IMAPServer + "/INBOX"
+}
+
+// WithDefaultPort appends the standard port for the protocol and security to a server given
+// without one ("mail.example.com" -> "mail.example.com:995").
+func WithDefaultPort(server, protocol, security string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil || server == "" {
+		return server
+	}
+	ports := map[string]string{
+		ProtocolIMAP + SecurityTLS: "993", ProtocolIMAP + SecurityStartTLS: "143",
+		ProtocolPOP3 + SecurityTLS: "995", ProtocolPOP3 + SecurityStartTLS: "110",
+	}
+	if security != SecurityStartTLS {
+		security = SecurityTLS
+	}
+	return net.JoinHostPort(server, ports[protocol+security])
 }
 
 // Default returns a config with sensible defaults (Gmail IMAP, 61s interval, polling off).
@@ -34,11 +148,24 @@ func Default() *Config {
 		PollingEnabled:       false,
 		LoggingEnabled:       false,
 		NotificationsEnabled: true,
+		BackfillMode:         BackfillAll,
 	}
 }
 
+// dirOverride replaces the OS-specific config directory when set with SetDir.
+var dirOverride string
+
+// SetDir makes config, state and related files live in dir instead of the OS-specific
+// default (used by the --config-dir flag of the headless daemon).
+func SetDir(dir string) {
+	dirOverride = dir
+}
+
 // configDir returns the directory for config and state files (OS-specific).
 func configDir() (string, error) {
+	if dirOverride != "" {
+		return dirOverride, nil
+	}
 	switch runtime.GOOS {
 	case "darwin":
 		home, err := os.UserHomeDir()
@@ -53,8 +180,12 @@ func configDir() (string, error) {
 		}
 		return filepath.Dir(exe), nil
 	default:
-		// Unsupported platform
-		return "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+		// Linux and other Unix systems: $XDG_CONFIG_HOME or ~/.config
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, appName), nil
 	}
 }
 
@@ -67,6 +198,16 @@ func ConfigPath() (string, error) {
 	return filepath.Join(dir, "config.json"), nil
 }
 
+// Exists reports whether a config file has been saved yet (false on first run).
+func Exists() bool {
+	path, err := ConfigPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
 // StatePath returns the path to the state file (last UID for incremental fetch).
 func StatePath() (string, error) {
 	dir, err := configDir()
@@ -76,6 +217,24 @@ func StatePath() (string, error) {
 	return filepath.Join(dir, "state.json"), nil
 }
 
+// PilotsPath returns the default path for the pilot directory file.
+func PilotsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pilots.csv"), nil
+}
+
+// HistoryPath returns the path to the processed-message history database.
+func HistoryPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.db"), nil
+}
+
 // Load reads config from the JSON file. If the file does not exist, returns Default() and nil error.
 func Load() (*Config, error) {
 	path, err := ConfigPath()
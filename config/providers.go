@@ -0,0 +1,79 @@
+package config
+
+// Provider holds the account settings of a mail provider, used to pre-fill the setup wizard.
+type Provider struct {
+	Name     string
+	Server   string // host:port; empty for a generic provider
+	Protocol string
+	Security string
+	UserHint string // placeholder for the user name
+	Help     string // how to sign in, in particular with an app password
+	HelpURL  string // page where app passwords are created, if any
+}
+
+// GenericProvider is the name of the provider without presets.
+const GenericProvider = "Other (generic IMAP, POP3 or JMAP)"
+
+// Providers lists the presets offered by the setup wizard; the generic entry comes last.
+var Providers = []Provider{
+	{
+		Name: "Gmail", Server: "imap.gmail.com:993", Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "you@gmail.com",
+		Help: "Gmail does not accept your normal password over IMAP. Turn on 2-Step Verification, " +
+			"then create an app password (16 letters) and enter it here.",
+		HelpURL: "https://myaccount.google.com/apppasswords",
+	},
+	{
+		Name: "Outlook / Microsoft 365", Server: "outlook.office365.com:993", Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "you@outlook.com",
+		Help: "Microsoft only allows password sign-in over IMAP where it has not been switched off. " +
+			"With two-step verification use an app password; work and school accounts need IMAP " +
+			"and basic authentication enabled by the administrator.",
+		HelpURL: "https://account.live.com/proofs/AppPassword",
+	},
+	{
+		Name: "Yahoo", Server: "imap.mail.yahoo.com:993", Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "you@yahoo.com",
+		Help:     "Yahoo requires an app password: Account security > Generate app password.",
+		HelpURL:  "https://login.yahoo.com/account/security",
+	},
+	{
+		Name: "iCloud", Server: "imap.mail.me.com:993", Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "you@icloud.com",
+		Help: "iCloud requires an app-specific password, created under Sign-In and Security on your " +
+			"Apple Account page. The user is your iCloud Mail address.",
+		HelpURL: "https://account.apple.com",
+	},
+	{
+		Name: "GMX", Server: "imap.gmx.net:993", Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "you@gmx.net",
+		Help: "Allow POP3 and IMAP access first in the GMX mail settings (POP3 & IMAP). " +
+			"With two-factor authentication, use an application-specific password.",
+	},
+	{
+		Name: GenericProvider, Protocol: ProtocolIMAP, Security: SecurityTLS,
+		UserHint: "user@example.com",
+		Help: "Enter the server from your provider's mail client settings. Providers with two-step " +
+			"verification usually require an app password instead of your normal password.",
+	},
+}
+
+// ProviderByName returns the preset with the given name, or the generic one.
+func ProviderByName(name string) Provider {
+	for _, p := range Providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return Providers[len(Providers)-1]
+}
+
+// ProviderForServer returns the preset whose server matches, or the generic one.
+func ProviderForServer(server string) Provider {
+	for _, p := range Providers {
+		if p.Server != "" && p.Server == server {
+			return p
+		}
+	}
+	return Providers[len(Providers)-1]
+}
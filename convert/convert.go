@@ -0,0 +1,332 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+// Supported output formats (also used as file extensions without the dot).
+const (
+	GPX     = "gpx"
+	KML     = "kml"
+	GeoJSON = "geojson"
+)
+
+// Formats lists every output format in the order they are offered in the UI.
+var Formats = []string{GPX, KML, GeoJSON}
+
+// ConvertFile parses the IGC file at igcPath and writes one sibling file per requested format
+// (e.g. flight.igc -> flight.gpx). Returns the paths written.
+func ConvertFile(igcPath string, formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		return nil, nil
+	}
+	fl, err := igc.ParseFile(igcPath)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(igcPath, filepath.Ext(igcPath))
+	var written []string
+	for _, format := range formats {
+		path := base + "." + format
+		if err := writeFile(path, format, fl); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// Write encodes the flight in the given format.
+func Write(w io.Writer, format string, fl *igc.Flight) error {
+	switch strings.ToLower(format) {
+	case GPX:
+		return WriteGPX(w, fl)
+	case KML:
+		return WriteKML(w, fl)
+	case GeoJSON:
+		return WriteGeoJSON(w, fl)
+	default:
+		return fmt.Errorf("convert: unknown format %q", format)
+	}
+}
+
+// writeFile writes through a temporary file renamed into place, so an interrupted conversion
+// never leaves a partial file.
+func writeFile(path, format string, fl *igc.Flight) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = Write(bw, format, fl)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// flightName returns a human-readable name for the track (pilot and date when known).
+func flightName(fl *igc.Flight) string {
+	var parts []string
+	if fl.Pilot != "" {
+		parts = append(parts, fl.Pilot)
+	}
+	if !fl.Date.IsZero() {
+		parts = append(parts, fl.Date.Format("2006-01-02"))
+	}
+	if len(parts) == 0 {
+		return "Flight"
+	}
+	return strings.Join(parts, " ")
+}
+
+// --- GPX ---
+
+type gpxDoc struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Rte     []gpxRoute `xml:"rte,omitempty"`
+	Trk     gpxTrack   `xml:"trk"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	Name string     `xml:"name"`
+	Seg  gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  *int    `xml:"ele,omitempty"`
+	Time string  `xml:"time,omitempty"`
+	Name string  `xml:"name,omitempty"`
+}
+
+// WriteGPX writes the track as a GPX 1.1 track, with the declared task as a separate route.
+func WriteGPX(w io.Writer, fl *igc.Flight) error {
+	doc := gpxDoc{
+		Version: "1.1",
+		Creator: "igcmailimap",
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Trk:     gpxTrack{Name: flightName(fl)},
+	}
+	hasTime := !fl.Date.IsZero()
+	for _, fix := range fl.Fixes {
+		ele := fix.Altitude()
+		p := gpxPoint{Lat: fix.Lat, Lon: fix.Lon, Ele: &ele}
+		if hasTime {
+			p.Time = fix.Time.Format(time.RFC3339)
+		}
+		doc.Trk.Seg.Points = append(doc.Trk.Seg.Points, p)
+	}
+	if len(fl.Task) > 0 {
+		rte := gpxRoute{Name: "Task"}
+		for _, wp := range fl.Task {
+			rte.Points = append(rte.Points, gpxPoint{Lat: wp.Lat, Lon: wp.Lon, Name: wp.Name})
+		}
+		doc.Rte = append(doc.Rte, rte)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// --- KML ---
+
+type kmlDoc struct {
+	XMLName xml.Name    `xml:"kml"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	XmlnsGx string      `xml:"xmlns:gx,attr"`
+	Doc     kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name    string      `xml:"name"`
+	Folders []kmlFolder `xml:"Folder"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name       string         `xml:"name"`
+	Track      *kmlTrack      `xml:"gx:Track,omitempty"`
+	LineString *kmlLineString `xml:"LineString,omitempty"`
+	Point      *kmlPoint      `xml:"Point,omitempty"`
+}
+
+type kmlTrack struct {
+	AltitudeMode string   `xml:"altitudeMode"`
+	When         []string `xml:"when"`
+	Coord        []string `xml:"gx:coord"`
+}
+
+type kmlLineString struct {
+	AltitudeMode string `xml:"altitudeMode,omitempty"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// WriteKML writes the track as a KML gx:Track (or a LineString when the date is unknown),
+// with the declared task in its own folder.
+func WriteKML(w io.Writer, fl *igc.Flight) error {
+	trackPM := kmlPlacemark{Name: flightName(fl)}
+	if !fl.Date.IsZero() {
+		tr := &kmlTrack{AltitudeMode: "absolute"}
+		for _, fix := range fl.Fixes {
+			tr.When = append(tr.When, fix.Time.Format(time.RFC3339))
+			tr.Coord = append(tr.Coord, fmt.Sprintf("%.6f %.6f %d", fix.Lon, fix.Lat, fix.Altitude()))
+		}
+		trackPM.Track = tr
+	} else {
+		coords := make([]string, len(fl.Fixes))
+		for i, fix := range fl.Fixes {
+			coords[i] = fmt.Sprintf("%.6f,%.6f,%d", fix.Lon, fix.Lat, fix.Altitude())
+		}
+		trackPM.LineString = &kmlLineString{AltitudeMode: "absolute", Coordinates: strings.Join(coords, " ")}
+	}
+
+	doc := kmlDoc{
+		Xmlns:   "http://www.opengis.net/kml/2.2",
+		XmlnsGx: "http://www.google.com/kml/ext/2.2",
+		Doc: kmlDocument{
+			Name:    flightName(fl),
+			Folders: []kmlFolder{{Name: "Track", Placemarks: []kmlPlacemark{trackPM}}},
+		},
+	}
+	if len(fl.Task) > 0 {
+		task := kmlFolder{Name: "Task"}
+		coords := make([]string, len(fl.Task))
+		for i, wp := range fl.Task {
+			c := fmt.Sprintf("%.6f,%.6f", wp.Lon, wp.Lat)
+			coords[i] = c
+			task.Placemarks = append(task.Placemarks, kmlPlacemark{Name: wp.Name, Point: &kmlPoint{Coordinates: c}})
+		}
+		task.Placemarks = append(task.Placemarks, kmlPlacemark{
+			Name:       "Task",
+			LineString: &kmlLineString{Coordinates: strings.Join(coords, " ")},
+		})
+		doc.Doc.Folders = append(doc.Doc.Folders, task)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// --- GeoJSON ---
+
+type geoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoGeometry            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// WriteGeoJSON writes a FeatureCollection: the track as a LineString (with per-point times in
+// "coordTimes"), and the task as a LineString plus one Point per turnpoint, tagged layer "task".
+func WriteGeoJSON(w io.Writer, fl *igc.Flight) error {
+	coords := make([][3]float64, len(fl.Fixes))
+	var times []string
+	for i, fix := range fl.Fixes {
+		coords[i] = [3]float64{fix.Lon, fix.Lat, float64(fix.Altitude())}
+		if !fl.Date.IsZero() {
+			times = append(times, fix.Time.Format(time.RFC3339))
+		}
+	}
+	props := map[string]interface{}{
+		"layer": "track",
+		"name":  flightName(fl),
+	}
+	if times != nil {
+		props["coordTimes"] = times
+	}
+	if fl.Pilot != "" {
+		props["pilot"] = fl.Pilot
+	}
+	if fl.GliderType != "" {
+		props["gliderType"] = fl.GliderType
+	}
+	if fl.GliderID != "" {
+		props["gliderId"] = fl.GliderID
+	}
+	fc := geoFeatureCollection{
+		Type: "FeatureCollection",
+		Features: []geoFeature{{
+			Type:       "Feature",
+			Geometry:   geoGeometry{Type: "LineString", Coordinates: coords},
+			Properties: props,
+		}},
+	}
+	if len(fl.Task) > 0 {
+		line := make([][2]float64, len(fl.Task))
+		for i, wp := range fl.Task {
+			line[i] = [2]float64{wp.Lon, wp.Lat}
+			fc.Features = append(fc.Features, geoFeature{
+				Type:       "Feature",
+				Geometry:   geoGeometry{Type: "Point", Coordinates: line[i]},
+				Properties: map[string]interface{}{"layer": "task", "name": wp.Name, "index": i},
+			})
+		}
+		fc.Features = append(fc.Features, geoFeature{
+			Type:       "Feature",
+			Geometry:   geoGeometry{Type: "LineString", Coordinates: line},
+			Properties: map[string]interface{}{"layer": "task", "name": "Task"},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
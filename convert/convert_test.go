@@ -0,0 +1,152 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+func testFlight() *igc.Flight {
+	start := time.Date(2023, 7, 15, 11, 0, 0, 0, time.UTC)
+	return &igc.Flight{
+		Date:       time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC),
+		Pilot:      "Jane Doe",
+		GliderType: "Ozone Rush",
+		Fixes: []igc.Fix{
+			{Time: start, Lat: 47.5, Lon: 8.5, Valid: true, PressureAlt: 1000, GNSSAlt: 1050},
+			{Time: start.Add(time.Minute), Lat: 47.6, Lon: 8.6, Valid: true, PressureAlt: 1200},
+		},
+		Task: []igc.Waypoint{{Lat: 47.5, Lon: 8.5, Name: "TP1"}, {Lat: 47.7, Lon: 8.7, Name: "TP2"}},
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "GPX", testFlight()); err != nil {
+		t.Fatal(err)
+	}
+	var doc gpxDoc
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GPX: %v\n%s", err, buf.String())
+	}
+	if doc.Trk.Name != "Jane Doe 2023-07-15" || len(doc.Trk.Seg.Points) != 2 {
+		t.Fatalf("track = %+v", doc.Trk)
+	}
+	p := doc.Trk.Seg.Points[1]
+	if p.Lat != 47.6 || p.Lon != 8.6 || *p.Ele != 1200 || p.Time != "2023-07-15T11:01:00Z" {
+		t.Errorf("second point = %+v (ele %d)", p, *p.Ele)
+	}
+	if len(doc.Rte) != 1 || len(doc.Rte[0].Points) != 2 || doc.Rte[0].Points[1].Name != "TP2" {
+		t.Errorf("task route = %+v", doc.Rte)
+	}
+}
+
+func TestWriteKML(t *testing.T) {
+	tests := []struct {
+		name    string
+		undated bool
+		want    []string
+	}{
+		{"timed track", false, []string{"<gx:Track>", "<when>2023-07-15T11:00:00Z</when>", "<gx:coord>8.500000 47.500000 1050</gx:coord>", "<name>TP2</name>"}},
+		{"no date", true, []string{"<LineString>", "8.500000,47.500000,1050 8.600000,47.600000,1200"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fl := testFlight()
+			if tt.undated {
+				fl.Date = time.Time{}
+			}
+			var buf bytes.Buffer
+			if err := Write(&buf, KML, fl); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(buf.String(), s) {
+					t.Errorf("KML lacks %q:\n%s", s, buf.String())
+				}
+			}
+			if tt.undated && strings.Contains(buf.String(), "<when>") {
+				t.Error("undated track has timestamps")
+			}
+		})
+	}
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, GeoJSON, testFlight()); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid GeoJSON: %v", err)
+	}
+	// Track, one point per turnpoint, task line
+	var kinds []string
+	for _, f := range fc.Features {
+		kinds = append(kinds, f.Properties["layer"].(string)+" "+f.Geometry.Type)
+	}
+	if want := []string{"track LineString", "task Point", "task Point", "task LineString"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("features = %q, want %q", kinds, want)
+	}
+	track := fc.Features[0]
+	var coords [][3]float64
+	if err := json.Unmarshal(track.Geometry.Coordinates, &coords); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][3]float64{{8.5, 47.5, 1050}, {8.6, 47.6, 1200}}; !reflect.DeepEqual(coords, want) {
+		t.Errorf("track coordinates = %v, want %v", coords, want)
+	}
+	if track.Properties["pilot"] != "Jane Doe" || len(track.Properties["coordTimes"].([]interface{})) != 2 {
+		t.Errorf("track properties = %v", track.Properties)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, "shp", testFlight()); err == nil {
+		t.Error("Write accepted an unknown format")
+	}
+}
+
+func TestConvertFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flight.igc")
+	var buf bytes.Buffer
+	if err := igc.Write(&buf, testFlight()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written, err := ConvertFile(path, []string{GPX, GeoJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "flight.gpx"), filepath.Join(dir, "flight.geojson")}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("written %q, want %q", written, want)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("%d files in the folder, want the IGC and two conversions (no leftover parts)", len(entries))
+	}
+	if written, err := ConvertFile(path, nil); err != nil || written != nil {
+		t.Errorf("no formats: %q, %v", written, err)
+	}
+}
@@ -0,0 +1,310 @@
+package convert
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+// ReadGPX reads the track points of a GPX file (all tracks and segments, in order).
+func ReadGPX(r io.Reader) (*igc.Flight, error) {
+	var doc struct {
+		Trk []struct {
+			Seg []struct {
+				Points []struct {
+					Lat  float64 `xml:"lat,attr"`
+					Lon  float64 `xml:"lon,attr"`
+					Ele  float64 `xml:"ele"`
+					Time string  `xml:"time"`
+				} `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("gpx: %w", err)
+	}
+	fl := &igc.Flight{}
+	for _, trk := range doc.Trk {
+		for _, seg := range trk.Seg {
+			for _, p := range seg.Points {
+				t, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+				if err != nil {
+					return nil, fmt.Errorf("gpx: track point without valid time: %w", err)
+				}
+				fl.Fixes = append(fl.Fixes, newFix(t, p.Lat, p.Lon, p.Ele))
+			}
+		}
+	}
+	return finishFlight(fl, "gpx")
+}
+
+// ReadKML reads a KML track. Only gx:Track elements carry timestamps, so a plain LineString is rejected.
+func ReadKML(r io.Reader) (*igc.Flight, error) {
+	dec := xml.NewDecoder(r)
+	var (
+		whens  []string
+		coords []string
+		text   strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "when":
+				whens = append(whens, strings.TrimSpace(text.String()))
+			case "coord":
+				coords = append(coords, strings.TrimSpace(text.String()))
+			}
+		}
+	}
+	if len(coords) == 0 {
+		return nil, fmt.Errorf("kml: no timed track (gx:Track) found")
+	}
+	if len(whens) != len(coords) {
+		return nil, fmt.Errorf("kml: %d timestamps for %d coordinates", len(whens), len(coords))
+	}
+	fl := &igc.Flight{}
+	for i, c := range coords {
+		t, err := time.Parse(time.RFC3339, whens[i])
+		if err != nil {
+			return nil, fmt.Errorf("kml: %w", err)
+		}
+		parts := strings.Fields(c)
+		if len(parts) < 2 {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(parts[0], 64)
+		lat, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		var ele float64
+		if len(parts) > 2 {
+			ele, _ = strconv.ParseFloat(parts[2], 64)
+		}
+		fl.Fixes = append(fl.Fixes, newFix(t, lat, lon, ele))
+	}
+	return finishFlight(fl, "kml")
+}
+
+// fitEpoch is the FIT timestamp origin (1989-12-31T00:00:00Z).
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+const (
+	fitMesgRecord      = 20
+	fitFieldTimestamp  = 253
+	fitFieldLat        = 0
+	fitFieldLon        = 1
+	fitFieldAltitude   = 2
+	fitFieldEnhAlt     = 78
+	fitInvalidSint32   = 0x7FFFFFFF
+	fitInvalidUint16   = 0xFFFF
+	fitInvalidUint32   = 0xFFFFFFFF
+	fitSemicircleToDeg = 180.0 / (1 << 31)
+)
+
+type fitField struct {
+	num  byte
+	size int
+}
+
+type fitDefinition struct {
+	global    uint16
+	bigEndian bool
+	fields    []fitField
+	devSize   int // total size of developer fields, skipped
+}
+
+// ReadFIT reads the record messages (position, altitude, time) of a Garmin FIT activity file.
+func ReadFIT(r io.Reader) (*igc.Flight, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[8:12]) != ".FIT" {
+		return nil, fmt.Errorf("fit: not a FIT file")
+	}
+	headerSize := int(data[0])
+	dataSize := int(uint32(data[4]) | uint32(data[5])<<8 | uint32(data[6])<<16 | uint32(data[7])<<24)
+	end := headerSize + dataSize
+	if headerSize < 12 || end > len(data) {
+		return nil, fmt.Errorf("fit: truncated file")
+	}
+
+	defs := make(map[byte]*fitDefinition)
+	fl := &igc.Flight{}
+	var lastTS uint32
+	pos := headerSize
+	for pos < end {
+		hdr := data[pos]
+		pos++
+		var (
+			local      byte
+			compressed bool
+			offset     uint32
+		)
+		switch {
+		case hdr&0x80 != 0: // compressed timestamp header
+			compressed = true
+			local = (hdr >> 5) & 0x03
+			offset = uint32(hdr & 0x1F)
+		case hdr&0x40 != 0: // definition message
+			if pos+5 > end {
+				return nil, fmt.Errorf("fit: truncated definition")
+			}
+			def := &fitDefinition{bigEndian: data[pos+1] == 1}
+			if def.bigEndian {
+				def.global = uint16(data[pos+2])<<8 | uint16(data[pos+3])
+			} else {
+				def.global = uint16(data[pos+2]) | uint16(data[pos+3])<<8
+			}
+			n := int(data[pos+4])
+			pos += 5
+			if pos+3*n > end {
+				return nil, fmt.Errorf("fit: truncated definition")
+			}
+			for i := 0; i < n; i++ {
+				def.fields = append(def.fields, fitField{num: data[pos], size: int(data[pos+1])})
+				pos += 3
+			}
+			if hdr&0x20 != 0 { // developer data fields
+				if pos >= end {
+					return nil, fmt.Errorf("fit: truncated definition")
+				}
+				nd := int(data[pos])
+				pos++
+				if pos+3*nd > end {
+					return nil, fmt.Errorf("fit: truncated definition")
+				}
+				for i := 0; i < nd; i++ {
+					def.devSize += int(data[pos+1])
+					pos += 3
+				}
+			}
+			defs[hdr&0x0F] = def
+			continue
+		default:
+			local = hdr & 0x0F
+		}
+
+		def, ok := defs[local]
+		if !ok {
+			return nil, fmt.Errorf("fit: data message without definition")
+		}
+		var (
+			ts       uint32
+			hasTS    bool
+			lat, lon int32 = fitInvalidSint32, fitInvalidSint32
+			alt            = math.NaN()
+		)
+		for _, f := range def.fields {
+			if pos+f.size > end {
+				return nil, fmt.Errorf("fit: truncated data message")
+			}
+			raw := data[pos : pos+f.size]
+			pos += f.size
+			if def.global != fitMesgRecord {
+				continue
+			}
+			v, ok := fitUint(raw, def.bigEndian)
+			if !ok {
+				continue
+			}
+			switch f.num {
+			case fitFieldTimestamp:
+				ts, hasTS = uint32(v), true
+			case fitFieldLat:
+				lat = int32(uint32(v))
+			case fitFieldLon:
+				lon = int32(uint32(v))
+			case fitFieldAltitude:
+				if v != fitInvalidUint16 && math.IsNaN(alt) {
+					alt = float64(v)/5 - 500
+				}
+			case fitFieldEnhAlt:
+				if v != fitInvalidUint32 {
+					alt = float64(v)/5 - 500
+				}
+			}
+		}
+		pos += def.devSize
+
+		if compressed {
+			ts = (lastTS &^ 0x1F) + offset
+			if offset < lastTS&0x1F {
+				ts += 0x20
+			}
+			hasTS = true
+		}
+		if hasTS {
+			lastTS = ts
+		}
+		if def.global != fitMesgRecord || !hasTS || lat == fitInvalidSint32 || lon == fitInvalidSint32 {
+			continue
+		}
+		if math.IsNaN(alt) {
+			alt = 0
+		}
+		t := fitEpoch.Add(time.Duration(ts) * time.Second)
+		fl.Fixes = append(fl.Fixes, newFix(t, float64(lat)*fitSemicircleToDeg, float64(lon)*fitSemicircleToDeg, alt))
+	}
+	return finishFlight(fl, "fit")
+}
+
+// fitUint decodes a 1, 2 or 4 byte field as an unsigned integer.
+func fitUint(b []byte, bigEndian bool) (uint64, bool) {
+	switch len(b) {
+	case 1:
+		return uint64(b[0]), true
+	case 2:
+		if bigEndian {
+			return uint64(b[0])<<8 | uint64(b[1]), true
+		}
+		return uint64(b[0]) | uint64(b[1])<<8, true
+	case 4:
+		if bigEndian {
+			return uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3]), true
+		}
+		return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24, true
+	}
+	return 0, false
+}
+
+func newFix(t time.Time, lat, lon, ele float64) igc.Fix {
+	return igc.Fix{
+		Time:    t.UTC(),
+		Lat:     lat,
+		Lon:     lon,
+		Valid:   true,
+		GNSSAlt: int(math.Round(ele)),
+	}
+}
+
+// finishFlight sorts fixes by time and sets the flight date from the first fix.
+func finishFlight(fl *igc.Flight, format string) (*igc.Flight, error) {
+	if len(fl.Fixes) == 0 {
+		return nil, fmt.Errorf("%s: no track points found", format)
+	}
+	sort.SliceStable(fl.Fixes, func(i, j int) bool { return fl.Fixes[i].Time.Before(fl.Fixes[j].Time) })
+	first := fl.Fixes[0].Time
+	fl.Date = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	return fl, nil
+}
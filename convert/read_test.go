@@ -0,0 +1,213 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+// point is the part of a fix the readers fill in.
+type point struct {
+	time     string
+	lat, lon float64
+	alt      int
+}
+
+func checkPoints(t *testing.T, got []point, want []point) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d points, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.time != w.time || math.Abs(g.lat-w.lat) > 1e-6 || math.Abs(g.lon-w.lon) > 1e-6 || g.alt != w.alt {
+			t.Errorf("point %d = %v, want %v", i, g, w)
+		}
+	}
+}
+
+func TestReadGPX(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    []point
+		wantErr bool
+	}{
+		{
+			name: "segments in order, sorted by time",
+			doc: `<?xml version="1.0"?><gpx version="1.1"><trk>
+				<trkseg><trkpt lat="47.6" lon="8.6"><ele>1200.4</ele><time>2023-07-15T11:01:00Z</time></trkpt></trkseg>
+				<trkseg><trkpt lat="47.5" lon="8.5"><ele>1050</ele><time>2023-07-15T13:00:00+02:00</time></trkpt></trkseg>
+				</trk></gpx>`,
+			want: []point{{"2023-07-15T11:00:00Z", 47.5, 8.5, 1050}, {"2023-07-15T11:01:00Z", 47.6, 8.6, 1200}},
+		},
+		{name: "point without time", doc: `<gpx><trk><trkseg><trkpt lat="1" lon="2"></trkpt></trkseg></trk></gpx>`, wantErr: true},
+		{name: "no track", doc: `<gpx><wpt lat="1" lon="2"/></gpx>`, wantErr: true},
+		{name: "not XML", doc: `flight`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := ReadGPX(strings.NewReader(tt.doc))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ReadGPX succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPoints(t, points(fl.Fixes), tt.want)
+			if want := time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC); !fl.Date.Equal(want) {
+				t.Errorf("Date = %v", fl.Date)
+			}
+		})
+	}
+}
+
+func TestReadKML(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    []point
+		wantErr bool
+	}{
+		{
+			name: "gx:Track",
+			doc: `<kml xmlns:gx="http://www.google.com/kml/ext/2.2"><Placemark><gx:Track>
+				<when>2023-07-15T11:00:00Z</when><when>2023-07-15T11:01:00Z</when>
+				<gx:coord>8.5 47.5 1050</gx:coord><gx:coord>8.6 47.6</gx:coord>
+				</gx:Track></Placemark></kml>`,
+			want: []point{{"2023-07-15T11:00:00Z", 47.5, 8.5, 1050}, {"2023-07-15T11:01:00Z", 47.6, 8.6, 0}},
+		},
+		{
+			name:    "LineString has no times",
+			doc:     `<kml><Placemark><LineString><coordinates>8.5,47.5,1050 8.6,47.6,1200</coordinates></LineString></Placemark></kml>`,
+			wantErr: true,
+		},
+		{
+			name:    "timestamps and coordinates differ",
+			doc:     `<kml><gx:Track><when>2023-07-15T11:00:00Z</when><gx:coord>8.5 47.5 1</gx:coord><gx:coord>8.6 47.6 1</gx:coord></gx:Track></kml>`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			doc:     `<kml><gx:Track><when>yesterday</when><gx:coord>8.5 47.5 1</gx:coord></gx:Track></kml>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fl, err := ReadKML(strings.NewReader(tt.doc))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("ReadKML succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPoints(t, points(fl.Fixes), tt.want)
+		})
+	}
+}
+
+func TestReadKMLRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteKML(&buf, testFlight()); err != nil {
+		t.Fatal(err)
+	}
+	fl, err := ReadKML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, points(fl.Fixes), []point{{"2023-07-15T11:00:00Z", 47.5, 8.5, 1050}, {"2023-07-15T11:01:00Z", 47.6, 8.6, 1200}})
+}
+
+// fitFile assembles a FIT file from its data records (header and CRC are added).
+func fitFile(records ...[]byte) []byte {
+	body := bytes.Join(records, nil)
+	header := []byte{12, 0x10, 0, 0, 0, 0, 0, 0, '.', 'F', 'I', 'T'}
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(body)))
+	return append(append(header, body...), 0, 0)
+}
+
+func fitDef(local byte, global uint16, fields ...[2]byte) []byte {
+	b := []byte{0x40 | local, 0, 0, 0, 0, byte(len(fields))}
+	binary.LittleEndian.PutUint16(b[3:5], global)
+	for _, f := range fields {
+		b = append(b, f[0], f[1], 0)
+	}
+	return b
+}
+
+func fitData(header byte, values ...interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(header)
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func semicircles(deg float64) int32 {
+	return int32(math.Round(deg / fitSemicircleToDeg))
+}
+
+func TestReadFIT(t *testing.T) {
+	ts := uint32(time.Date(2023, 7, 15, 11, 0, 0, 0, time.UTC).Sub(fitEpoch) / time.Second)
+	data := fitFile(
+		// file_id message: skipped
+		fitDef(2, 0, [2]byte{0, 1}),
+		fitData(0x02, uint8(4)),
+		// record with timestamp, position, altitude and enhanced altitude (which wins)
+		fitDef(0, fitMesgRecord, [2]byte{fitFieldTimestamp, 4}, [2]byte{fitFieldLat, 4}, [2]byte{fitFieldLon, 4},
+			[2]byte{fitFieldAltitude, 2}, [2]byte{fitFieldEnhAlt, 4}),
+		fitData(0x00, ts, semicircles(47.5), semicircles(8.5), uint16((900+500)*5), uint32((1050+500)*5)),
+		// record without position: skipped
+		fitData(0x00, ts+1, int32(fitInvalidSint32), int32(fitInvalidSint32), uint16(fitInvalidUint16), uint32(fitInvalidUint32)),
+		// compressed timestamp header, 3 seconds after the last full timestamp
+		fitDef(1, fitMesgRecord, [2]byte{fitFieldLat, 4}, [2]byte{fitFieldLon, 4}, [2]byte{fitFieldAltitude, 2}),
+		fitData(0x80|1<<5|byte((ts+4)&0x1F), semicircles(-47.6), semicircles(-8.6), uint16((1200+500)*5)),
+	)
+	fl, err := ReadFIT(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, points(fl.Fixes), []point{{"2023-07-15T11:00:00Z", 47.5, 8.5, 1050}, {"2023-07-15T11:00:04Z", -47.6, -8.6, 1200}})
+}
+
+func TestReadFITInvalid(t *testing.T) {
+	valid := fitFile(fitDef(0, fitMesgRecord, [2]byte{fitFieldLat, 4}), fitData(0x00, int32(1)))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not FIT", []byte("AXCT001\r\nHFDTE150723\r\n")},
+		{"truncated", valid[:len(valid)-6]},
+		{"data before definition", fitFile(fitData(0x00, int32(1)))},
+		{"no records", fitFile(fitDef(0, 0, [2]byte{0, 1}), fitData(0x00, uint8(4)))},
+		{"records without time", valid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadFIT(bytes.NewReader(tt.data)); err == nil {
+				t.Error("ReadFIT succeeded, want an error")
+			}
+		})
+	}
+}
+
+func points(fixes []igc.Fix) []point {
+	var out []point
+	for _, f := range fixes {
+		out = append(out, point{f.Time.Format(time.RFC3339), f.Lat, f.Lon, f.Altitude()})
+	}
+	return out
+}
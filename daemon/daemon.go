@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"igcmailimap/config"
+	"igcmailimap/engine"
+	"igcmailimap/history"
+	"igcmailimap/logger"
+	"igcmailimap/state"
+)
+
+// shutdownTimeout bounds how long Run waits for an in-flight fetch after a signal.
+const shutdownTimeout = 30 * time.Second
+
+// Options configures the headless daemon.
+type Options struct {
+	ConfigDir string // overrides the OS-specific config directory (optional)
+	LogFile   string // also append logs to this file, rotated like igcmailimap.log (optional); logs always go to stdout
+}
+
+// Run loads config and state, runs the poll loop without any GUI until SIGINT or SIGTERM,
+// then stops polling and returns.
+func Run(opts Options) error {
+	if opts.ConfigDir != "" {
+		config.SetDir(opts.ConfigDir)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if cfg.IMAPServer == "" || (cfg.IMAPUser == "" && !cfg.IsJMAP()) || cfg.IMAPPassword == "" || cfg.OutputFolder == "" {
+		path, _ := config.ConfigPath()
+		return fmt.Errorf("server, user, password and output folder must be set in %s", path)
+	}
+	statePath, err := config.StatePath()
+	if err != nil {
+		return err
+	}
+	st, err := state.Load(statePath, cfg.StateKey())
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.LogFile != "" {
+		f, err := logger.OpenFile(opts.LogFile, cfg.LogOptions())
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+	log := logger.NewWriter(out, cfg.LogOptions())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	e := engine.New(cfg, st, log, engine.Hooks{})
+	if hist, err := openHistory(); err != nil {
+		// Keep polling without dedupe and history rather than refusing to start
+		log.Warning("Message history unavailable", logger.KeyError, err)
+	} else {
+		defer hist.Close()
+		e.SetHistory(hist)
+	}
+	e.Start()
+	log.Info("IGCmail IMAP headless polling started", logger.KeyAccount, cfg.IMAPUser, "server", cfg.IMAPServer, "interval_seconds", cfg.IntervalSec)
+
+	<-ctx.Done()
+	log.Info("Signal received, stopping IMAP polling")
+	select {
+	case <-e.Stop():
+		log.Info("IMAP polling stopped")
+	case <-time.After(shutdownTimeout):
+		log.Warning("Timed out waiting for the current fetch to finish")
+	}
+	return nil
+}
+
+func openHistory() (*history.DB, error) {
+	path, err := config.HistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	return history.Open(path)
+}
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"igcmailimap/config"
+	"igcmailimap/convert"
+	"igcmailimap/extract"
+	"igcmailimap/logger"
+	"igcmailimap/state"
+)
+
+// ApplyConfig replaces the config and applies what changed right away, without restarting
+// polling by hand:
+//   - a new interval reschedules the next poll;
+//   - a new account (see config.Config.StateKey) cancels a fetch in progress, so it cannot save
+//     the old account's position later, switches to the state saved for the new account (none
+//     yet: the first poll runs the initial import) and restarts the poll loop. With keepState
+//     the current state moves to the new account instead, for the same mailbox under a new
+//     server or user name.
+//
+// Changes to the output folder are not applied to files already extracted; see MoveOutput.
+// It returns the changes, for the caller to apply its own part (e.g. the logger).
+func (e *Engine) ApplyConfig(cfg *config.Config, keepState bool) config.Change {
+	e.mu.Lock()
+	old := e.cfg
+	e.mu.Unlock()
+	change := config.Diff(&old, cfg)
+
+	if !change.Account {
+		e.mu.Lock()
+		e.cfg = *cfg
+		e.mu.Unlock()
+		if change.Interval {
+			select {
+			case e.reschedule <- struct{}{}:
+			default: // already pending
+			}
+		}
+		return change
+	}
+
+	log := e.logger()
+	path, err := config.StatePath()
+	var next *state.State
+	if err == nil && !keepState {
+		next, err = state.Load(path, cfg.StateKey())
+	}
+	if err != nil {
+		// Without the new account's position, start as on its first run
+		log.Error("Failed to load state", logger.KeyError, err)
+		e.error("State: " + err.Error())
+		next = &state.State{}
+		next.Rekey(cfg.StateKey())
+	}
+
+	running := e.Running()
+	e.mu.Lock()
+	if e.stopFetch != nil {
+		e.stopFetch()
+	}
+	e.mu.Unlock()
+	<-e.Stop()
+	e.fetchMu.Lock() // waits for a cancelled manual fetch to return
+	e.mu.Lock()
+	e.cfg = *cfg
+	e.health = health{}
+	if keepState {
+		e.state.Rekey(cfg.StateKey())
+	} else {
+		*e.state = *next // the UI shares the pointer
+	}
+	st := e.state.Clone()
+	e.mu.Unlock()
+	e.fetchMu.Unlock()
+
+	log.Info("Account changed", logger.KeyAccount, cfg.IMAPUser, "server", cfg.IMAPServer, "state", st.Key(), "last_uid", st.LastUID, "kept", keepState)
+	if keepState && path != "" {
+		if err := state.Save(path, st); err != nil {
+			log.Error("Failed to save state", logger.KeyError, err)
+			e.error("State: " + err.Error())
+		}
+	}
+	if e.hooks.Failures != nil {
+		e.hooks.Failures(st.PermanentFailures())
+	}
+	if running {
+		e.Start()
+	}
+	e.statusChanged()
+	return change
+}
+
+// OutputFiles returns the extracted files recorded in the history that are still inside dir.
+func (e *Engine) OutputFiles(dir string) ([]string, error) {
+	hist := e.history()
+	if hist == nil || dir == "" {
+		return nil, nil
+	}
+	records, err := hist.List(0)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var out []string
+	for _, r := range records {
+		for _, f := range r.Files {
+			if seen[f.Path] || !inside(dir, f.Path) {
+				continue
+			}
+			seen[f.Path] = true
+			if _, err := os.Stat(f.Path); err == nil {
+				out = append(out, f.Path)
+			}
+		}
+	}
+	return out, nil
+}
+
+// MoveOutput moves the extracted files recorded in the history from oldDir to the same place
+// under newDir, with the GPX/KML/GeoJSON copies next to them, and updates the history so the
+// recent flights and duplicate records point at the new folder. Files already present in newDir
+// are left where they are. Returns the number of files moved.
+func (e *Engine) MoveOutput(ctx context.Context, oldDir, newDir string) (int, error) {
+	hist := e.history()
+	if hist == nil {
+		return 0, fmt.Errorf("history is not available")
+	}
+	files, err := e.OutputFiles(oldDir)
+	if err != nil {
+		return 0, err
+	}
+	log := e.logger()
+	moved := make(map[string]string)
+	var failed []string
+	for _, from := range files {
+		if err := ctx.Err(); err != nil {
+			failed = append(failed, err.Error())
+			break
+		}
+		if inside(newDir, from) {
+			continue // the new folder is inside the old one and already holds the file
+		}
+		rel, _ := filepath.Rel(oldDir, from)
+		to := filepath.Join(newDir, rel)
+		if _, err := os.Stat(to); err == nil {
+			log.Warning("Not moving file, target exists", "path", from, "target", to)
+			continue
+		}
+		if err := moveFile(from, to); err != nil {
+			log.Error("Failed to move file", "path", from, "target", to, logger.KeyError, err)
+			failed = append(failed, err.Error())
+			continue
+		}
+		moved[from] = to
+		if extract.IGCOnly(from) {
+			for _, format := range convert.Formats {
+				side := strings.TrimSuffix(from, filepath.Ext(from)) + "." + format
+				if _, err := os.Stat(side); err == nil {
+					if err := moveFile(side, strings.TrimSuffix(to, filepath.Ext(to))+"."+format); err != nil {
+						log.Error("Failed to move file", "path", side, logger.KeyError, err)
+					}
+				}
+			}
+		}
+	}
+	if _, err := hist.UpdatePaths(moved); err != nil {
+		return len(moved), err
+	}
+	log.Info("Moved extracted files", "from", oldDir, "to", newDir, logger.KeyFiles, len(moved), "failed", len(failed))
+	if len(failed) > 0 {
+		return len(moved), fmt.Errorf("%d file(s) not moved: %s", len(failed), failed[0])
+	}
+	return len(moved), nil
+}
+
+// inside reports whether path is within dir.
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveFile renames from to to, creating the folders needed, or copies and removes it when they
+// are on different drives.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	in.Close()
+	return os.Remove(from)
+}
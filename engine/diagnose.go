@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"igcmailimap/config"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// Diagnose tests the connection for cfg step by step without touching the saved state.
+// Sources without a detailed test (POP3, JMAP) report a single login step.
+func Diagnose(ctx context.Context, cfg *config.Config) *source.Diagnosis {
+	src := NewSource(cfg, &state.State{}, nil)
+	if d, ok := src.(source.Diagnoser); ok {
+		return d.Diagnose(ctx)
+	}
+	count, err := src.TestConnection(ctx)
+	step := source.Step{Name: source.StepLogin, Err: err}
+	if err == nil {
+		step.Detail = fmt.Sprintf("as %s, %d messages in the mailbox", cfg.IMAPUser, count)
+	}
+	return &source.Diagnosis{Steps: []source.Step{step}}
+}
+
+// Report formats a diagnosis for the user: the outcome of each step, the capabilities and
+// folders found, and what to do about a failure.
+func Report(cfg *config.Config, d *source.Diagnosis) string {
+	var b strings.Builder
+	for _, s := range d.Steps {
+		if s.Err != nil {
+			fmt.Fprintf(&b, "✗ %s: %s\n", s.Name, s.Err)
+		} else {
+			fmt.Fprintf(&b, "✓ %s: %s\n", s.Name, s.Detail)
+		}
+	}
+	if len(d.Capabilities) > 0 || len(d.AuthMechanisms) > 0 {
+		fmt.Fprintf(&b, "\nPush (IDLE): %s  MOVE: %s  CONDSTORE: %s\n",
+			yesNo(d, "IDLE"), yesNo(d, "MOVE"), yesNo(d, "CONDSTORE"))
+		fmt.Fprintf(&b, "Sign-in mechanisms: %s\n", orNone(d.AuthMechanisms))
+	}
+	if len(d.Folders) > 0 {
+		b.WriteString("\nFolders:\n")
+		for _, f := range d.Folders {
+			fmt.Fprintf(&b, "  %s (%d)\n", f.Name, f.Messages)
+		}
+	}
+	if failed := d.Failed(); failed != nil {
+		b.WriteString("\n" + Explain(cfg, d, failed))
+	} else {
+		b.WriteString("\nThe connection works.")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Explain turns the failed step of a connection test into a diagnosis in plain words.
+func Explain(cfg *config.Config, d *source.Diagnosis, failed *source.Step) string {
+	err := failed.Err
+	protocol := config.ProtocolIMAP
+	if cfg.IsPOP3() {
+		protocol = config.ProtocolPOP3
+	}
+	host, port, splitErr := net.SplitHostPort(config.WithDefaultPort(cfg.IMAPServer, protocol, cfg.Security))
+	if splitErr != nil {
+		host = cfg.IMAPServer // JMAP session URL
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "The test was cancelled."
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("The server name %q could not be found. Check the spelling of the server address and your internet connection.", host)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Sprintf("%s refused the connection on port %s. Check the port: %s.", host, port, usualPorts(cfg))
+	case errors.As(err, &recordErr):
+		return fmt.Sprintf("The server did not answer with TLS on port %s. This port probably expects STARTTLS: choose STARTTLS, or use %s.", port, usualPorts(cfg))
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("The server's certificate is not valid for %q. Use the host name given by your provider rather than an alias or IP address.", host)
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert):
+		return "The server's certificate is not trusted (self-signed or expired). Check the server address; if it is correct, ask your provider about the certificate."
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		hint := "a firewall may be blocking the port, or the server is down"
+		if cfg.Security == config.SecurityStartTLS && (port == "993" || port == "995") {
+			hint = "port " + port + " expects TLS, not STARTTLS"
+		}
+		return fmt.Sprintf("%s did not answer in time on port %s: %s.", host, port, hint)
+	case errors.Is(err, io.EOF), errors.Is(err, syscall.ECONNRESET):
+		return fmt.Sprintf("%s closed the connection. Check the port and the TLS/STARTTLS choice: %s.", host, usualPorts(cfg))
+	case failed.Name == source.StepConnect && strings.Contains(err.Error(), "STARTTLS"):
+		return fmt.Sprintf("%s does not offer STARTTLS on port %s. Choose TLS and %s.", host, port, usualPorts(cfg))
+	case failed.Name == source.StepLogin:
+		return explainLogin(d, err)
+	}
+	return "The test failed: " + err.Error()
+}
+
+func explainLogin(d *source.Diagnosis, err error) string {
+	if hasCapability(d, "LOGINDISABLED") {
+		return "The server does not allow password login on this connection. Use TLS (port 993) or STARTTLS."
+	}
+	if len(d.AuthMechanisms) > 0 && !hasMechanism(d, "PLAIN") && !hasMechanism(d, "LOGIN") {
+		return "The server only offers " + strings.Join(d.AuthMechanisms, ", ") + " sign-in, not a password. " +
+			"Enable IMAP access with an app password in your account settings."
+	}
+	return "The server rejected the user name or password (" + err.Error() + "). " +
+		"Gmail, Outlook/Microsoft 365, Yahoo and iCloud require an app password when two-step verification is on, " +
+		"and some providers need IMAP access to be enabled in the mail settings first."
+}
+
+// usualPorts names the standard ports for the protocol, e.g. "993 for TLS or 143 for STARTTLS".
+func usualPorts(cfg *config.Config) string {
+	if cfg.IsPOP3() {
+		return "995 for TLS or 110 for STARTTLS"
+	}
+	return "993 for TLS or 143 for STARTTLS"
+}
+
+func hasCapability(d *source.Diagnosis, name string) bool {
+	for _, c := range d.Capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMechanism(d *source.Diagnosis, name string) bool {
+	for _, m := range d.AuthMechanisms {
+		if strings.EqualFold(m, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func yesNo(d *source.Diagnosis, name string) string {
+	if hasCapability(d, name) {
+		return "yes"
+	}
+	return "no"
+}
+
+func orNone(s []string) string {
+	if len(s) == 0 {
+		return "none advertised"
+	}
+	return strings.Join(s, ", ")
+}
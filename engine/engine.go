@@ -0,0 +1,992 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"igcmailimap/archive"
+	"igcmailimap/config"
+	"igcmailimap/convert"
+	"igcmailimap/extract"
+	"igcmailimap/filter"
+	"igcmailimap/history"
+	"igcmailimap/imap"
+	"igcmailimap/jmap"
+	"igcmailimap/logger"
+	"igcmailimap/pilots"
+	"igcmailimap/pop3"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// Hooks lets the front end (GUI or headless daemon) react to engine events. Nil hooks are ignored.
+type Hooks struct {
+	Info  func(msg string) // user-facing information (e.g. a desktop notification)
+	Error func(msg string) // user-facing error
+	// Failures is called after each poll with the messages given up on (state.Failed.Permanent).
+	Failures func(permanent []state.Failed)
+	// Status is called whenever the poll status changes: polling started or stopped, a fetch
+	// began or ended, the next poll was scheduled.
+	Status func(Status)
+}
+
+// Status is a snapshot of the poll loop, for status displays such as the tray menu.
+type Status struct {
+	Running    bool      // the poll loop is running
+	Fetching   bool      // a fetch is in progress
+	LastCheck  time.Time // end of the last completed fetch (zero before the first)
+	NewFlights int       // files extracted by the last completed fetch
+	NextCheck  time.Time // when the poll loop fetches next (zero when stopped)
+	Health     Health
+	LastError  string // the last fetch error while Health is not Healthy
+}
+
+// Engine runs the poll loop: fetch new mail, filter, extract and convert attachments.
+// It has no UI dependency so it can run headless.
+type Engine struct {
+	hooks Hooks
+
+	fetchMu sync.Mutex // held for the whole of a fetch, so scheduled and manual fetches never overlap
+
+	reschedule chan struct{} // tells the poll loop the interval changed
+
+	mu     sync.Mutex
+	cfg    config.Config
+	state  *state.State
+	log    *logger.Logger
+	hist   *history.DB // nil when the history database could not be opened
+	health health
+
+	// Poll status, see Status
+	fetching   bool
+	lastCheck  time.Time
+	newFlights int
+	nextCheck  time.Time
+	stopFetch  context.CancelFunc // non-nil while a fetch is running; call it to cancel the fetch
+	cancel     context.CancelFunc // non-nil while the poll loop is running; call it to stop
+	done       chan struct{}      // closed when the poll loop has exited
+}
+
+// New returns an engine for the given config, state and logger. The config is copied;
+// use SetConfig or ApplyConfig to apply later changes.
+func New(cfg *config.Config, st *state.State, log *logger.Logger, hooks Hooks) *Engine {
+	return &Engine{hooks: hooks, cfg: *cfg, state: st, log: log, reschedule: make(chan struct{}, 1)}
+}
+
+// Config returns a copy of the engine's current config.
+func (e *Engine) Config() config.Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cfg
+}
+
+// SetConfig replaces the config, keeping the state (see ApplyConfig).
+func (e *Engine) SetConfig(cfg *config.Config) {
+	e.ApplyConfig(cfg, false)
+}
+
+// SetLogger replaces the logger (e.g. after logging settings changed).
+func (e *Engine) SetLogger(log *logger.Logger) {
+	e.mu.Lock()
+	e.log = log
+	e.mu.Unlock()
+}
+
+// SetHistory sets the database processed messages are recorded in and deduplicated against
+// (nil disables both).
+func (e *Engine) SetHistory(db *history.DB) {
+	e.mu.Lock()
+	e.hist = db
+	e.mu.Unlock()
+}
+
+func (e *Engine) history() *history.DB {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hist
+}
+
+func (e *Engine) logger() *logger.Logger {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.log
+}
+
+func (e *Engine) info(msg string) {
+	if e.hooks.Info != nil {
+		e.hooks.Info(msg)
+	}
+}
+
+func (e *Engine) error(msg string) {
+	if e.hooks.Error != nil {
+		e.hooks.Error(msg)
+	}
+}
+
+// Running reports whether the poll loop is running.
+func (e *Engine) Running() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cancel != nil
+}
+
+// Start starts the poll loop. Returns false if it was already running.
+func (e *Engine) Start() bool {
+	e.mu.Lock()
+	if e.cancel != nil {
+		e.mu.Unlock()
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.health = health{}
+	e.done = make(chan struct{})
+	done := e.done
+	e.mu.Unlock()
+
+	go e.pollLoop(ctx, done)
+	e.statusChanged()
+	return true
+}
+
+// Stop asks the poll loop to exit and returns a channel that is closed once it has
+// (immediately closed if it was not running). An in-flight fetch is cancelled: the connection
+// is closed, the state is left as it was and files being written are completed or removed.
+func (e *Engine) Stop() <-chan struct{} {
+	e.mu.Lock()
+	if e.cancel == nil {
+		e.mu.Unlock()
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	e.cancel()
+	e.cancel = nil
+	done := e.done
+	e.mu.Unlock()
+	e.statusChanged()
+	return done
+}
+
+// Status returns the current poll status.
+func (e *Engine) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Status{
+		Running:    e.cancel != nil,
+		Fetching:   e.fetching,
+		LastCheck:  e.lastCheck,
+		NewFlights: e.newFlights,
+		NextCheck:  e.nextCheck,
+		Health:     e.health.state,
+		LastError:  e.health.lastErr,
+	}
+}
+
+func (e *Engine) statusChanged() {
+	if e.hooks.Status != nil {
+		e.hooks.Status(e.Status())
+	}
+}
+
+// scheduled records when the poll loop fetches next (zero: not scheduled).
+func (e *Engine) scheduled(next time.Time) {
+	e.mu.Lock()
+	e.nextCheck = next
+	e.mu.Unlock()
+	e.statusChanged()
+}
+
+func (e *Engine) pollLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		e.mu.Lock()
+		if e.done == done { // not already restarted
+			e.nextCheck = time.Time{}
+		}
+		e.mu.Unlock()
+		e.statusChanged()
+	}()
+	// Sources with push (JMAP EventSource) trigger a fetch as soon as mail arrives;
+	// the timer still runs as a fallback.
+	push := make(chan struct{}, 1)
+	cfg := e.Config()
+	e.mu.Lock()
+	src := NewSource(&cfg, e.state.Clone(), e.log)
+	e.mu.Unlock()
+	if p, ok := src.(source.Pusher); ok {
+		go p.Watch(ctx, func() {
+			select {
+			case push <- struct{}{}:
+			default: // a fetch is already pending
+			}
+		})
+	}
+	e.scheduled(time.Now().Add(2 * time.Second))
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(2 * time.Second):
+	}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		e.FetchOnce(ctx)
+		last := time.Now()
+		e.schedule(timer, last, e.nextPoll(e.interval()))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				break wait
+			case <-push:
+				break wait
+			case <-e.reschedule:
+				// New interval: counted from the end of the last fetch, so a shorter one may
+				// poll right away
+				interval := e.interval()
+				e.logger().Info("Poll interval changed", "interval_seconds", int(interval.Seconds()))
+				e.schedule(timer, last, e.nextPoll(interval))
+			}
+		}
+	}
+}
+
+// schedule (re)sets the timer to fire delay after from.
+func (e *Engine) schedule(timer *time.Timer, from time.Time, delay time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	next := from.Add(delay)
+	timer.Reset(max(time.Until(next), 0))
+	e.scheduled(next)
+}
+
+// nextPoll returns the wait before the next poll, backing off while the server is failing.
+func (e *Engine) nextPoll(interval time.Duration) time.Duration {
+	e.mu.Lock()
+	h := e.health
+	e.mu.Unlock()
+	d := h.delay(interval)
+	if h.failures > 0 {
+		e.logger().Info("Next poll delayed", "health", h.state.String(), "failures", h.failures, "delay", d.Round(time.Second).String())
+	}
+	return d
+}
+
+// Health returns the connection health and the last fetch error (empty while healthy).
+func (e *Engine) Health() (Health, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.health.state, e.health.lastErr
+}
+
+// interval returns the configured poll interval.
+func (e *Engine) interval() time.Duration {
+	e.mu.Lock()
+	sec := e.cfg.IntervalSec
+	e.mu.Unlock()
+	if sec <= 0 {
+		sec = 60
+	}
+	return time.Duration(sec) * time.Second
+}
+
+// NewSource returns the mail source selected by cfg.Protocol, logging to log (may be nil).
+func NewSource(cfg *config.Config, st *state.State, log *logger.Logger) source.Source {
+	switch {
+	case cfg.IsPOP3():
+		return pop3.NewFetcher(cfg, st, log)
+	case cfg.IsJMAP():
+		return jmap.NewFetcher(cfg, st, log)
+	}
+	return imap.NewFetcher(cfg, st, log)
+}
+
+// protocolName is used to prefix fetch errors ("IMAP: ...", "POP3: ...").
+func protocolName(cfg *config.Config) string {
+	switch {
+	case cfg.IsPOP3():
+		return "POP3"
+	case cfg.IsJMAP():
+		return "JMAP"
+	}
+	return "IMAP"
+}
+
+// ErrFetchInProgress is returned by FetchNow while another fetch is running.
+var ErrFetchInProgress = errors.New("a fetch is already in progress")
+
+// FetchOnce fetches new messages and processes them, giving up when ctx is done, and returns
+// their history records. It waits for a fetch already in progress to finish first.
+func (e *Engine) FetchOnce(ctx context.Context) []*history.Record {
+	e.fetchMu.Lock()
+	defer e.fetchMu.Unlock()
+	records, _ := e.fetch(ctx, false)
+	return records
+}
+
+// FetchNow fetches and processes new messages right away, outside the poll schedule, and
+// returns the number of messages processed. Unlike a scheduled poll it always reports a failed
+// fetch through the Error hook; the error is returned too. Returns ErrFetchInProgress if a
+// fetch is already running.
+func (e *Engine) FetchNow(ctx context.Context) (int, error) {
+	if !e.fetchMu.TryLock() {
+		return 0, ErrFetchInProgress
+	}
+	defer e.fetchMu.Unlock()
+	records, err := e.fetch(ctx, true)
+	return len(records), err
+}
+
+// fetch runs one fetch, with fetchMu held, and publishes the poll status around it.
+func (e *Engine) fetch(ctx context.Context, manual bool) ([]*history.Record, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.mu.Lock()
+	e.fetching = true
+	e.stopFetch = cancel
+	e.mu.Unlock()
+	e.statusChanged()
+
+	records, err := e.fetchOnce(ctx, manual)
+
+	e.mu.Lock()
+	e.fetching = false
+	e.stopFetch = nil
+	if ctx.Err() == nil {
+		e.lastCheck = time.Now()
+		e.newFlights = extractedFiles(records)
+	}
+	e.mu.Unlock()
+	e.statusChanged()
+	return records, err
+}
+
+// extractedFiles counts the files saved for the records.
+func extractedFiles(records []*history.Record) int {
+	n := 0
+	for _, r := range records {
+		if r.Outcome == history.OutcomeExtracted {
+			n += len(r.Files)
+		}
+	}
+	return n
+}
+
+// fetchOnce fetches and processes new messages and returns their history records.
+func (e *Engine) fetchOnce(ctx context.Context, manual bool) ([]*history.Record, error) {
+	e.mu.Lock()
+	cfg := e.cfg
+	st := e.state
+	e.mu.Unlock()
+
+	if cfg.OutputFolder == "" || cfg.IMAPServer == "" {
+		return nil, nil
+	}
+
+	// The source advances a copy of the state, which is merged back under e.mu (see takePosition)
+	e.mu.Lock()
+	wasPermanent := permanentRefs(st)
+	work := st.Clone()
+	e.mu.Unlock()
+
+	src := NewSource(&cfg, work, e.logger())
+	msgs, err := src.FetchNew(ctx)
+	e.takePosition(st, work)
+	if ctx.Err() != nil {
+		e.logger().Info(protocolName(&cfg)+" fetch cancelled", logger.KeyAccount, cfg.IMAPUser, "fetched", len(msgs))
+		if len(msgs) > 0 {
+			// Cancelled after the source saved its position (e.g. during logout): process records
+			// the messages as interrupted, so they are retried instead of lost
+			e.updateFailures(st, nil, e.process(ctx, &cfg, msgs, true))
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		e.logger().Error(protocolName(&cfg)+" fetch failed", logger.KeyAccount, cfg.IMAPUser, logger.KeyError, err, "fetched", len(msgs))
+		e.fetchFailed(&cfg, err, manual)
+		if len(msgs) == 0 {
+			return nil, err
+		}
+		// Fetched before the error, with the position saved past them: the next poll goes on
+		// from there, so they are processed now
+		records := e.process(ctx, &cfg, msgs, true)
+		e.updateFailures(st, nil, records)
+		if a, ok := src.(source.Acknowledger); ok {
+			e.acknowledge(a, msgs, records)
+			e.takePosition(st, work)
+		}
+		return records, err
+	}
+	e.fetchSucceeded(&cfg)
+
+	// Messages that failed before are fetched again once their backoff has expired
+	e.mu.Lock()
+	due := st.DueRetries(time.Now())
+	e.mu.Unlock()
+	if len(due) > 0 {
+		e.logger().Info("Retrying failed messages", logger.KeyAccount, cfg.IMAPUser, "messages", due)
+		var retried []source.Message
+		retried, due, err = refetch(ctx, src, due)
+		if err != nil {
+			e.logger().Error("Retry fetch failed", logger.KeyAccount, cfg.IMAPUser, logger.KeyError, err)
+			due = nil
+		}
+		msgs = append(msgs, retried...)
+	}
+
+	records := e.process(ctx, &cfg, msgs, true)
+	e.updateFailures(st, due, records)
+	if a, ok := src.(source.Acknowledger); ok {
+		e.acknowledge(a, msgs, records)
+		e.takePosition(st, work)
+	}
+	e.reportFailures(st, wasPermanent)
+	return records, nil
+}
+
+// fetchFailed updates the connection health after a failed fetch. The user is notified only
+// when the health changes, not on every failed poll of an outage, unless the fetch was manual.
+func (e *Engine) fetchFailed(cfg *config.Config, err error, manual bool) {
+	e.mu.Lock()
+	changed := e.health.fail(err, time.Now())
+	h := e.health
+	e.mu.Unlock()
+	if changed {
+		e.logger().Warning("Connection health changed", "health", h.state.String(), "failures", h.failures, logger.KeyError, err)
+	} else if !manual {
+		return
+	}
+	if h.state == Down {
+		e.error(fmt.Sprintf("%s: %s is unreachable (%d failed polls since %s): %s. Checking less often until it is back.",
+			protocolName(cfg), cfg.IMAPServer, h.failures, h.since.Local().Format("15:04"), err.Error()))
+		return
+	}
+	e.error(protocolName(cfg) + ": " + err.Error())
+}
+
+// fetchSucceeded resets the connection health and reports the recovery after an outage.
+func (e *Engine) fetchSucceeded(cfg *config.Config) {
+	e.mu.Lock()
+	old := e.health.succeed()
+	e.mu.Unlock()
+	if old.failures == 0 {
+		return
+	}
+	e.logger().Info("Connection restored", "failures", old.failures, "outage", time.Since(old.since).Round(time.Second).String())
+	e.info(fmt.Sprintf("%s: connection to %s restored after %d failed polls", protocolName(cfg), cfg.IMAPServer, old.failures))
+}
+
+// refetch fetches the messages due for a retry again, by UID or ID depending on the source,
+// and returns them with the refs asked for; refs the source cannot fetch are left out of both.
+func refetch(ctx context.Context, src source.Source, due []state.Ref) ([]source.Message, []state.Ref, error) {
+	var uids []uint32
+	var ids []string
+	var asked []state.Ref
+	uf, byUID := src.(source.UIDFetcher)
+	idf, byID := src.(source.IDFetcher)
+	for _, ref := range due {
+		switch {
+		case ref.ID != "" && byID:
+			ids = append(ids, ref.ID)
+		case ref.ID == "" && byUID:
+			uids = append(uids, ref.UID)
+		default:
+			continue
+		}
+		asked = append(asked, ref)
+	}
+	var out []source.Message
+	if len(uids) > 0 {
+		msgs, err := uf.FetchUIDs(ctx, uids)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, msgs...)
+	}
+	if len(ids) > 0 {
+		msgs, err := idf.FetchIDs(ctx, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, msgs...)
+	}
+	return out, asked, nil
+}
+
+// updateFailures schedules a retry for each message that failed and forgets those that succeeded,
+// then saves the state. due are the messages fetched again for retry; those the server no longer
+// returned count as failed attempts too.
+func (e *Engine) updateFailures(st *state.State, due []state.Ref, records []*history.Record) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	changed := false
+	fetched := make(map[state.Ref]bool)
+	for _, r := range records {
+		ref := state.Ref{UID: r.UID, ID: r.SourceID}
+		if ref.IsZero() {
+			continue
+		}
+		fetched[ref] = true
+		if r.Outcome != history.OutcomeFailed {
+			changed = st.ClearFailure(ref) || changed
+			continue
+		}
+		st.RecordFailure(ref, r.Subject, r.From, strings.Join(r.Errors, "; "), now)
+		changed = true
+	}
+	for _, ref := range due {
+		if !fetched[ref] {
+			st.RecordFailure(ref, "", "", "message is no longer on the server", now)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	path, err := config.StatePath()
+	if err == nil {
+		err = state.Save(path, st)
+	}
+	if err != nil {
+		e.log.Error("Failed to save state", logger.KeyError, err)
+	}
+}
+
+// acknowledge passes the messages that did not fail to the source (see source.Acknowledger).
+// records are those of msgs, in the same order.
+func (e *Engine) acknowledge(a source.Acknowledger, msgs []source.Message, records []*history.Record) {
+	var done []source.Message
+	for i, r := range records {
+		if r.Outcome != history.OutcomeFailed {
+			done = append(done, msgs[i])
+		}
+	}
+	if err := a.Processed(done); err != nil {
+		e.logger().Error("Failed to save state", logger.KeyError, err)
+	}
+}
+
+// takePosition merges the position a source reached on work, a Clone of st, into st and saves
+// st, so the file keeps the failed messages recorded meanwhile.
+func (e *Engine) takePosition(st, work *state.State) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st.TakePosition(work)
+	path, err := config.StatePath()
+	if err == nil {
+		err = state.Save(path, st)
+	}
+	if err != nil {
+		e.log.Error("Failed to save state", logger.KeyError, err)
+	}
+}
+
+// reportFailures reports the messages given up on since the poll started and passes the
+// permanent failures to the Failures hook.
+func (e *Engine) reportFailures(st *state.State, wasPermanent map[state.Ref]bool) {
+	e.mu.Lock()
+	permanent := st.PermanentFailures()
+	e.mu.Unlock()
+	for _, f := range permanent {
+		if wasPermanent[f.Ref] {
+			continue
+		}
+		e.logger().With(refAttrs(f.Ref)...).Error("Giving up on message", logger.KeySubject, f.Subject, logger.KeyFrom, f.From, "attempts", f.Attempts, logger.KeyError, f.LastError)
+		e.error(fmt.Sprintf("Gave up on message %q after %d attempts: %s", f.Subject, f.Attempts, f.LastError))
+	}
+	if e.hooks.Failures != nil {
+		e.hooks.Failures(permanent)
+	}
+}
+
+func permanentRefs(st *state.State) map[state.Ref]bool {
+	refs := make(map[state.Ref]bool)
+	for _, f := range st.PermanentFailures() {
+		refs[f.Ref] = true
+	}
+	return refs
+}
+
+// FailedMessages returns the messages waiting for a retry or given up on.
+func (e *Engine) FailedMessages() []state.Failed {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]state.Failed(nil), e.state.Failed...)
+}
+
+// HasState reports whether the state holds a position or failed messages, i.e. something
+// would be lost by resetting it.
+func (e *Engine) HasState() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.state.Empty()
+}
+
+// RetryFailed makes a message given up on due for retry on the next poll.
+func (e *Engine) RetryFailed(ref state.Ref) error {
+	return e.updateState(func(st *state.State) { st.ResetFailure(ref) })
+}
+
+// DismissFailed forgets a failed message; it is not retried again.
+func (e *Engine) DismissFailed(ref state.Ref) error {
+	return e.updateState(func(st *state.State) { st.ClearFailure(ref) })
+}
+
+func (e *Engine) updateState(change func(st *state.State)) error {
+	path, err := config.StatePath()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	change(e.state)
+	return state.Save(path, e.state)
+}
+
+// Reimport fetches and processes the messages received in [since, before) without changing the
+// saved state. Returns the number of messages processed.
+func (e *Engine) Reimport(ctx context.Context, since, before time.Time) (int, error) {
+	cfg := e.Config()
+	e.mu.Lock()
+	st := e.state.Clone()
+	e.mu.Unlock()
+
+	if cfg.OutputFolder == "" {
+		return 0, fmt.Errorf("output folder is not set")
+	}
+	rf, ok := NewSource(&cfg, st, e.logger()).(source.RangeFetcher)
+	if !ok {
+		return 0, fmt.Errorf("re-import by date is not supported over %s", protocolName(&cfg))
+	}
+	e.logger().Info("Re-import started", logger.KeyAccount, cfg.IMAPUser, "since", since.Format(config.DateFormat))
+	msgs, err := rf.FetchRange(ctx, since, before)
+	if err != nil {
+		e.logger().Error("Re-import fetch failed", logger.KeyAccount, cfg.IMAPUser, logger.KeyError, err)
+		return 0, err
+	}
+	// A re-import is explicit, so messages extracted before are extracted again
+	e.process(ctx, &cfg, msgs, false)
+	return len(msgs), nil
+}
+
+// Reprocess fetches the messages of the given history records again and processes them,
+// bypassing the Message-ID dedupe. Only messages of the current account can be fetched again,
+// and only from sources that fetch by UID (IMAP). Returns the number of messages processed.
+func (e *Engine) Reprocess(ctx context.Context, ids []uint64) (int, error) {
+	cfg := e.Config()
+	e.mu.Lock()
+	st := e.state
+	work := st.Clone()
+	e.mu.Unlock()
+
+	hist := e.history()
+	if hist == nil {
+		return 0, fmt.Errorf("history is not available")
+	}
+	if cfg.OutputFolder == "" {
+		return 0, fmt.Errorf("output folder is not set")
+	}
+	uf, ok := NewSource(&cfg, work, e.logger()).(source.UIDFetcher)
+	if !ok {
+		return 0, fmt.Errorf("reprocessing is not supported over %s", protocolName(&cfg))
+	}
+	records, err := hist.Get(ids...)
+	if err != nil {
+		return 0, err
+	}
+	var uids []uint32
+	for _, r := range records {
+		if r.Server == cfg.IMAPServer && r.Account == cfg.IMAPUser && r.Folder == "INBOX" && r.UID != 0 {
+			uids = append(uids, r.UID)
+		}
+	}
+	if len(uids) == 0 {
+		return 0, fmt.Errorf("none of the selected messages can be fetched from the current account")
+	}
+	e.logger().Info("Reprocessing started", logger.KeyAccount, cfg.IMAPUser, "uids", uids)
+	msgs, err := uf.FetchUIDs(ctx, uids)
+	if err != nil {
+		e.logger().Error("Reprocessing fetch failed", logger.KeyAccount, cfg.IMAPUser, logger.KeyError, err)
+		return 0, err
+	}
+	// A message that is reprocessed successfully no longer needs a retry
+	e.updateFailures(st, nil, e.process(ctx, &cfg, msgs, false))
+	return len(msgs), nil
+}
+
+// importBatch is how many archived messages are processed (and logged) together.
+const importBatch = 50
+
+// ImportArchive feeds the messages of an .eml file, mbox or Maildir (see archive.Walk) through
+// the same filtering and extraction as fetched mail. The IMAP state is not touched.
+// Returns the number of messages processed; ctx stops the import between messages.
+func (e *Engine) ImportArchive(ctx context.Context, path string) (int, error) {
+	cfg := e.Config()
+	if cfg.OutputFolder == "" {
+		return 0, fmt.Errorf("output folder is not set")
+	}
+	e.logger().Info("Import of mail archive started", "path", path)
+
+	var batch []source.Message
+	total := 0
+	err := archive.Walk(path, func(src string, body []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg := OfflineMessage(body)
+		msg.Folder = src
+		batch = append(batch, msg)
+		if len(batch) == importBatch {
+			e.Process(ctx, &cfg, batch)
+			total += len(batch)
+			batch = nil
+		}
+		return nil
+	})
+	e.Process(ctx, &cfg, batch)
+	total += len(batch)
+	if err != nil {
+		e.logger().Error("Import of mail archive stopped", "path", path, "messages", total, logger.KeyError, err)
+		return total, err
+	}
+	e.logger().Info("Import of mail archive finished", "path", path, "messages", total)
+	return total, nil
+}
+
+// OfflineMessage wraps a raw RFC 822 message read from disk (it has no UID) for Process.
+func OfflineMessage(body []byte) source.Message {
+	m := filter.ParseMessage(body)
+	return source.Message{Subject: m.Subject, From: m.From, Body: body}
+}
+
+// Process filters, extracts and converts fetched messages (shared by polling and imports).
+// Messages whose Message-ID was extracted before are skipped, and every message is recorded
+// in the history. Once ctx is done the remaining messages are recorded as failed.
+func (e *Engine) Process(ctx context.Context, cfg *config.Config, msgs []source.Message) {
+	e.process(ctx, cfg, msgs, true)
+}
+
+// process is Process, optionally without dedupe. It returns the history records of the messages.
+func (e *Engine) process(ctx context.Context, cfg *config.Config, msgs []source.Message, dedupe bool) []*history.Record {
+	if len(msgs) == 0 {
+		return nil
+	}
+	log := e.logger().With(logger.KeyAccount, cfg.IMAPUser)
+
+	// Log fetch summary and individual messages only when there are new messages
+	var uids []uint32
+	for _, msg := range msgs {
+		if msg.UID != 0 {
+			uids = append(uids, msg.UID)
+		}
+		messageLog(log, msg).LogMessageDetails(msg.Subject, msg.From)
+	}
+	log.LogFetch(len(msgs), cfg.OutputFolder, uids)
+
+	hist := e.history()
+	var records []*history.Record
+	rules, err := filter.New(cfg.FilterAllow, cfg.FilterDeny, cfg.FilterRules)
+	if err != nil {
+		log.Error("Invalid filter rules, no message extracted", logger.KeyError, err)
+		e.error("Filters: " + err.Error())
+		// Recorded as failed, so the messages are retried once the rules are fixed
+		for _, m := range msgs {
+			rec := newRecord(cfg, m, m.FilterMessage())
+			rec.Outcome = history.OutcomeFailed
+			rec.Errors = []string{"invalid filter rules: " + err.Error()}
+			records = append(records, rec)
+		}
+		saveHistory(log, hist, records)
+		return records
+	}
+
+	var pilotDir *pilots.Directory
+	if cfg.PilotDirectory != "" {
+		pilotDir, err = pilots.Load(cfg.PilotDirectory)
+		if err != nil {
+			// Keep extracting: flights then go to the "unknown" pilot folder
+			log.Error("Failed to load pilot directory", "path", cfg.PilotDirectory, logger.KeyError, err)
+			e.error("Pilots: " + err.Error())
+			pilotDir = &pilots.Directory{}
+		}
+	}
+
+	// One SaveDir per target subfolder so duplicate handling stays per folder
+	saveDirs := make(map[string]*extract.SaveDir)
+	var allResults []logger.ExtractResult
+	extracted := make(map[string]bool) // Message-IDs extracted in this batch
+	for _, m := range msgs {
+		fm := m.FilterMessage()
+		rec := newRecord(cfg, m, fm)
+		records = append(records, rec)
+		mlog := messageLog(log, m)
+
+		if err := ctx.Err(); err != nil {
+			// Stopped: fetched but unprocessed messages are retried like failed ones
+			rec.Outcome = history.OutcomeFailed
+			rec.Errors = append(rec.Errors, "interrupted: "+err.Error())
+			continue
+		}
+		if m.Err != nil {
+			mlog.Error("Message could not be fetched", logger.KeySubject, m.Subject, logger.KeyFrom, m.From, logger.KeyError, m.Err)
+			rec.Outcome = history.OutcomeFailed
+			rec.Errors = append(rec.Errors, m.Err.Error())
+			continue
+		}
+
+		if dedupe && rec.MessageID != "" {
+			if reason := e.duplicateReason(hist, rec.MessageID, extracted); reason != "" {
+				mlog.Info("Skipped duplicate message", logger.KeySubject, m.Subject, logger.KeyFrom, m.From, "message_id", rec.MessageID, "reason", reason)
+				rec.Outcome, rec.Reason = history.OutcomeDuplicate, reason
+				continue
+			}
+		}
+
+		decision := rules.Evaluate(fm)
+		if !decision.Allow {
+			mlog.Info("Skipped message", logger.KeySubject, m.Subject, logger.KeyFrom, m.From, "reason", decision.Reason)
+			rec.Outcome, rec.Reason = history.OutcomeSkipped, decision.Reason
+			continue
+		}
+		saveDir, ok := saveDirs[decision.Subfolder]
+		if !ok {
+			saveDir = extract.NewSaveDir(filepath.Join(cfg.OutputFolder, decision.Subfolder))
+			saveDir.Formats = cfg.AcceptedFormats
+			saveDir.ToIGC = cfg.ConvertToIGC
+			saveDir.Pilots = pilotDir
+			saveDir.Template = cfg.FilenameTemplate
+			saveDirs[decision.Subfolder] = saveDir
+		}
+		results, err := extract.ExtractIGCAttachments(ctx, m.Body, saveDir)
+		for _, result := range results {
+			rec.Files = append(rec.Files, history.File{Name: result.Filename, Path: result.Path, SHA256: result.SHA256})
+		}
+		if err != nil {
+			mlog.Error("IGC extraction failed", logger.KeySubject, m.Subject, logger.KeyFrom, m.From, logger.KeyError, err)
+			e.error("Extract: " + err.Error())
+			rec.Outcome = history.OutcomeFailed
+			rec.Errors = append(rec.Errors, err.Error())
+			continue
+		}
+		rec.Outcome = history.OutcomeNoFiles
+		if len(results) > 0 {
+			rec.Outcome = history.OutcomeExtracted
+			extracted[rec.MessageID] = true
+		}
+
+		// Convert extract.ExtractResult to logger.ExtractResult
+		var loggerResults []logger.ExtractResult
+		for _, result := range results {
+			loggerResults = append(loggerResults, logger.ExtractResult{
+				Filename: result.Filename,
+				Path:     result.Path,
+			})
+		}
+		allResults = append(allResults, loggerResults...)
+
+		// Log details for this message
+		if len(loggerResults) > 0 {
+			mlog.LogMessageExtract(m.Subject, m.From, loggerResults, cfg.OutputFolder)
+		}
+
+		// Write GPX/KML/GeoJSON copies next to each saved IGC file
+		for _, result := range results {
+			if !extract.IGCOnly(result.Filename) {
+				continue
+			}
+			written, err := convert.ConvertFile(result.Path, cfg.ConvertFormats)
+			if err != nil {
+				log.Error("Conversion failed", "file", result.Filename, logger.KeyError, err)
+				rec.Errors = append(rec.Errors, fmt.Sprintf("convert %s: %v", result.Filename, err))
+				continue
+			}
+			if len(written) > 0 {
+				log.Info("Converted", "file", result.Filename, logger.KeyFiles, written)
+			}
+		}
+	}
+
+	log.LogExtract(len(allResults), cfg.OutputFolder)
+
+	saveHistory(log, hist, records)
+	return records
+}
+
+// messageLog returns log with the UID of the message, or its ID for sources without UIDs.
+func messageLog(log *logger.Logger, m source.Message) *logger.Logger {
+	return log.With(refAttrs(state.Ref{UID: m.UID, ID: m.ID})...)
+}
+
+// refAttrs returns the log attribute identifying a message.
+func refAttrs(ref state.Ref) []any {
+	if ref.ID != "" {
+		return []any{logger.KeyID, ref.ID}
+	}
+	return []any{logger.KeyUID, ref.UID}
+}
+
+func saveHistory(log *logger.Logger, hist *history.DB, records []*history.Record) {
+	if hist == nil {
+		return
+	}
+	if err := hist.Add(records); err != nil {
+		log.Error("Failed to save message history", logger.KeyError, err)
+	}
+}
+
+// newRecord returns the history record of a message, without outcome.
+func newRecord(cfg *config.Config, m source.Message, fm filter.Message) *history.Record {
+	rec := &history.Record{
+		Server:   cfg.IMAPServer,
+		Account:  cfg.IMAPUser,
+		Folder:   m.Folder,
+		UID:      m.UID,
+		SourceID: m.ID,
+		From:     fm.From,
+		Subject:  fm.Subject,
+	}
+	if ids := fm.Header["Message-Id"]; len(ids) > 0 {
+		rec.MessageID = history.NormalizeMessageID(ids[0])
+	}
+	if dates := fm.Header["Date"]; len(dates) > 0 {
+		if t, err := mail.ParseDate(dates[0]); err == nil {
+			rec.Date = t
+		}
+	}
+	return rec
+}
+
+// duplicateReason returns why the message with this Message-ID is a duplicate, or "" if it was
+// not extracted before (in this batch or, according to the history, earlier).
+func (e *Engine) duplicateReason(hist *history.DB, messageID string, extracted map[string]bool) string {
+	if extracted[messageID] {
+		return "already extracted from this batch"
+	}
+	if hist == nil {
+		return ""
+	}
+	prev, err := hist.Extracted(messageID)
+	if err != nil {
+		e.logger().Warning("History lookup failed", "message_id", messageID, logger.KeyError, err)
+		return ""
+	}
+	if prev == nil {
+		return ""
+	}
+	return fmt.Sprintf("already extracted on %s from %s %s", prev.ProcessedAt.Format("2006-01-02 15:04"), prev.Account, prev.Folder)
+}
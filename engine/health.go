@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Health is the state of the connection to the mail server, derived from consecutive failed polls.
+type Health int
+
+const (
+	Healthy  Health = iota // the last poll succeeded
+	Degraded               // the last poll failed; retrying with backoff
+	Down                   // downAfter or more polls in a row failed
+)
+
+func (h Health) String() string {
+	switch h {
+	case Degraded:
+		return "degraded"
+	case Down:
+		return "down"
+	}
+	return "healthy"
+}
+
+const (
+	downAfter   = 3                // consecutive failed polls after which the server counts as down
+	backoffBase = 30 * time.Second // wait after the first failed poll, doubled for each further one
+	backoffMax  = 30 * time.Minute // longest wait, unless the poll interval is longer
+)
+
+// health tracks consecutive failed polls. It is guarded by Engine.mu.
+type health struct {
+	state    Health
+	failures int       // failed polls in a row
+	since    time.Time // time of the first failure of the current outage
+	lastErr  string
+}
+
+// fail records a failed poll and reports whether the state changed.
+func (h *health) fail(err error, now time.Time) bool {
+	if h.failures == 0 {
+		h.since = now
+	}
+	h.failures++
+	h.lastErr = err.Error()
+	old := h.state
+	h.state = Degraded
+	if h.failures >= downAfter {
+		h.state = Down
+	}
+	return h.state != old
+}
+
+// succeed records a successful poll and returns the state before it.
+func (h *health) succeed() health {
+	old := *h
+	*h = health{}
+	return old
+}
+
+// delay returns the wait before the next poll: the interval while healthy, otherwise the longer
+// of the interval and an exponential backoff, plus up to 25% jitter so that many clients do not
+// retry in step. A failing server is never polled more often than a healthy one.
+func (h *health) delay(interval time.Duration) time.Duration {
+	if h.failures == 0 {
+		return interval
+	}
+	limit := max(backoffMax, interval)
+	d := backoffBase
+	for i := 1; i < h.failures && d < limit; i++ {
+		d *= 2
+	}
+	d = max(min(d, limit), interval)
+	return d + time.Duration(rand.Int63n(int64(d/4)+1))
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHealthDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		failures int
+		want     time.Duration // before jitter
+	}{
+		{"healthy", 5 * time.Minute, 0, 5 * time.Minute},
+		{"first failure, short interval", 10 * time.Second, 1, 30 * time.Second},
+		{"third failure, short interval", 10 * time.Second, 3, 2 * time.Minute},
+		{"capped", time.Minute, 20, 30 * time.Minute},
+		{"interval longer than backoff", 5 * time.Minute, 1, 5 * time.Minute},
+		{"backoff overtakes interval", 5 * time.Minute, 5, 8 * time.Minute},
+		{"interval longer than cap", 2 * time.Hour, 20, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &health{}
+			for i := 0; i < tt.failures; i++ {
+				h.fail(errors.New("unreachable"), time.Now())
+			}
+			maxDelay := tt.want + tt.want/4
+			if tt.failures == 0 {
+				maxDelay = tt.want
+			}
+			for i := 0; i < 100; i++ {
+				if d := h.delay(tt.interval); d < tt.want || d > maxDelay {
+					t.Fatalf("delay(%v) after %d failures = %v, want %v to %v", tt.interval, tt.failures, d, tt.want, maxDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthStates(t *testing.T) {
+	h := &health{}
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	want := []struct {
+		state   Health
+		changed bool
+	}{
+		{Degraded, true},
+		{Degraded, false},
+		{Down, true},
+		{Down, false},
+	}
+	for i, w := range want {
+		changed := h.fail(errors.New("unreachable"), start.Add(time.Duration(i)*time.Minute))
+		if h.state != w.state || changed != w.changed {
+			t.Errorf("failure %d: state %v, changed %v; want %v, %v", i+1, h.state, changed, w.state, w.changed)
+		}
+	}
+	if !h.since.Equal(start) {
+		t.Errorf("outage since %v, want the first failure", h.since)
+	}
+	old := h.succeed()
+	if old.state != Down || old.failures != 4 || h.state != Healthy || h.failures != 0 {
+		t.Errorf("succeed: old %+v, now %+v", old, *h)
+	}
+}
+
+func TestHealthByFailures(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     Health
+	}{
+		{0, Healthy},
+		{1, Degraded},
+		{downAfter - 1, Degraded},
+		{downAfter, Down},
+		{10, Down},
+	}
+	for _, tt := range tests {
+		h := &health{}
+		for i := 0; i < tt.failures; i++ {
+			h.fail(errors.New("unreachable"), time.Now())
+		}
+		if h.state != tt.want {
+			t.Errorf("after %d failures: %v, want %v", tt.failures, h.state, tt.want)
+		}
+	}
+}
+
+func TestHealthDelayNotBelowInterval(t *testing.T) {
+	intervals := []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 45 * time.Minute, 2 * time.Hour}
+	for _, interval := range intervals {
+		for failures := 0; failures <= 12; failures++ {
+			t.Run(fmt.Sprintf("%v/%d", interval, failures), func(t *testing.T) {
+				h := &health{failures: failures}
+				for i := 0; i < 20; i++ {
+					if d := h.delay(interval); d < interval {
+						t.Fatalf("delay = %v, shorter than the interval", d)
+					}
+				}
+			})
+		}
+	}
+}
@@ -1,12 +1,21 @@
 package extract
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"igcmailimap/pilots"
+
 	"github.com/emersion/go-message"
 	_ "github.com/emersion/go-message/charset"
 )
@@ -17,6 +26,7 @@ const igcExt = ".igc"
 type ExtractResult struct {
 	Filename string // The filename that was saved
 	Path     string // Full path to the saved file
+	SHA256   string // Hex digest of the saved content
 }
 
 // IGCOnly returns true if the filename (after lowercasing extension) is .igc.
@@ -24,9 +34,18 @@ func IGCOnly(filename string) bool {
 	return strings.EqualFold(filepath.Ext(filename), igcExt)
 }
 
+// sniffLen is how many leading bytes of an attachment are passed to Format.Sniff.
+const sniffLen = 512
+
 // SaveDir holds the output directory and tracks existing filenames for duplicate handling.
 type SaveDir struct {
-	Dir       string
+	Dir     string
+	Formats []string // accepted format names (see Register); nil means DefaultFormats
+	ToIGC   bool     // convert non-IGC tracks to .igc instead of saving them as received
+	// Pilots and Template, when either is set, route each file to a relative path built by
+	// pilots.Route (e.g. "{pilot}/{filename}"), with unmatched flights under "unknown".
+	Pilots    *pilots.Directory
+	Template  string
 	usedNames map[string]struct{}
 }
 
@@ -38,36 +57,52 @@ func NewSaveDir(dir string) *SaveDir {
 	}
 }
 
-// SavePath returns the path to use for an IGC attachment. If the base name was already used,
-// returns a path with timestamp prefix + "duplicate" as requested.
+func (d *SaveDir) formats() []string {
+	if len(d.Formats) == 0 {
+		return DefaultFormats
+	}
+	return d.Formats
+}
+
+// SavePath returns the path to use for an attachment of an accepted format ("" otherwise).
+// If the base name was already used, returns a path with timestamp prefix + "duplicate" as requested.
 func (d *SaveDir) SavePath(baseName string) string {
-	if !IGCOnly(baseName) {
+	if _, ok := lookupFormat(d.formats(), baseName, nil); !ok {
 		return ""
 	}
-	name := baseName
-	if !strings.HasSuffix(strings.ToLower(name), igcExt) {
-		name = name + igcExt
-	}
+	return d.uniquePath(baseName)
+}
+
+// uniquePath joins name (which may contain subfolders) to Dir, prefixing the file name
+// with a timestamp + "duplicate" if it was already used.
+func (d *SaveDir) uniquePath(name string) string {
 	fullPath := filepath.Join(d.Dir, name)
 	if _, used := d.usedNames[name]; used {
 		ts := time.Now().Format("20060102150405")
-		name = ts + "_duplicate_" + name
+		dir, base := filepath.Split(name)
+		name = dir + ts + "_duplicate_" + base
 		fullPath = filepath.Join(d.Dir, name)
 	}
 	d.usedNames[name] = struct{}{}
 	return fullPath
 }
 
-// ExtractIGCAttachments parses the raw RFC822 message body and saves each .igc attachment
-// into the given SaveDir. Returns the extracted files and any error (e.g. from writing).
-func ExtractIGCAttachments(raw []byte, out *SaveDir) ([]ExtractResult, error) {
+// ExtractIGCAttachments parses the raw RFC822 message body and saves each attachment of an
+// accepted format (see SaveDir.Formats) into the given SaveDir. Returns the extracted files
+// and any error (e.g. from writing or converting). It stops before the next attachment once
+// ctx is done; files are written completely or not at all.
+func ExtractIGCAttachments(ctx context.Context, raw []byte, out *SaveDir) ([]ExtractResult, error) {
 	if out == nil || out.Dir == "" {
 		return nil, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	m, err := message.Read(strings.NewReader(string(raw)))
 	if err != nil && !message.IsUnknownCharset(err) {
 		return nil, err
 	}
+	sender := senderAddress(m.Header)
 	mr := m.MultipartReader()
 	if mr == nil {
 		// Single part: check if the whole body is IGC-named (e.g. Content-Disposition filename)
@@ -75,14 +110,13 @@ func ExtractIGCAttachments(raw []byte, out *SaveDir) ([]ExtractResult, error) {
 		if disp == "attachment" {
 			if filename, _ := m.Header.Text("Content-Disposition"); filename != "" {
 				// Parse filename from Content-Disposition (simplified: look for filename=)
-				if name := parseFilenameFromDisposition(filename); name != "" && IGCOnly(name) {
-					path := out.SavePath(name)
-					if path != "" {
-						if wErr := writePartToFile(m.Body, path); wErr != nil {
-							return nil, wErr
-						}
-						filename := filepath.Base(path)
-						return []ExtractResult{{Filename: filename, Path: path}}, nil
+				if name := parseFilenameFromDisposition(filename); name != "" {
+					result, ok, wErr := saveAttachment(out, name, sender, m.Body)
+					if wErr != nil {
+						return nil, wErr
+					}
+					if ok {
+						return []ExtractResult{result}, nil
 					}
 				}
 			}
@@ -96,6 +130,9 @@ func ExtractIGCAttachments(raw []byte, out *SaveDir) ([]ExtractResult, error) {
 		if err != nil {
 			break
 		}
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
 		_, params, _ := part.Header.ContentDisposition()
 		filename := ""
 		if params != nil {
@@ -110,24 +147,71 @@ func ExtractIGCAttachments(raw []byte, out *SaveDir) ([]ExtractResult, error) {
 				filename = ctParams["name"]
 			}
 		}
-		if filename == "" || !IGCOnly(filename) {
-			continue
-		}
-		path := out.SavePath(filename)
-		if path == "" {
+		if filename == "" {
 			continue
 		}
-		if wErr := writePartToFile(part.Body, path); wErr != nil {
+		result, ok, wErr := saveAttachment(out, filename, sender, part.Body)
+		if wErr != nil {
 			return results, wErr
 		}
-		results = append(results, ExtractResult{
-			Filename: filepath.Base(path),
-			Path:     path,
-		})
+		if ok {
+			results = append(results, result)
+		}
 	}
 	return results, nil
 }
 
+// saveAttachment writes one attachment if its name or content matches an accepted format,
+// converting it to IGC first when out.ToIGC is set and routing it per pilot when configured.
+// ok is false when the attachment is skipped.
+func saveAttachment(out *SaveDir, filename, sender string, body io.Reader) (ExtractResult, bool, error) {
+	filename = filepath.Base(filename)
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	f, ok := lookupFormat(out.formats(), filename, head)
+	if !ok {
+		return ExtractResult{}, false, nil
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return ExtractResult{}, false, err
+	}
+	name := filename
+	if !strings.HasSuffix(strings.ToLower(name), f.Ext) {
+		name += f.Ext // recognised by content, e.g. "flight.txt" -> "flight.txt.igc"
+	}
+	if out.ToIGC && f.ToIGC != nil {
+		igcData, err := f.ToIGC(data)
+		if err != nil {
+			return ExtractResult{}, false, fmt.Errorf("convert %s to IGC: %w", filename, err)
+		}
+		name = name[:len(name)-len(f.Ext)] + igcExt
+		data = igcData
+	}
+	if out.Pilots != nil || out.Template != "" {
+		name = pilots.Route(out.Pilots, out.Template, name, sender, data)
+	}
+	path := out.uniquePath(name)
+	if err := writePartToFile(bytes.NewReader(data), path); err != nil {
+		return ExtractResult{}, false, err
+	}
+	sum := sha256.Sum256(data)
+	return ExtractResult{Filename: filepath.Base(path), Path: path, SHA256: hex.EncodeToString(sum[:])}, true, nil
+}
+
+// senderAddress returns the bare From address of the message, or "" if it cannot be parsed.
+func senderAddress(h message.Header) string {
+	from, err := h.Text("From")
+	if err != nil || from == "" {
+		return ""
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return ""
+	}
+	return addr.Address
+}
+
 func parseFilenameFromDisposition(disp string) string {
 	// Very simple: look for filename="..." or filename=...
 	i := strings.Index(strings.ToLower(disp), "filename=")
@@ -150,15 +234,28 @@ func parseFilenameFromDisposition(disp string) string {
 	return disp[:j]
 }
 
+// writePartToFile writes r to path through a temporary file in the same folder, so an
+// interrupted write never leaves a partial file under the final name.
 func writePartToFile(r io.Reader, path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	f, err := os.Create(path)
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
 	return err
 }
@@ -0,0 +1,178 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"igcmailimap/convert"
+	"igcmailimap/igc"
+)
+
+// Format describes an accepted attachment type.
+type Format struct {
+	Name string // key used in config.AcceptedFormats, e.g. "igc.gz"
+	Ext  string // lower-case filename suffix including the dot, e.g. ".igc.gz"
+	// Sniff optionally recognises the format from the first bytes of an attachment whose
+	// filename does not carry a known extension. May be nil.
+	Sniff func(head []byte) bool
+	// ToIGC optionally converts the attachment to IGC. Nil for IGC itself.
+	ToIGC func(data []byte) ([]byte, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+)
+
+// DefaultFormats is the accepted set when none is configured (plain IGC only, as before).
+var DefaultFormats = []string{"igc"}
+
+// Register adds or replaces a format in the registry.
+func Register(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	f.Ext = strings.ToLower(f.Ext)
+	formats[f.Name] = f
+}
+
+// Formats returns the names of all registered formats, sorted.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AcceptsName reports whether filename carries the extension of an enabled format (nil means
+// DefaultFormats). Content is not sniffed: sources that download attachments one by one use it
+// to skip the others.
+func AcceptsName(enabled []string, filename string) bool {
+	if len(enabled) == 0 {
+		enabled = DefaultFormats
+	}
+	_, ok := lookupFormat(enabled, filename, nil)
+	return ok
+}
+
+// lookupFormat returns the enabled format for filename, by extension first, then by sniffing head.
+// Longer extensions win so "flight.igc.gz" is not taken for ".gz" of something else.
+func lookupFormat(enabled []string, filename string, head []byte) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	lower := strings.ToLower(filename)
+	var best Format
+	found := false
+	for _, name := range enabled {
+		f, ok := formats[name]
+		if !ok || !strings.HasSuffix(lower, f.Ext) {
+			continue
+		}
+		if !found || len(f.Ext) > len(best.Ext) {
+			best, found = f, true
+		}
+	}
+	if found || head == nil {
+		return best, found
+	}
+	for _, name := range enabled {
+		if f, ok := formats[name]; ok && f.Sniff != nil && f.Sniff(head) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// ReadFlight reads a track file of any registered format, recognised by extension or content.
+func ReadFlight(path string) (*igc.Flight, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	head := data
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	f, ok := lookupFormat(Formats(), filepath.Base(path), head)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a track file", filepath.Base(path))
+	}
+	if f.ToIGC != nil {
+		if data, err = f.ToIGC(data); err != nil {
+			return nil, err
+		}
+	}
+	return igc.Parse(bytes.NewReader(data))
+}
+
+func init() {
+	Register(Format{Name: "igc", Ext: igcExt, Sniff: sniffIGC})
+	Register(Format{Name: "igc.gz", Ext: ".igc.gz", Sniff: sniffGzip, ToIGC: gunzip})
+	Register(Format{Name: "kml", Ext: ".kml", Sniff: sniffXML("<kml"), ToIGC: trackToIGC(convert.ReadKML)})
+	Register(Format{Name: "gpx", Ext: ".gpx", Sniff: sniffXML("<gpx"), ToIGC: trackToIGC(convert.ReadGPX)})
+	Register(Format{Name: "fit", Ext: ".fit", Sniff: sniffFIT, ToIGC: trackToIGC(convert.ReadFIT)})
+}
+
+// sniffIGC matches files starting with an A (logger) record followed by H (header) records.
+func sniffIGC(head []byte) bool {
+	head = bytes.TrimLeft(head, "\xef\xbb\xbf \r\n")
+	return len(head) > 4 && head[0] == 'A' && (bytes.Contains(head, []byte("\nHF")) || bytes.Contains(head, []byte("\nHO")))
+}
+
+// sniffGzip matches gzip data that decompresses to an IGC file; head is usually cut short, so
+// only the start of the content is checked.
+func sniffGzip(head []byte) bool {
+	if len(head) <= 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return false
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return false
+	}
+	content := make([]byte, sniffLen)
+	n, _ := io.ReadFull(zr, content)
+	return sniffIGC(content[:n])
+}
+
+func sniffXML(root string) func([]byte) bool {
+	return func(head []byte) bool {
+		return bytes.Contains(head, []byte(root))
+	}
+}
+
+func sniffFIT(head []byte) bool {
+	return len(head) >= 12 && string(head[8:12]) == ".FIT"
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+func trackToIGC(read func(io.Reader) (*igc.Flight, error)) func([]byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		fl, err := read(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := igc.Write(&buf, fl); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
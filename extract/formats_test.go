@@ -0,0 +1,76 @@
+package extract
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// longIGC returns an IGC file whose gzip is well over sniffLen bytes.
+func longIGC() string {
+	var b strings.Builder
+	b.WriteString("AXCT001\r\nHFDTE150723\r\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "B%06d5206343N00006198WA%05d00558\r\n", i*7919%1000000, i*31%99999)
+	}
+	return b.String()
+}
+
+// gz returns s gzipped, cut to sniffLen bytes as Sniff receives it.
+func gz(s string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return string(buf.Bytes()[:min(buf.Len(), sniffLen)])
+}
+
+func TestAcceptsName(t *testing.T) {
+	tests := []struct {
+		enabled []string
+		name    string
+		want    bool
+	}{
+		{nil, "flight.igc", true},
+		{nil, "FLIGHT.IGC", true},
+		{nil, "flight.gpx", false},
+		{[]string{"igc", "gpx"}, "track.GPX", true},
+		{[]string{"igc"}, "flight.igc.gz", false},
+		{[]string{"igc.gz"}, "flight.igc.gz", true},
+		{[]string{"fit"}, "photo.jpg", false},
+		{[]string{"unknown"}, "flight.igc", false},
+	}
+	for _, tt := range tests {
+		if got := AcceptsName(tt.enabled, tt.name); got != tt.want {
+			t.Errorf("AcceptsName(%q, %q) = %v, want %v", tt.enabled, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLookupFormat(t *testing.T) {
+	all := Formats()
+	tests := []struct {
+		name string
+		head string
+		want string // "" for not found
+	}{
+		{"flight.igc.gz", "", "igc.gz"}, // longest extension wins
+		{"attachment.bin", "\xef\xbb\xbfAXCT001\r\nHFDTE150723\r\n", "igc"},
+		{"attachment.bin", gz("AXCT001\r\nHFDTE150723\r\n"), "igc.gz"},
+		{"attachment.bin", gz(longIGC()), "igc.gz"}, // cut mid-stream
+		{"attachment.bin", gz("Hello"), ""},         // not every gzip file is a track
+		{"attachment.bin", "\x1f\x8b\x08\x00", ""},
+		{"attachment.bin", `<?xml version="1.0"?><gpx version="1.1">`, "gpx"},
+		{"attachment.bin", `<?xml version="1.0"?><kml xmlns="http://www.opengis.net/kml/2.2">`, "kml"},
+		{"attachment.bin", "\x0e\x10\x00\x00\x00\x00\x00\x00.FIT\x00\x00", "fit"},
+		{"attachment.bin", "Hello", ""},
+	}
+	for _, tt := range tests {
+		f, _ := lookupFormat(all, tt.name, []byte(tt.head))
+		if f.Name != tt.want {
+			t.Errorf("lookupFormat(%q, %q) = %q, want %q", tt.name, tt.head, f.Name, tt.want)
+		}
+	}
+}
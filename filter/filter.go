@@ -0,0 +1,296 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+)
+
+// Rule fields. Any other header is matched with "header:<Name>", e.g. "header:X-Mailer".
+const (
+	FieldFrom    = "from"
+	FieldTo      = "to"
+	FieldSubject = "subject"
+	FieldListID  = "list-id"
+	headerPrefix = "header:"
+)
+
+// Match types.
+const (
+	MatchEquals   = "equals"   // case-insensitive equality
+	MatchContains = "contains" // case-insensitive substring
+	MatchDomain   = "domain"   // address domain, e.g. "club.example" matches "pilot@club.example"
+	MatchRegex    = "regex"    // Go regular expression, case-insensitive unless it starts with (?-i)
+)
+
+// Actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Fields, Matches and Actions list the accepted values in the order shown in the UI.
+var (
+	Fields  = []string{FieldFrom, FieldTo, FieldSubject, FieldListID}
+	Matches = []string{MatchContains, MatchEquals, MatchDomain, MatchRegex}
+	Actions = []string{ActionAllow, ActionDeny}
+)
+
+// Rule is one filter rule as stored in config.json.
+type Rule struct {
+	Field     string `json:"field"` // see Field* constants, or "header:<Name>"
+	Match     string `json:"match"` // see Match* constants
+	Pattern   string `json:"pattern"`
+	Action    string `json:"action"`              // "allow" or "deny"
+	Subfolder string `json:"subfolder,omitempty"` // allow rules only: save into this subfolder of the output folder
+}
+
+// String returns a one-line summary of the rule for lists and logs.
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s if %s %s %q", r.Action, r.Field, r.Match, r.Pattern)
+	if r.Subfolder != "" {
+		s += " -> " + r.Subfolder
+	}
+	return s
+}
+
+// Message is what rules are evaluated against.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Header  map[string][]string // decoded header values, canonical keys (textproto.CanonicalMIMEHeaderKey)
+}
+
+// ParseMessage reads the header of a raw RFC822 message. Values are MIME-decoded; the body is ignored.
+func ParseMessage(raw []byte) Message {
+	var m Message
+	e, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return m
+	}
+	m.Header = make(map[string][]string)
+	fields := e.Header.Fields()
+	for fields.Next() {
+		v, err := fields.Text()
+		if err != nil {
+			v = fields.Value()
+		}
+		k := textproto.CanonicalMIMEHeaderKey(fields.Key())
+		m.Header[k] = append(m.Header[k], v)
+	}
+	m.Subject = first(m.Header["Subject"])
+	if addrs := parseAddresses(m.Header["From"]); len(addrs) > 0 {
+		m.From = addrs[0]
+	}
+	m.To = parseAddresses(append(m.Header["To"], m.Header["Cc"]...))
+	return m
+}
+
+// parseAddresses returns the bare addresses in the given header values, skipping unparsable ones.
+func parseAddresses(values []string) []string {
+	var out []string
+	for _, v := range values {
+		list, err := mail.ParseAddressList(v)
+		if err != nil {
+			continue
+		}
+		for _, a := range list {
+			out = append(out, a.Address)
+		}
+	}
+	return out
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Decision is the outcome of evaluating a message.
+type Decision struct {
+	Allow     bool
+	Subfolder string // target subfolder from the matching allow rule, if any
+	Reason    string // human-readable reason for logs
+}
+
+type compiledRule struct {
+	Rule
+	re *regexp.Regexp
+}
+
+// Engine evaluates a message against the sender lists and rules. A nil Engine allows everything.
+type Engine struct {
+	allow []string
+	deny  []string
+	rules []compiledRule
+}
+
+// New validates and compiles the lists and rules. Allow/deny entries are addresses
+// ("pilot@club.example") or domains ("club.example" or "@club.example"), matched against From
+// regardless of case.
+func New(allow, deny []string, rules []Rule) (*Engine, error) {
+	e := &Engine{allow: normaliseList(allow), deny: normaliseList(deny)}
+	for i, r := range rules {
+		cr := compiledRule{Rule: r}
+		field := strings.ToLower(r.Field)
+		if !strings.HasPrefix(field, headerPrefix) && !contains(Fields, field) {
+			return nil, fmt.Errorf("rule %d: unknown field %q", i+1, r.Field)
+		}
+		if field == headerPrefix {
+			return nil, fmt.Errorf("rule %d: missing header name", i+1)
+		}
+		if !contains(Matches, r.Match) {
+			return nil, fmt.Errorf("rule %d: unknown match %q", i+1, r.Match)
+		}
+		if !contains(Actions, r.Action) {
+			return nil, fmt.Errorf("rule %d: unknown action %q", i+1, r.Action)
+		}
+		if r.Match == MatchRegex {
+			// Case-insensitive like the other matches; a leading (?-i) turns this off again
+			re, err := regexp.Compile("(?i)" + r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			cr.re = re
+		}
+		if r.Subfolder != "" {
+			clean := filepath.Clean(r.Subfolder)
+			if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("rule %d: subfolder must be inside the output folder", i+1)
+			}
+			cr.Subfolder = clean
+		}
+		e.rules = append(e.rules, cr)
+	}
+	return e, nil
+}
+
+// Evaluate applies, in order: the deny list, the rules (first match wins), then the allow list
+// (when non-empty, senders not on it are denied). Messages matching nothing are allowed.
+func (e *Engine) Evaluate(m Message) Decision {
+	if e == nil {
+		return Decision{Allow: true}
+	}
+	for _, d := range e.deny {
+		if matchSender(m.From, d) {
+			return Decision{Reason: "sender on blocklist (" + d + ")"}
+		}
+	}
+	for _, r := range e.rules {
+		if r.matches(m) {
+			return Decision{
+				Allow:     r.Action == ActionAllow,
+				Subfolder: r.Subfolder,
+				Reason:    "rule: " + r.Rule.String(),
+			}
+		}
+	}
+	if len(e.allow) > 0 {
+		for _, a := range e.allow {
+			if matchSender(m.From, a) {
+				return Decision{Allow: true, Reason: "sender on allowlist (" + a + ")"}
+			}
+		}
+		return Decision{Reason: "sender not on allowlist"}
+	}
+	return Decision{Allow: true}
+}
+
+func (r compiledRule) matches(m Message) bool {
+	for _, v := range r.values(m) {
+		if r.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the message values the rule's field refers to.
+func (r compiledRule) values(m Message) []string {
+	field := strings.ToLower(r.Field)
+	switch field {
+	case FieldFrom:
+		return []string{m.From}
+	case FieldTo:
+		return m.To
+	case FieldSubject:
+		return []string{m.Subject}
+	case FieldListID:
+		return m.Header["List-Id"]
+	}
+	name := strings.TrimSpace(r.Field[len(headerPrefix):])
+	return m.Header[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+func (r compiledRule) matchValue(v string) bool {
+	switch r.Match {
+	case MatchEquals:
+		return strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(r.Pattern))
+	case MatchContains:
+		return strings.Contains(strings.ToLower(v), strings.ToLower(r.Pattern))
+	case MatchDomain:
+		return matchSender(v, "@"+strings.TrimPrefix(strings.TrimSpace(r.Pattern), "@"))
+	case MatchRegex:
+		return r.re.MatchString(v)
+	}
+	return false
+}
+
+// matchSender matches an address against a list entry, ignoring case: "@domain" matches the
+// domain and its subdomains, anything else must be the same address.
+func matchSender(addr, entry string) bool {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return false
+	}
+	if strings.HasPrefix(entry, "@") {
+		domain := entry[1:]
+		at := strings.LastIndexByte(addr, '@')
+		if at < 0 {
+			return false
+		}
+		host := addr[at+1:]
+		return strings.EqualFold(host, domain) || hasSuffixFold(host, "."+domain)
+	}
+	return strings.EqualFold(addr, entry)
+}
+
+// hasSuffixFold is strings.HasSuffix ignoring case.
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// normaliseList trims entries, drops blanks and turns bare domains into "@domain".
+func normaliseList(list []string) []string {
+	var out []string
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "@") {
+			s = "@" + s
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
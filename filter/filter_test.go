@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     []string
+		deny      []string
+		rules     []Rule
+		msg       Message
+		allowed   bool
+		subfolder string
+	}{
+		{name: "no rules", msg: Message{From: "pilot@club.org"}, allowed: true},
+		{name: "allow address", allow: []string{"pilot@club.org"}, msg: Message{From: "pilot@club.org"}, allowed: true},
+		{name: "allow address ignores case", allow: []string{"pilot@club.org"}, msg: Message{From: "Pilot@Club.org"}, allowed: true},
+		{name: "allow entry ignores case", allow: []string{"Pilot@Club.org"}, msg: Message{From: "pilot@club.org"}, allowed: true},
+		{name: "not on allowlist", allow: []string{"pilot@club.org"}, msg: Message{From: "other@club.org"}},
+		{name: "allow domain", allow: []string{"club.org"}, msg: Message{From: "anyone@CLUB.org"}, allowed: true},
+		{name: "allow domain with at", allow: []string{"@club.org"}, msg: Message{From: "anyone@club.org"}, allowed: true},
+		{name: "allow subdomain", allow: []string{"club.org"}, msg: Message{From: "anyone@mail.Club.org"}, allowed: true},
+		{name: "domain is not a suffix of another", allow: []string{"club.org"}, msg: Message{From: "anyone@myclub.org"}},
+		{name: "empty sender not on allowlist", allow: []string{"club.org"}, msg: Message{}},
+		{name: "deny domain ignores case", deny: []string{"SPAM.example"}, msg: Message{From: "x@mail.spam.EXAMPLE"}},
+		{name: "deny entry trimmed", deny: []string{"  spam@club.org "}, msg: Message{From: "spam@club.org"}},
+		{name: "blank allow entries ignored", allow: []string{" ", ""}, msg: Message{From: "pilot@club.org"}, allowed: true},
+		{name: "deny wins over allow", allow: []string{"club.org"}, deny: []string{"Spam@Club.org"}, msg: Message{From: "spam@club.org"}},
+		{
+			name:  "deny wins over rules",
+			deny:  []string{"spam.example"},
+			rules: []Rule{{Field: FieldFrom, Match: MatchContains, Pattern: "spam", Action: ActionAllow}},
+			msg:   Message{From: "x@spam.example"},
+		},
+		{
+			name:  "rule before allowlist",
+			allow: []string{"club.org"},
+			rules: []Rule{{Field: FieldSubject, Match: MatchContains, Pattern: "IGC", Action: ActionAllow, Subfolder: "comp"}},
+			msg:   Message{From: "guest@other.org", Subject: "my igc file"}, allowed: true, subfolder: "comp",
+		},
+		{
+			name:  "first matching rule wins",
+			rules: []Rule{{Field: FieldSubject, Match: MatchContains, Pattern: "test", Action: ActionDeny}, {Field: FieldSubject, Match: MatchContains, Pattern: "flight", Action: ActionAllow}},
+			msg:   Message{Subject: "Test flight"},
+		},
+		{
+			name:  "equals ignores case and spaces",
+			rules: []Rule{{Field: FieldFrom, Match: MatchEquals, Pattern: " Pilot@Club.org ", Action: ActionDeny}},
+			msg:   Message{From: "pilot@club.org"},
+		},
+		{
+			name:  "domain match on to",
+			rules: []Rule{{Field: FieldTo, Match: MatchDomain, Pattern: "@Flights.example", Action: ActionDeny}},
+			msg:   Message{To: []string{"a@example.com", "log@flights.example"}},
+		},
+		{
+			name:  "regex is case-insensitive",
+			rules: []Rule{{Field: FieldSubject, Match: MatchRegex, Pattern: `^task \d+$`, Action: ActionDeny}},
+			msg:   Message{Subject: "TASK 3"},
+		},
+		{
+			name:  "regex with (?-i) is case-sensitive",
+			rules: []Rule{{Field: FieldSubject, Match: MatchRegex, Pattern: `(?-i)^task \d+$`, Action: ActionDeny}},
+			msg:   Message{Subject: "TASK 3"}, allowed: true,
+		},
+		{
+			name:  "list-id",
+			rules: []Rule{{Field: FieldListID, Match: MatchContains, Pattern: "xc-league", Action: ActionDeny}},
+			msg:   Message{Header: map[string][]string{"List-Id": {"<XC-League.lists.example>"}}},
+		},
+		{
+			name:  "field name ignores case",
+			rules: []Rule{{Field: "Subject", Match: MatchContains, Pattern: "task", Action: ActionDeny}},
+			msg:   Message{Subject: "Task 1"},
+		},
+		{
+			name:  "domain match on from includes subdomains",
+			rules: []Rule{{Field: FieldFrom, Match: MatchDomain, Pattern: "club.org", Action: ActionDeny}},
+			msg:   Message{From: "pilot@Mail.Club.ORG"},
+		},
+		{
+			name:  "header name ignores case",
+			rules: []Rule{{Field: "Header:X-MAILER", Match: MatchContains, Pattern: "xctr", Action: ActionAllow, Subfolder: "xctrack"}},
+			msg:   Message{Header: map[string][]string{"X-Mailer": {"XCTrack Pro"}}}, allowed: true, subfolder: "xctrack",
+		},
+		{
+			name:  "missing header does not match",
+			rules: []Rule{{Field: "header:x-mailer", Match: MatchContains, Pattern: "", Action: ActionDeny}},
+			msg:   Message{Subject: "no mailer"}, allowed: true,
+		},
+		{
+			name:  "any header",
+			rules: []Rule{{Field: "header:x-mailer", Match: MatchEquals, Pattern: "XCTrack", Action: ActionAllow, Subfolder: "xctrack"}},
+			msg:   Message{Header: map[string][]string{"X-Mailer": {"xctrack"}}}, allowed: true, subfolder: "xctrack",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(tt.allow, tt.deny, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := e.Evaluate(tt.msg)
+			if d.Allow != tt.allowed || d.Subfolder != tt.subfolder {
+				t.Errorf("Evaluate() = allow %v, subfolder %q (%s), want allow %v, subfolder %q", d.Allow, d.Subfolder, d.Reason, tt.allowed, tt.subfolder)
+			}
+		})
+	}
+}
+
+func TestNilEngineAllows(t *testing.T) {
+	var e *Engine
+	if !e.Evaluate(Message{From: "a@b.c"}).Allow {
+		t.Error("nil engine denied a message")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"unknown field", Rule{Field: "body", Match: MatchContains, Action: ActionAllow}},
+		{"missing header name", Rule{Field: "header:", Match: MatchContains, Action: ActionAllow}},
+		{"unknown match", Rule{Field: FieldFrom, Match: "like", Action: ActionAllow}},
+		{"unknown action", Rule{Field: FieldFrom, Match: MatchContains, Action: "keep"}},
+		{"invalid regex", Rule{Field: FieldFrom, Match: MatchRegex, Pattern: "(", Action: ActionAllow}},
+		{"subfolder outside", Rule{Field: FieldFrom, Match: MatchContains, Action: ActionAllow, Subfolder: "../x"}},
+		{"absolute subfolder", Rule{Field: FieldFrom, Match: MatchContains, Action: ActionAllow, Subfolder: "/tmp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nil, nil, []Rule{tt.rule}); err == nil {
+				t.Error("New() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	raw := "From: \"A Pilot\" <Pilot@Club.org>\r\n" +
+		"To: log@flights.example, Other <other@example.com>\r\n" +
+		"Cc: cc@example.com\r\n" +
+		"Subject: =?utf-8?q?Fl=C3=BCge?=\r\n" +
+		"List-Id: <xc.lists.example>\r\n" +
+		"\r\n" +
+		"body\r\n"
+	m := ParseMessage([]byte(raw))
+	if m.From != "Pilot@Club.org" {
+		t.Errorf("From = %q", m.From)
+	}
+	if want := []string{"log@flights.example", "other@example.com", "cc@example.com"}; !reflect.DeepEqual(m.To, want) {
+		t.Errorf("To = %q, want %q", m.To, want)
+	}
+	if m.Subject != "Flüge" {
+		t.Errorf("Subject = %q", m.Subject)
+	}
+	if got := m.Header["List-Id"]; len(got) != 1 || got[0] != "<xc.lists.example>" {
+		t.Errorf("List-Id = %q", got)
+	}
+}
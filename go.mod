@@ -6,6 +6,7 @@ require (
 	fyne.io/fyne/v2 v2.4.4
 	github.com/emersion/go-imap v1.2.1
 	github.com/emersion/go-message v0.18.2
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/image v0.11.0
 	golang.org/x/sys v0.18.0
 )
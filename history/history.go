@@ -0,0 +1,253 @@
+// Package history records every processed message in a local bbolt database, for dedupe by
+// Message-ID across folders and accounts, the history view, reprocessing and reporting.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Outcomes of processing a message (Record.Outcome).
+const (
+	OutcomeExtracted = "extracted" // at least one file was saved
+	OutcomeNoFiles   = "no_files"  // processed, but no attachment of an accepted format
+	OutcomeSkipped   = "skipped"   // rejected by the filters (see Record.Reason)
+	OutcomeDuplicate = "duplicate" // same Message-ID already extracted (see Record.Reason)
+	OutcomeFailed    = "failed"    // extraction failed (see Record.Errors)
+)
+
+var (
+	recordsBucket    = []byte("records")     // ID -> JSON Record
+	messageIDsBucket = []byte("message_ids") // Message-ID -> ID of the latest extracted record
+)
+
+// openTimeout bounds the wait for the file lock held by another process (e.g. the GUI while
+// the CLI runs).
+const openTimeout = 2 * time.Second
+
+// ErrLocked is returned by Open when another process has the database open.
+var ErrLocked = errors.New("history database is in use by another process")
+
+// File is a file saved from a message.
+type File struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"` // hex digest of the saved content
+}
+
+// Record describes one processed message.
+type Record struct {
+	ID          uint64    `json:"id"`
+	Server      string    `json:"server"`
+	Account     string    `json:"account"`
+	Folder      string    `json:"folder"` // mailbox, or the archive file for imported mail
+	UID         uint32    `json:"uid,omitempty"`
+	SourceID    string    `json:"source_id,omitempty"` // POP3 UIDL or JMAP Email id, for mail without UID
+	MessageID   string    `json:"message_id,omitempty"`
+	Date        time.Time `json:"date,omitempty"` // Date header
+	From        string    `json:"from"`
+	Subject     string    `json:"subject"`
+	Outcome     string    `json:"outcome"`
+	Reason      string    `json:"reason,omitempty"`
+	Files       []File    `json:"files,omitempty"`
+	Errors      []string  `json:"errors,omitempty"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// DB is the history database.
+type DB struct {
+	bolt *bolt.DB
+}
+
+// Open opens (or creates) the history database at path.
+func Open(path string) (*DB, error) {
+	b, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = b.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{recordsBucket, messageIDsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return &DB{bolt: b}, nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+// NormalizeMessageID strips the angle brackets and spaces around a Message-ID header value.
+func NormalizeMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// Add stores the records in one transaction, assigning their IDs (and ProcessedAt if unset).
+func (db *DB) Add(records []*Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		rb, ib := tx.Bucket(recordsBucket), tx.Bucket(messageIDsBucket)
+		for _, r := range records {
+			id, err := rb.NextSequence()
+			if err != nil {
+				return err
+			}
+			r.ID = id
+			if r.ProcessedAt.IsZero() {
+				r.ProcessedAt = time.Now()
+			}
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := rb.Put(itob(id), data); err != nil {
+				return err
+			}
+			if r.Outcome == OutcomeExtracted && r.MessageID != "" {
+				if err := ib.Put([]byte(r.MessageID), itob(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Extracted returns the latest record in which the message with this Message-ID had files
+// extracted, or nil if there is none.
+func (db *DB) Extracted(messageID string) (*Record, error) {
+	if messageID == "" {
+		return nil, nil
+	}
+	var r *Record
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(messageIDsBucket).Get([]byte(messageID))
+		if id == nil {
+			return nil
+		}
+		var err error
+		r, err = get(tx, id)
+		return err
+	})
+	return r, err
+}
+
+// Get returns the records with the given IDs, skipping unknown ones.
+func (db *DB) Get(ids ...uint64) ([]Record, error) {
+	var out []Record
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			r, err := get(tx, itob(id))
+			if err != nil {
+				return err
+			}
+			if r != nil {
+				out = append(out, *r)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// List returns up to limit records (all if limit <= 0), newest first.
+func (db *DB) List(limit int) ([]Record, error) {
+	var out []Record
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(recordsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("history record %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			out = append(out, r)
+			if limit > 0 && len(out) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// UpdatePaths replaces the paths of saved files (old path -> new path), e.g. after the files
+// were moved to another output folder. Returns the number of records changed.
+func (db *DB) UpdatePaths(moved map[string]string) (int, error) {
+	if len(moved) == 0 {
+		return 0, nil
+	}
+	n := 0
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(recordsBucket)
+		updated := make(map[string][]byte)
+		c := rb.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("history record %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			changed := false
+			for i, f := range r.Files {
+				if to, ok := moved[f.Path]; ok {
+					r.Files[i].Path = to
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			updated[string(k)] = data
+		}
+		// Written after the loop: a cursor must not be used across changes to its bucket
+		for k, data := range updated {
+			if err := rb.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		n = len(updated)
+		return nil
+	})
+	return n, err
+}
+
+func get(tx *bolt.Tx, key []byte) (*Record, error) {
+	v := tx.Bucket(recordsBucket).Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	var r Record
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// itob encodes an ID as a big-endian key, so cursor order is insertion order.
+func itob(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
@@ -0,0 +1,125 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func openTest(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestExtractedDedupe(t *testing.T) {
+	db := openTest(t)
+	records := []*Record{
+		{UID: 1, MessageID: "a@club.org", Outcome: OutcomeFailed},
+		{UID: 2, MessageID: "b@club.org", Outcome: OutcomeNoFiles},
+		{UID: 3, MessageID: "a@club.org", Outcome: OutcomeExtracted, Files: []File{{Name: "a.igc", Path: "/out/a.igc"}}},
+		{UID: 4, Outcome: OutcomeExtracted}, // no Message-ID
+	}
+	if err := db.Add(records); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range records {
+		if r.ID != uint64(i+1) || r.ProcessedAt.IsZero() {
+			t.Errorf("record %d: ID %d, processed at %v", i, r.ID, r.ProcessedAt)
+		}
+	}
+
+	tests := []struct {
+		messageID string
+		wantUID   uint32 // 0 for none
+	}{
+		{"a@club.org", 3}, // the extracted record, not the failed one before it
+		{"b@club.org", 0}, // processed without files: extracting it again is not a duplicate
+		{"c@club.org", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		r, err := db.Extracted(tt.messageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got uint32
+		if r != nil {
+			got = r.UID
+		}
+		if got != tt.wantUID {
+			t.Errorf("Extracted(%q) = UID %d, want %d", tt.messageID, got, tt.wantUID)
+		}
+	}
+
+	// A later extraction of the same message becomes the one reported
+	if err := db.Add([]*Record{{UID: 9, MessageID: "a@club.org", Outcome: OutcomeExtracted}}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := db.Extracted("a@club.org"); r == nil || r.UID != 9 {
+		t.Errorf("Extracted after reprocessing = %+v, want UID 9", r)
+	}
+}
+
+func TestUpdatePaths(t *testing.T) {
+	db := openTest(t)
+	records := []*Record{
+		{MessageID: "a@club.org", Outcome: OutcomeExtracted, Files: []File{
+			{Name: "a.igc", Path: "/old/a.igc"},
+			{Name: "a.gpx", Path: "/old/a.gpx"},
+		}},
+		{MessageID: "b@club.org", Outcome: OutcomeExtracted, Files: []File{{Name: "b.igc", Path: "/elsewhere/b.igc"}}},
+		{MessageID: "c@club.org", Outcome: OutcomeSkipped},
+	}
+	if err := db.Add(records); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.UpdatePaths(map[string]string{"/old/a.igc": "/new/a.igc", "/old/a.gpx": "/new/a.gpx", "/old/gone.igc": "/new/gone.igc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("UpdatePaths changed %d records, want 1", n)
+	}
+	got, err := db.Get(1, 2, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Get returned %d records, want 2", len(got))
+	}
+	if p := got[0].Files; p[0].Path != "/new/a.igc" || p[1].Path != "/new/a.gpx" {
+		t.Errorf("moved files = %+v", p)
+	}
+	if p := got[1].Files[0].Path; p != "/elsewhere/b.igc" {
+		t.Errorf("unrelated file moved to %s", p)
+	}
+	// The dedupe index still points at the updated record
+	if r, _ := db.Extracted("a@club.org"); r == nil || r.Files[0].Path != "/new/a.igc" {
+		t.Errorf("Extracted after move = %+v", r)
+	}
+
+	if n, err := db.UpdatePaths(nil); n != 0 || err != nil {
+		t.Errorf("UpdatePaths(nil) = %d, %v", n, err)
+	}
+}
+
+func TestList(t *testing.T) {
+	db := openTest(t)
+	for i := uint32(1); i <= 3; i++ {
+		if err := db.Add([]*Record{{UID: i, Outcome: OutcomeNoFiles}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := db.List(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].UID != 3 || got[1].UID != 2 {
+		t.Errorf("List(2) = %+v, want the newest two", got)
+	}
+}
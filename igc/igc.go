@@ -0,0 +1,224 @@
+package igc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fix is a single B record (position fix) from an IGC file.
+type Fix struct {
+	Time        time.Time
+	Lat         float64 // decimal degrees, negative for south
+	Lon         float64 // decimal degrees, negative for west
+	Valid       bool    // 'A' (3D fix) validity flag
+	PressureAlt int     // metres, barometric
+	GNSSAlt     int     // metres, GPS
+}
+
+// Altitude returns the GNSS altitude, falling back to pressure altitude when the logger recorded none.
+func (f Fix) Altitude() int {
+	if f.GNSSAlt != 0 {
+		return f.GNSSAlt
+	}
+	return f.PressureAlt
+}
+
+// Waypoint is a task declaration point (C record).
+type Waypoint struct {
+	Lat  float64
+	Lon  float64
+	Name string
+}
+
+// Flight holds the parts of an IGC file used by the app (headers, track and declared task).
+type Flight struct {
+	Manufacturer  string // three-letter manufacturer code from the A record
+	LoggerSerial  string // logger serial from the A record
+	Date          time.Time
+	Pilot         string
+	GliderType    string
+	GliderID      string
+	CompetitionID string
+	Fixes         []Fix
+	Task          []Waypoint
+}
+
+// ParseFile opens and parses an IGC file.
+func ParseFile(path string) (*Flight, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads an IGC file. Unknown or malformed records are skipped; only a missing track is an error.
+func Parse(r io.Reader) (*Flight, error) {
+	fl := &Flight{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 1024), 1024*1024)
+	var (
+		day     time.Time
+		last    time.Time
+		hasTask bool
+	)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r\n ")
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 'A':
+			if len(line) >= 4 {
+				fl.Manufacturer = line[1:4]
+			}
+			if len(line) >= 7 {
+				fl.LoggerSerial = line[4:7]
+			}
+		case 'H':
+			parseHeader(fl, line)
+			day = fl.Date
+		case 'B':
+			fix, ok := parseFix(line, day)
+			if !ok {
+				continue
+			}
+			// Flights crossing midnight UTC keep the HFDTE date; roll the day over.
+			if !last.IsZero() && fix.Time.Before(last.Add(-time.Hour)) {
+				day = day.AddDate(0, 0, 1)
+				fix.Time = fix.Time.AddDate(0, 0, 1)
+			}
+			last = fix.Time
+			fl.Fixes = append(fl.Fixes, fix)
+		case 'C':
+			// The first C record is the declaration header (dates, turnpoint count), the rest are points.
+			if !hasTask {
+				hasTask = true
+				continue
+			}
+			if wp, ok := parseWaypoint(line); ok {
+				fl.Task = append(fl.Task, wp)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(fl.Fixes) == 0 {
+		return nil, fmt.Errorf("igc: no position fixes found")
+	}
+	return fl, nil
+}
+
+func parseHeader(fl *Flight, line string) {
+	if len(line) < 5 {
+		return
+	}
+	code := strings.ToUpper(line[2:5])
+	value := line[5:]
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		value = value[i+1:]
+	}
+	value = strings.TrimSpace(value)
+	switch code {
+	case "DTE":
+		// "HFDTE150723" or "HFDTEDATE:150723,01"
+		if i := strings.IndexByte(value, ','); i >= 0 {
+			value = value[:i]
+		}
+		if len(value) >= 6 {
+			if d, err := time.Parse("020106", value[:6]); err == nil {
+				fl.Date = d
+			}
+		}
+	case "PLT":
+		fl.Pilot = value
+	case "GTY":
+		fl.GliderType = value
+	case "GID":
+		fl.GliderID = value
+	case "CID":
+		fl.CompetitionID = value
+	}
+}
+
+// parseFix decodes "B HHMMSS DDMMmmmN DDDMMmmmE V PPPPP GGGGG".
+func parseFix(line string, day time.Time) (Fix, bool) {
+	if len(line) < 35 {
+		return Fix{}, false
+	}
+	hh, err1 := strconv.Atoi(line[1:3])
+	mm, err2 := strconv.Atoi(line[3:5])
+	ss, err3 := strconv.Atoi(line[5:7])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return Fix{}, false
+	}
+	lat, ok := parseCoord(line[7:15], 2)
+	if !ok {
+		return Fix{}, false
+	}
+	lon, ok := parseCoord(line[15:24], 3)
+	if !ok {
+		return Fix{}, false
+	}
+	pAlt, _ := strconv.Atoi(line[25:30])
+	gAlt, _ := strconv.Atoi(line[30:35])
+	return Fix{
+		Time:        time.Date(day.Year(), day.Month(), day.Day(), hh, mm, ss, 0, time.UTC),
+		Lat:         lat,
+		Lon:         lon,
+		Valid:       line[24] == 'A',
+		PressureAlt: pAlt,
+		GNSSAlt:     gAlt,
+	}, true
+}
+
+// parseWaypoint decodes "C DDMMmmmN DDDMMmmmE name".
+func parseWaypoint(line string) (Waypoint, bool) {
+	if len(line) < 18 {
+		return Waypoint{}, false
+	}
+	lat, ok := parseCoord(line[1:9], 2)
+	if !ok {
+		return Waypoint{}, false
+	}
+	lon, ok := parseCoord(line[9:18], 3)
+	if !ok {
+		return Waypoint{}, false
+	}
+	// Unset points (take-off/landing) are declared as 0000000N00000000E.
+	if lat == 0 && lon == 0 {
+		return Waypoint{}, false
+	}
+	return Waypoint{Lat: lat, Lon: lon, Name: strings.TrimSpace(line[18:])}, true
+}
+
+// parseCoord decodes degrees (degDigits wide), minutes with three implied decimals, and a hemisphere letter.
+func parseCoord(s string, degDigits int) (float64, bool) {
+	if len(s) != degDigits+6 {
+		return 0, false
+	}
+	deg, err := strconv.Atoi(s[:degDigits])
+	if err != nil {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(s[degDigits : degDigits+5])
+	if err != nil {
+		return 0, false
+	}
+	v := float64(deg) + float64(minutes)/1000/60
+	switch s[len(s)-1] {
+	case 'S', 'W':
+		v = -v
+	case 'N', 'E':
+	default:
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,125 @@
+package igc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const sample = "AXCT001abc\r\n" +
+	"HFDTEDATE:150723,01\r\n" +
+	"HFPLTPILOTINCHARGE: Jane Doe\r\n" +
+	"HFGTYGLIDERTYPE:Ozone Rush\r\n" +
+	"HFGIDGLIDERID:D-1234\r\n" +
+	"HFCIDCOMPETITIONID:42\r\n" +
+	"C150723000000150723000102\r\n" +
+	"C0000000N00000000E\r\n" +
+	"C4730000N00830000ETP1\r\n" +
+	"C4700000S01230000WTP2\r\n" +
+	"B2359004730000N00830000EA0100001050\r\n" +
+	"Bnot a fix\r\n" +
+	"B0000104730500N00830500EV0110000000\r\n"
+
+func TestParse(t *testing.T) {
+	fl, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fl.Manufacturer != "XCT" || fl.LoggerSerial != "001" {
+		t.Errorf("A record = %q %q", fl.Manufacturer, fl.LoggerSerial)
+	}
+	if want := time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC); !fl.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", fl.Date, want)
+	}
+	if fl.Pilot != "Jane Doe" || fl.GliderType != "Ozone Rush" || fl.GliderID != "D-1234" || fl.CompetitionID != "42" {
+		t.Errorf("headers = %q %q %q %q", fl.Pilot, fl.GliderType, fl.GliderID, fl.CompetitionID)
+	}
+
+	// The unset take-off point is dropped
+	if len(fl.Task) != 2 || fl.Task[0] != (Waypoint{47.5, 8.5, "TP1"}) || fl.Task[1] != (Waypoint{-47, -12.5, "TP2"}) {
+		t.Errorf("Task = %+v", fl.Task)
+	}
+
+	if len(fl.Fixes) != 2 {
+		t.Fatalf("%d fixes, want 2 (the malformed one skipped)", len(fl.Fixes))
+	}
+	first, second := fl.Fixes[0], fl.Fixes[1]
+	if want := time.Date(2023, 7, 15, 23, 59, 0, 0, time.UTC); !first.Time.Equal(want) {
+		t.Errorf("first fix at %v, want %v", first.Time, want)
+	}
+	// Past midnight UTC the fix belongs to the next day
+	if want := time.Date(2023, 7, 16, 0, 0, 10, 0, time.UTC); !second.Time.Equal(want) {
+		t.Errorf("second fix at %v, want %v", second.Time, want)
+	}
+	if !first.Valid || second.Valid {
+		t.Errorf("validity = %v, %v", first.Valid, second.Valid)
+	}
+	if first.Lat != 47.5 || first.Lon != 8.5 || first.PressureAlt != 1000 || first.GNSSAlt != 1050 {
+		t.Errorf("first fix = %+v", first)
+	}
+}
+
+func TestParseNoFixes(t *testing.T) {
+	if _, err := Parse(strings.NewReader("AXCT001\r\nHFDTE150723\r\n")); err == nil {
+		t.Error("Parse succeeded without B records")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		line string
+		want time.Time
+	}{
+		{"HFDTE150723", time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"HFDTEDATE:010124,02", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"hfdte311299", time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"HFDTE9999", time.Time{}},
+		{"HFDTEDATE:320123", time.Time{}},
+	}
+	for _, tt := range tests {
+		fl := &Flight{}
+		parseHeader(fl, tt.line)
+		if !fl.Date.Equal(tt.want) {
+			t.Errorf("%s: date %v, want %v", tt.line, fl.Date, tt.want)
+		}
+	}
+}
+
+func TestParseCoord(t *testing.T) {
+	tests := []struct {
+		s         string
+		degDigits int
+		want      float64
+		ok        bool
+	}{
+		{"4730000N", 2, 47.5, true},
+		{"4730000S", 2, -47.5, true},
+		{"00830000E", 3, 8.5, true},
+		{"17959999W", 3, -(179 + 59.999/60), true},
+		{"4730000X", 2, 0, false},
+		{"47300N", 2, 0, false},
+		{"4a30000N", 2, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseCoord(tt.s, tt.degDigits)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseCoord(%q) = %v, %v; want %v, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestAltitude(t *testing.T) {
+	tests := []struct {
+		fix  Fix
+		want int
+	}{
+		{Fix{PressureAlt: 1000, GNSSAlt: 1050}, 1050},
+		{Fix{PressureAlt: 1000}, 1000},
+		{Fix{}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.fix.Altitude(); got != tt.want {
+			t.Errorf("%+v.Altitude() = %d, want %d", tt.fix, got, tt.want)
+		}
+	}
+}
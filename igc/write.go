@@ -0,0 +1,83 @@
+package igc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Write encodes the flight as an IGC file (A, H, C and B records). Used when converting tracks
+// from other formats, so only the fields the parser reads back are written.
+func Write(w io.Writer, fl *Flight) error {
+	bw := bufio.NewWriter(w)
+	manufacturer := fl.Manufacturer
+	if len(manufacturer) != 3 {
+		manufacturer = "XXX" // IGC code for "no manufacturer"
+	}
+	serial := fl.LoggerSerial
+	if serial == "" {
+		serial = "000"
+	}
+	fmt.Fprintf(bw, "A%s%s\r\n", manufacturer, serial)
+
+	date := fl.Date
+	if date.IsZero() && len(fl.Fixes) > 0 {
+		date = fl.Fixes[0].Time.UTC()
+	}
+	fmt.Fprintf(bw, "HFDTEDATE:%s,01\r\n", date.Format("020106"))
+	fmt.Fprintf(bw, "HFPLTPILOTINCHARGE:%s\r\n", fl.Pilot)
+	fmt.Fprintf(bw, "HFGTYGLIDERTYPE:%s\r\n", fl.GliderType)
+	fmt.Fprintf(bw, "HFGIDGLIDERID:%s\r\n", fl.GliderID)
+	if fl.CompetitionID != "" {
+		fmt.Fprintf(bw, "HFCIDCOMPETITIONID:%s\r\n", fl.CompetitionID)
+	}
+
+	if len(fl.Task) > 0 {
+		turnpoints := len(fl.Task) - 2
+		if turnpoints < 0 {
+			turnpoints = 0
+		}
+		fmt.Fprintf(bw, "C%s000000%s0001%02d\r\n", date.Format("020106"), date.Format("020106"), turnpoints)
+		for _, wp := range fl.Task {
+			fmt.Fprintf(bw, "C%s%s%s\r\n", formatCoord(wp.Lat, 2, 'N', 'S'), formatCoord(wp.Lon, 3, 'E', 'W'), wp.Name)
+		}
+	}
+
+	for _, fix := range fl.Fixes {
+		validity := 'V'
+		if fix.Valid {
+			validity = 'A'
+		}
+		t := fix.Time.UTC()
+		fmt.Fprintf(bw, "B%02d%02d%02d%s%s%c%s%s\r\n",
+			t.Hour(), t.Minute(), t.Second(),
+			formatCoord(fix.Lat, 2, 'N', 'S'), formatCoord(fix.Lon, 3, 'E', 'W'),
+			validity, formatAlt(fix.PressureAlt), formatAlt(fix.GNSSAlt))
+	}
+	return bw.Flush()
+}
+
+// formatCoord is the inverse of parseCoord: degrees, minutes * 1000, hemisphere.
+func formatCoord(v float64, degDigits int, pos, neg byte) string {
+	hemi := pos
+	if v < 0 {
+		hemi = neg
+		v = -v
+	}
+	deg := math.Floor(v)
+	milliMinutes := int(math.Round((v - deg) * 60 * 1000))
+	if milliMinutes == 60000 {
+		deg++
+		milliMinutes = 0
+	}
+	return fmt.Sprintf("%0*d%05d%c", degDigits, int(deg), milliMinutes, hemi)
+}
+
+// formatAlt writes a five-character altitude; negative values use a leading minus sign.
+func formatAlt(alt int) string {
+	if alt < 0 {
+		return fmt.Sprintf("-%04d", -alt)
+	}
+	return fmt.Sprintf("%05d", alt)
+}
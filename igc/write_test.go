@@ -0,0 +1,105 @@
+package igc
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	start := time.Date(2023, 7, 15, 23, 59, 30, 0, time.UTC)
+	in := &Flight{
+		Manufacturer:  "XCT",
+		LoggerSerial:  "001",
+		Date:          time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC),
+		Pilot:         "Jane Doe",
+		GliderType:    "Ozone Rush",
+		GliderID:      "D-1234",
+		CompetitionID: "42",
+		Fixes: []Fix{
+			{Time: start, Lat: 47.5, Lon: 8.5, Valid: true, PressureAlt: 1000, GNSSAlt: 1050},
+			{Time: start.Add(time.Minute), Lat: -33.8568, Lon: -151.2153, PressureAlt: -12, GNSSAlt: 3},
+		},
+		Task: []Waypoint{{Lat: 47.5, Lon: 8.5, Name: "Start"}, {Lat: 46.25, Lon: 7.75, Name: "Goal"}},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Manufacturer != in.Manufacturer || out.LoggerSerial != in.LoggerSerial || !out.Date.Equal(in.Date) ||
+		out.Pilot != in.Pilot || out.GliderType != in.GliderType || out.GliderID != in.GliderID || out.CompetitionID != in.CompetitionID {
+		t.Errorf("headers = %+v", out)
+	}
+	if !reflect.DeepEqual(out.Task, in.Task) {
+		t.Errorf("Task = %+v, want %+v", out.Task, in.Task)
+	}
+	if len(out.Fixes) != len(in.Fixes) {
+		t.Fatalf("%d fixes, want %d", len(out.Fixes), len(in.Fixes))
+	}
+	for i, want := range in.Fixes {
+		got := out.Fixes[i]
+		// Minutes are written with three decimals: about 2 m
+		if !got.Time.Equal(want.Time) || math.Abs(got.Lat-want.Lat) > 1e-5 || math.Abs(got.Lon-want.Lon) > 1e-5 ||
+			got.Valid != want.Valid || got.PressureAlt != want.PressureAlt || got.GNSSAlt != want.GNSSAlt {
+			t.Errorf("fix %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestWriteDefaults(t *testing.T) {
+	fix := Fix{Time: time.Date(2024, 5, 11, 10, 0, 0, 0, time.UTC), Lat: 1, Lon: 2, Valid: true}
+	var buf bytes.Buffer
+	if err := Write(&buf, &Flight{Fixes: []Fix{fix}}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Converted tracks have no logger; the date comes from the first fix
+	if out.Manufacturer != "XXX" || out.LoggerSerial != "000" || !out.Date.Equal(time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("defaults = %q %q %v", out.Manufacturer, out.LoggerSerial, out.Date)
+	}
+}
+
+func TestFormatCoord(t *testing.T) {
+	tests := []struct {
+		v         float64
+		degDigits int
+		want      string
+	}{
+		{47.5, 2, "4730000N"},
+		{-47.5, 2, "4730000S"},
+		{8.5, 3, "00830000E"},
+		{-0.0001, 3, "00000006W"},
+		{9.9999999, 3, "01000000E"}, // minutes round up to a whole degree
+	}
+	for _, tt := range tests {
+		got := formatCoord(tt.v, tt.degDigits, "NE"[tt.degDigits-2], "SW"[tt.degDigits-2])
+		if got != tt.want {
+			t.Errorf("formatCoord(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAlt(t *testing.T) {
+	tests := []struct {
+		alt  int
+		want string
+	}{
+		{1050, "01050"},
+		{0, "00000"},
+		{-12, "-0012"},
+	}
+	for _, tt := range tests {
+		if got := formatAlt(tt.alt); got != tt.want {
+			t.Errorf("formatAlt(%d) = %q, want %q", tt.alt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"igcmailimap/config"
+	"igcmailimap/source"
+
+	"github.com/emersion/go-imap"
+)
+
+// reportedCaps are the capabilities a connection test reports, besides the AUTH= mechanisms.
+var reportedCaps = map[string]bool{
+	"IDLE": true, "MOVE": true, "CONDSTORE": true, "QRESYNC": true, "UIDPLUS": true,
+	"SPECIAL-USE": true, "LOGINDISABLED": true,
+}
+
+// maxStatusFolders bounds the STATUS commands a connection test sends.
+const maxStatusFolders = 100
+
+// Diagnose connects, reads the capabilities, logs in and counts the messages in each folder.
+func (f *Fetcher) Diagnose(ctx context.Context) *source.Diagnosis {
+	d := &source.Diagnosis{}
+	fail := func(name string, err error) *source.Diagnosis {
+		d.Steps = append(d.Steps, source.Step{Name: name, Err: err})
+		return d
+	}
+	if !f.configured() {
+		return fail(source.StepConnect, fmt.Errorf("IMAP server, user and password must be set"))
+	}
+
+	addr := config.WithDefaultPort(f.cfg.IMAPServer, config.ProtocolIMAP, f.cfg.Security)
+	s, err := dial(ctx, f.cfg, f.log)
+	if err != nil {
+		return fail(source.StepConnect, err)
+	}
+	defer s.logout()
+	c := s.Client
+	over := "TLS"
+	if f.cfg.Security == config.SecurityStartTLS {
+		over = "STARTTLS"
+	}
+	d.Steps = append(d.Steps, source.Step{Name: source.StepConnect, Detail: addr + " over " + over})
+
+	caps, err := c.Capability()
+	if err != nil {
+		return fail(source.StepCapabilities, err)
+	}
+	for name := range caps {
+		if mech, ok := strings.CutPrefix(name, "AUTH="); ok {
+			d.AuthMechanisms = append(d.AuthMechanisms, mech)
+		} else if reportedCaps[name] {
+			d.Capabilities = append(d.Capabilities, name)
+		}
+	}
+	sort.Strings(d.Capabilities)
+	sort.Strings(d.AuthMechanisms)
+	d.Steps = append(d.Steps, source.Step{Name: source.StepCapabilities, Detail: strings.Join(d.Capabilities, " ")})
+
+	s.setTimeout(loginTimeout)
+	if err := c.Login(f.cfg.IMAPUser, f.cfg.IMAPPassword); err != nil {
+		return fail(source.StepLogin, err)
+	}
+	d.Steps = append(d.Steps, source.Step{Name: source.StepLogin, Detail: "as " + f.cfg.IMAPUser})
+
+	s.setTimeout(fetchTimeout)
+	mailboxes, err := listMailboxes(c)
+	if err != nil {
+		return fail(source.StepFolders, err)
+	}
+	for _, m := range mailboxes {
+		if len(d.Folders) == maxStatusFolders || ctx.Err() != nil {
+			break
+		}
+		if hasAttr(m, imap.NoSelectAttr) {
+			continue
+		}
+		status, err := c.Status(m.Name, []imap.StatusItem{imap.StatusMessages})
+		if err != nil {
+			continue // some servers refuse STATUS on special folders
+		}
+		d.Folders = append(d.Folders, source.Folder{Name: m.Name, Messages: status.Messages})
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(source.StepFolders, err)
+	}
+	d.Steps = append(d.Steps, source.Step{Name: source.StepFolders, Detail: fmt.Sprintf("%d folder(s)", len(mailboxes))})
+	return d
+}
+
+func hasAttr(m *imap.MailboxInfo, attr string) bool {
+	for _, a := range m.Attributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,20 @@
 package imap
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"igcmailimap/config"
+	"igcmailimap/logger"
+	"igcmailimap/source"
 	"igcmailimap/state"
 
 	"github.com/emersion/go-imap"
@@ -12,84 +22,395 @@ import (
 )
 
 // Fetcher connects to IMAP over TLS, selects Inbox, and fetches new messages by UID.
+// It implements source.Source, source.RangeFetcher, source.UIDFetcher and source.FolderLister.
 type Fetcher struct {
 	cfg   *config.Config
 	state *state.State
+	log   *logger.Logger
 }
 
 // FetchedMessage holds raw RFC822 body for a message (for extraction).
-type FetchedMessage struct {
-	UID     uint32
-	Subject string
-	From    string
-	Body    []byte
+type FetchedMessage = source.Message
+
+// NewFetcher returns a fetcher for the given config and state that logs to log (may be nil).
+func NewFetcher(cfg *config.Config, st *state.State, log *logger.Logger) *Fetcher {
+	return &Fetcher{cfg: cfg, state: st, log: log.With(logger.KeyAccount, cfg.IMAPUser)}
+}
+
+// rootCAs replaces the system certificate pool when set (tests against a stub server).
+var rootCAs *x509.CertPool
+
+// Timeouts so a dead or hung server does not block a poll forever; stop and quit cancel
+// sooner through the context. They limit how long the server may stay silent, not how long a
+// command takes: a large FETCH that keeps receiving data is never cut off.
+const (
+	dialTimeout  = 30 * time.Second // TCP, TLS handshake, greeting and STARTTLS
+	loginTimeout = 30 * time.Second // LOGIN and SELECT
+	fetchTimeout = 2 * time.Minute  // SEARCH, FETCH and LIST
+)
+
+// fetchBatch is the number of messages fetched per UID FETCH command, so that a failure loses
+// only the batch in progress.
+const fetchBatch = 50
+
+// idleConn moves its deadline forward on every read and write, so only a silent server times
+// out. go-imap's Client.Timeout is not used: it sets one deadline for a whole command, and its
+// SetDeadline calls are ignored here.
+type idleConn struct {
+	net.Conn
+	timeout atomic.Int64 // time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(time.Duration(c.timeout.Load())))
+	return c.Conn.Read(b)
+}
+
+// Write also extends the read deadline, so the wait for the reply starts with the command.
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(time.Duration(c.timeout.Load())))
+	return c.Conn.Write(b)
+}
+
+func (c *idleConn) SetDeadline(time.Time) error { return nil }
+
+// session is a logged-in connection that is closed as soon as its context is done, which
+// makes the pending command fail.
+type session struct {
+	*client.Client
+	conn *idleConn
+	ctx  context.Context
+	stop func() bool // unregisters the close on cancellation
+	log  *logger.Logger
+}
+
+// setTimeout sets how long the server may stay silent from now on.
+func (s *session) setTimeout(d time.Duration) {
+	s.conn.timeout.Store(int64(d))
+}
+
+// logout ends the session; a cancelled session is just closed.
+func (s *session) logout() {
+	s.stop()
+	if s.ctx.Err() != nil {
+		s.Terminate()
+		return
+	}
+	if err := s.Logout(); err != nil && err != io.EOF {
+		s.log.Warning("IMAP logout failed", logger.KeyError, err)
+	}
+}
+
+// connect dials over TLS (or STARTTLS), logs in and selects INBOX. The caller must log out.
+func (f *Fetcher) connect(ctx context.Context) (*session, *imap.MailboxStatus, error) {
+	s, err := dial(ctx, f.cfg, f.log)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.setTimeout(loginTimeout)
+	if err := s.Login(f.cfg.IMAPUser, f.cfg.IMAPPassword); err != nil {
+		s.logout()
+		return nil, nil, err
+	}
+	mbox, err := s.Select("INBOX", false)
+	if err != nil {
+		s.logout()
+		return nil, nil, err
+	}
+	s.setTimeout(fetchTimeout)
+	return s, mbox, nil
+}
+
+// dial connects and reads the greeting, upgrading with STARTTLS if configured. The connection
+// is closed when ctx is done; the caller must log out of the returned session.
+func dial(ctx context.Context, cfg *config.Config, log *logger.Logger) (*session, error) {
+	addr := config.WithDefaultPort(cfg.IMAPServer, config.ProtocolIMAP, cfg.Security)
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: rootCAs}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var raw net.Conn
+	var err error
+	if cfg.Security == config.SecurityStartTLS {
+		raw, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		raw, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Under STARTTLS the TLS layer reads and writes through conn, so it keeps the deadlines
+	conn := &idleConn{Conn: raw}
+	conn.timeout.Store(int64(dialTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	c, err := client.New(conn)
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	if cfg.Security == config.SecurityStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			stop()
+			c.Terminate()
+			return nil, fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	return &session{Client: c, conn: conn, ctx: ctx, stop: stop, log: log}, nil
 }
 
-// NewFetcher returns a fetcher for the given config and state.
-func NewFetcher(cfg *config.Config, st *state.State) *Fetcher {
-	return &Fetcher{cfg: cfg, state: st}
+func (f *Fetcher) configured() bool {
+	return f.cfg.IMAPServer != "" && f.cfg.IMAPUser != "" && f.cfg.IMAPPassword != ""
 }
 
 // FetchNew connects, selects INBOX, fetches messages with UID > state.LastUID, and returns their bodies.
 // State is updated to the max UID fetched so each mail is only ever fetched once (last email is not re-processed every poll).
-func (f *Fetcher) FetchNew() ([]FetchedMessage, error) {
-	if f.cfg.IMAPServer == "" || f.cfg.IMAPUser == "" || f.cfg.IMAPPassword == "" {
+// On the very first run (no saved state) config.BackfillMode decides what is imported.
+// Messages are fetched in batches; if one fails, those of the batches before it are returned
+// with the error and the state continues after them.
+func (f *Fetcher) FetchNew(ctx context.Context) ([]FetchedMessage, error) {
+	if !f.configured() {
 		return nil, nil // no config, skip
 	}
 
-	c, err := client.DialTLS(f.cfg.IMAPServer, nil)
+	c, mbox, err := f.connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := c.Logout(); err != nil && err != io.EOF {
-			log.Printf("IMAP Logout: %v", err)
+	defer c.logout()
+
+	path, _ := config.StatePath()
+	if v := mbox.UidValidity; v != f.state.UIDValidity {
+		if f.state.UIDValidity != 0 {
+			// The mailbox was recreated (e.g. moved to another server): the saved UIDs now refer
+			// to other messages, so start over as on the first run. The history keeps messages
+			// extracted before from being extracted again.
+			f.log.Warning("IMAP INBOX UIDVALIDITY changed, fetching as on the first run", "old", f.state.UIDValidity, "new", v)
+			f.state.ResetUIDs(v)
+		} else {
+			f.state.UIDValidity = v // saved with the position
 		}
-	}()
+	}
+	if f.state.NeedsBackfill() {
+		return f.backfill(c.Client, mbox, path)
+	}
 
-	if err := c.Login(f.cfg.IMAPUser, f.cfg.IMAPPassword); err != nil {
+	// Search first: "UID n:*" also matches the last message when there is nothing newer
+	found, err := c.UidSearch(&imap.SearchCriteria{Uid: f.state.UIDSet()})
+	if err != nil {
 		return nil, err
 	}
+	var newer []uint32
+	for _, u := range found {
+		if u > f.state.LastUID {
+			newer = append(newer, u)
+		}
+	}
+	out, err := fetchUIDs(c.Client, newer)
 
-	_, err = c.Select("INBOX", false)
+	// Advance state to max UID we fetched so we never re-fetch the same mail (fixes "last email always processed").
+	// Messages without a body are retried through state.Failed instead (see source.Message.Err).
+	// After an error the next poll fetches from the first batch that failed.
+	if len(out) > 0 {
+		if saveErr := state.UpdateLastUID(path, f.state, uids(out)); saveErr != nil {
+			f.log.Error("Failed to save state", logger.KeyError, saveErr)
+		}
+	}
+	return out, err
+}
+
+// backfill runs the first poll according to config.BackfillMode and marks the state initialized.
+func (f *Fetcher) backfill(c *client.Client, mbox *imap.MailboxStatus, statePath string) ([]FetchedMessage, error) {
+	// UIDNEXT-1 is the highest UID currently in the mailbox, so later polls only see newer mail.
+	// Servers that do not report UIDNEXT are asked for the UIDs of all messages instead; starting
+	// from 0 would import the whole mailbox on the next poll.
+	var current uint32
+	if mbox.UidNext > 0 {
+		current = mbox.UidNext - 1
+	} else {
+		all, err := c.UidSearch(&imap.SearchCriteria{}) // UID SEARCH ALL
+		if err != nil {
+			return nil, fmt.Errorf("UID SEARCH ALL: %w", err)
+		}
+		for _, u := range all {
+			current = max(current, u)
+		}
+	}
+
+	var out []FetchedMessage
+	var err error
+	switch f.cfg.BackfillMode {
+	case config.BackfillNow:
+		// Nothing to import
+	case config.BackfillSince:
+		since, dateErr := f.cfg.BackfillSinceDate()
+		if dateErr != nil {
+			return nil, fmt.Errorf("invalid backfill date %q: %w", f.cfg.BackfillSince, dateErr)
+		}
+		out, err = searchAndFetch(c, &imap.SearchCriteria{Since: since})
+	default: // config.BackfillAll and configs saved before backfill modes existed
+		out, err = searchAndFetch(c, &imap.SearchCriteria{})
+	}
+	if err != nil {
+		if len(out) == 0 {
+			return nil, err
+		}
+		// Continue after the last batch fetched: later polls fetch the rest as new mail
+		current = 0
+	}
+	for _, u := range uids(out) {
+		if u > current {
+			current = u
+		}
+	}
+	if saveErr := state.MarkInitialized(statePath, f.state, current); saveErr != nil {
+		f.log.Error("Failed to save state", logger.KeyError, saveErr)
+	}
+	return out, err
+}
+
+// FetchRange returns the messages received between since and before (before excluded; zero
+// values mean unbounded), for re-importing. It does not change the saved state.
+func (f *Fetcher) FetchRange(ctx context.Context, since, before time.Time) ([]FetchedMessage, error) {
+	if !f.configured() {
+		return nil, fmt.Errorf("IMAP server, user and password must be set")
+	}
+	c, _, err := f.connect(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer c.logout()
+	return searchAndFetch(c.Client, &imap.SearchCriteria{Since: since, Before: before})
+}
 
-	// Fetch all messages to work around potential IMAP server issues with UID ranges
-	seqSet := imap.SeqSet{}
-	seqSet.AddRange(1, 0) // 1:*
+// FetchUIDs returns the INBOX messages with the given UIDs (for reprocessing); UIDs no longer
+// on the server are left out. It does not change the saved state.
+func (f *Fetcher) FetchUIDs(ctx context.Context, uids []uint32) ([]FetchedMessage, error) {
+	if !f.configured() {
+		return nil, fmt.Errorf("IMAP server, user and password must be set")
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	c, _, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.logout()
+	return fetchUIDs(c.Client, uids)
+}
 
+// TestConnection logs in and selects INBOX, returning the number of messages it holds.
+func (f *Fetcher) TestConnection(ctx context.Context) (uint32, error) {
+	if !f.configured() {
+		return 0, fmt.Errorf("IMAP server, user and password must be set")
+	}
+	c, mbox, err := f.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.logout()
+	return mbox.Messages, nil
+}
+
+// ListFolders returns the names of all mailboxes on the server.
+func (f *Fetcher) ListFolders(ctx context.Context) ([]string, error) {
+	if !f.configured() {
+		return nil, fmt.Errorf("IMAP server, user and password must be set")
+	}
+	c, _, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.logout()
+
+	mailboxes, err := listMailboxes(c.Client)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(mailboxes))
+	for i, m := range mailboxes {
+		names[i] = m.Name
+	}
+	return names, nil
+}
+
+// listMailboxes returns all mailboxes on the server (LIST "" *).
+func listMailboxes(c *client.Client) ([]*imap.MailboxInfo, error) {
+	ch := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", "*", ch)
+	}()
+	var mailboxes []*imap.MailboxInfo
+	for m := range ch {
+		mailboxes = append(mailboxes, m)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return mailboxes, nil
+}
+
+// searchAndFetch runs UID SEARCH with the criteria and fetches the matching messages (see fetchUIDs).
+func searchAndFetch(c *client.Client, criteria *imap.SearchCriteria) ([]FetchedMessage, error) {
+	found, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	return fetchUIDs(c, found)
+}
+
+// fetchUIDs fetches the messages in ascending UID order, fetchBatch per command. If a batch
+// fails, the messages of the batches before it are returned with the error.
+func fetchUIDs(c *client.Client, uids []uint32) (out []FetchedMessage, err error) {
+	uids = append([]uint32(nil), uids...)
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	for start := 0; start < len(uids); start += fetchBatch {
+		seqSet := imap.SeqSet{}
+		seqSet.AddNum(uids[start:min(start+fetchBatch, len(uids))]...)
+		batch, err := fetchMessages(c, &seqSet)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, batch...)
+	}
+	return out, nil
+}
+
+// errNoBody is the source.Message.Err of messages the server returned without a body.
+var errNoBody = errors.New("server returned no message body")
+
+// fetchMessages UID FETCHes the set (full body, UID, envelope). Messages the server returns
+// without a body come with errNoBody. If the fetch fails or a body cannot be read, no message
+// is returned so the caller does not advance its state.
+func fetchMessages(c *client.Client, seqSet *imap.SeqSet) (out []FetchedMessage, err error) {
 	// Fetch full message body (RFC822) for the UID range
 	section := &imap.BodySectionName{}
 	items := []imap.FetchItem{section.FetchItem(), imap.FetchUid, imap.FetchEnvelope}
 
 	ch := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
 	go func() {
-		if err := c.UidFetch(&seqSet, items, ch); err != nil {
-			log.Printf("UidFetch: %v", err)
-		}
+		done <- c.UidFetch(seqSet, items, ch)
 	}()
 
-	var out []FetchedMessage
-	var fetchedUIDs []uint32
+	var readErr error
 	for msg := range ch {
 		if msg == nil {
 			continue
 		}
 
-		// Skip messages we've already processed
-		if msg.Uid <= f.state.LastUID {
-			continue
-		}
-
-		lit := msg.GetBody(section)
-		if lit == nil {
-			continue
-		}
-		body, err := io.ReadAll(lit)
-		if err != nil {
-			log.Printf("Read body UID %d: %v", msg.Uid, err)
+		var body []byte
+		var bodyErr error
+		if lit := msg.GetBody(section); lit == nil {
+			bodyErr = errNoBody
+		} else if body, err = io.ReadAll(lit); err != nil {
+			// Keep draining the channel so UidFetch can return
+			if readErr == nil {
+				readErr = fmt.Errorf("read body of UID %d: %w", msg.Uid, err)
+			}
 			continue
 		}
 
@@ -107,24 +428,33 @@ func (f *Fetcher) FetchNew() ([]FetchedMessage, error) {
 
 		out = append(out, FetchedMessage{
 			UID:     msg.Uid,
+			Folder:  "INBOX",
 			Subject: subject,
 			From:    from,
 			Body:    body,
+			Err:     bodyErr,
 		})
-		fetchedUIDs = append(fetchedUIDs, msg.Uid)
 	}
-
-	// Advance state to max UID we fetched so we never re-fetch the same mail (fixes "last email always processed").
-	if len(fetchedUIDs) > 0 {
-		path, _ := config.StatePath()
-		_ = state.UpdateLastUID(path, f.state, fetchedUIDs)
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
 	}
 	return out, nil
 }
 
+func uids(msgs []FetchedMessage) []uint32 {
+	out := make([]uint32, len(msgs))
+	for i := range msgs {
+		out[i] = msgs[i].UID
+	}
+	return out
+}
+
 // FetchNewBytes is a convenience that returns bodies as byte slices (same as FetchNew but typo-safe name).
-func (f *Fetcher) FetchNewBytes() ([][]byte, []uint32, error) {
-	msgs, err := f.FetchNew()
+func (f *Fetcher) FetchNewBytes(ctx context.Context) ([][]byte, []uint32, error) {
+	msgs, err := f.FetchNew(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
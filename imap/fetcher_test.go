@@ -0,0 +1,211 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"igcmailimap/config"
+	"igcmailimap/state"
+)
+
+// stubServer is go-imap's in-memory server over TLS. Its INBOX starts with one message, UID 6.
+type stubServer struct {
+	addr  string
+	inbox backend.Mailbox
+}
+
+func newStubServer(t *testing.T) *stubServer {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	rootCAs = pool
+	t.Cleanup(func() { rootCAs = nil })
+
+	be := memory.New()
+	user, err := be.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(be)
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return &stubServer{addr: ln.Addr().String(), inbox: inbox}
+}
+
+// add delivers n messages; the memory backend gives them the next UIDs.
+func (s *stubServer) add(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		body := fmt.Sprintf("From: pilot@club.org\r\nSubject: Flight %d\r\n\r\nflight\r\n", i)
+		if err := s.inbox.CreateMessage(nil, time.Now(), bytes.NewBufferString(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func testFetcher(t *testing.T, s *stubServer, mode string) *Fetcher {
+	t.Helper()
+	config.SetDir(t.TempDir())
+	cfg := &config.Config{
+		Security: config.SecurityTLS, IMAPServer: s.addr, IMAPUser: "username", IMAPPassword: "password",
+		BackfillMode: mode,
+	}
+	return NewFetcher(cfg, &state.State{}, nil)
+}
+
+func TestFetchNewBatches(t *testing.T) {
+	s := newStubServer(t)
+	s.add(t, 2*fetchBatch+3) // UIDs 7 to 109
+	f := testFetcher(t, s, config.BackfillAll)
+	ctx := context.Background()
+
+	msgs, err := f.FetchNew(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2*fetchBatch+4 {
+		t.Fatalf("first poll fetched %d messages, want all %d", len(msgs), 2*fetchBatch+4)
+	}
+	for i, m := range msgs {
+		if want := uint32(6 + i); m.UID != want || len(m.Body) == 0 {
+			t.Fatalf("message %d: UID %d with %d bytes, want UID %d with a body", i, m.UID, len(m.Body), want)
+		}
+	}
+	if msgs[1].Subject != "Flight 0" || msgs[1].From != "pilot@club.org" {
+		t.Errorf("envelope = %q from %q", msgs[1].Subject, msgs[1].From)
+	}
+	if f.state.LastUID != 109 || !f.state.Initialized {
+		t.Errorf("state: last UID %d, initialized %v; want 109, true", f.state.LastUID, f.state.Initialized)
+	}
+
+	// "UID 110:*" matches the last message when nothing is newer: it must not come back
+	msgs, err = f.FetchNew(ctx)
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("second poll = %d messages, %v; want none", len(msgs), err)
+	}
+
+	s.add(t, 1)
+	msgs, err = f.FetchNew(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].UID != 110 {
+		t.Errorf("third poll = %v, want UID 110", uids(msgs))
+	}
+	path, _ := config.StatePath()
+	if st, err := state.Load(path, ""); err != nil || st.LastUID != 110 {
+		t.Errorf("saved last UID = %d, %v; want 110", st.LastUID, err)
+	}
+}
+
+func TestBackfillNow(t *testing.T) {
+	s := newStubServer(t)
+	s.add(t, 3)
+	f := testFetcher(t, s, config.BackfillNow)
+	msgs, err := f.FetchNew(context.Background())
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("first poll = %d messages, %v; want none", len(msgs), err)
+	}
+	if f.state.LastUID != 9 {
+		t.Errorf("last UID = %d, want 9 (the newest message)", f.state.LastUID)
+	}
+}
+
+func TestFetchUIDs(t *testing.T) {
+	s := newStubServer(t)
+	s.add(t, 3)
+	f := testFetcher(t, s, config.BackfillAll)
+	msgs, err := f.FetchUIDs(context.Background(), []uint32{9, 100, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uids(msgs); len(got) != 2 || got[0] != 7 || got[1] != 9 {
+		t.Errorf("FetchUIDs = %v, want [7 9]", got)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &idleConn{Conn: client}
+	c.timeout.Store(int64(50 * time.Millisecond))
+	c.SetDeadline(time.Now()) // ignored: go-imap would set one deadline per command
+
+	// Data that keeps coming in is read however long it takes overall
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			server.Write([]byte("x"))
+		}
+	}()
+	buf := make([]byte, 1)
+	for i := 0; i < 4; i++ {
+		if _, err := c.Read(buf); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+	}
+	// A silent server times out
+	start := time.Now()
+	if _, err := c.Read(buf); err == nil {
+		t.Fatal("read from a silent server succeeded")
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read error %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timed out after %v", d)
+	}
+}
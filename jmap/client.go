@@ -0,0 +1,286 @@
+// Package jmap fetches mail over JMAP (RFC 8620/8621), e.g. from Fastmail or Stalwart: new mail is
+// found with Email/changes, only accepted attachments are downloaded as blobs, and an EventSource
+// connection signals new mail without polling.
+package jmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	capCore = "urn:ietf:params:jmap:core"
+	capMail = "urn:ietf:params:jmap:mail"
+)
+
+// requestTimeout bounds API calls and downloads; the EventSource connection has no timeout.
+const requestTimeout = 60 * time.Second
+
+// Session is the part of the JMAP session resource used here.
+type Session struct {
+	APIURL          string            `json:"apiUrl"`
+	DownloadURL     string            `json:"downloadUrl"`
+	EventSourceURL  string            `json:"eventSourceUrl"`
+	PrimaryAccounts map[string]string `json:"primaryAccounts"`
+}
+
+// Client talks to one JMAP server. Set HTTP to use a custom transport (e.g. for a stub server).
+type Client struct {
+	SessionURL string
+	User       string // HTTP Basic user; empty means Password is a Bearer API token
+	Password   string
+	HTTP       *http.Client
+
+	session   *Session
+	accountID string
+}
+
+// SessionURL returns the session resource for a server setting: a full URL is used as is,
+// a bare host (optionally with port) gets https:// and the /.well-known/jmap path.
+func SessionURL(server string) string {
+	if strings.HasPrefix(server, "http://") || strings.HasPrefix(server, "https://") {
+		return server
+	}
+	return "https://" + strings.TrimSuffix(server, "/") + "/.well-known/jmap"
+}
+
+// MethodError is a JMAP method-level error such as "cannotCalculateChanges".
+type MethodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+func (e *MethodError) Error() string {
+	if e.Description != "" {
+		return e.Type + ": " + e.Description
+	}
+	return e.Type
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return &http.Client{Timeout: requestTimeout}
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.Password)
+	}
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.authorize(req)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// Connect fetches the session resource and picks the primary mail account.
+func (c *Client) Connect(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.SessionURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var s Session
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return fmt.Errorf("invalid JMAP session: %w", err)
+	}
+	account := s.PrimaryAccounts[capMail]
+	if s.APIURL == "" || account == "" {
+		return fmt.Errorf("server has no JMAP mail account")
+	}
+	// Relative URLs are allowed in the session resource. They are joined by hand because
+	// url.URL.String would escape the {placeholders} of the URL templates.
+	base, err := url.Parse(c.SessionURL)
+	if err != nil {
+		return err
+	}
+	for _, u := range []*string{&s.APIURL, &s.DownloadURL, &s.EventSourceURL} {
+		if strings.HasPrefix(*u, "/") {
+			*u = base.Scheme + "://" + base.Host + *u
+		}
+	}
+	c.session, c.accountID = &s, account
+	return nil
+}
+
+// invocation is one [name, arguments, callId] method call or response.
+type invocation struct {
+	Name string
+	Args json.RawMessage
+	ID   string
+}
+
+func (i invocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{i.Name, i.Args, i.ID})
+}
+
+func (i *invocation) UnmarshalJSON(data []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid method response")
+	}
+	if err := json.Unmarshal(parts[0], &i.Name); err != nil {
+		return err
+	}
+	i.Args = parts[1]
+	return json.Unmarshal(parts[2], &i.ID)
+}
+
+// call runs one method with the account ID added to args and decodes its response into out.
+func (c *Client) call(ctx context.Context, method string, args map[string]interface{}, out interface{}) error {
+	args["accountId"] = c.accountID
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"using":       []string{capCore, capMail},
+		"methodCalls": []invocation{{Name: method, Args: raw, ID: "0"}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.session.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		MethodResponses []invocation `json:"methodResponses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	if len(result.MethodResponses) == 0 {
+		return fmt.Errorf("%s: empty response", method)
+	}
+	r := result.MethodResponses[0]
+	if r.Name == "error" {
+		var me MethodError
+		if err := json.Unmarshal(r.Args, &me); err != nil {
+			return fmt.Errorf("%s: invalid error response", method)
+		}
+		return &me
+	}
+	return json.Unmarshal(r.Args, out)
+}
+
+// Download fetches a blob through the session's download URL template.
+func (c *Client) Download(ctx context.Context, blobID, name, contentType string) ([]byte, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	u := strings.NewReplacer(
+		"{accountId}", url.PathEscape(c.accountID),
+		"{blobId}", url.PathEscape(blobID),
+		"{name}", url.PathEscape(name),
+		"{type}", url.QueryEscape(contentType),
+	).Replace(c.session.DownloadURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// events connects to the EventSource endpoint and calls changed for every "state" event until
+// ctx is cancelled or the connection drops.
+func (c *Client) events(ctx context.Context, changed func()) error {
+	if c.session.EventSourceURL == "" {
+		return fmt.Errorf("server does not offer push (no eventSourceUrl)")
+	}
+	u := strings.NewReplacer("{types}", "Email", "{closeafter}", "no", "{ping}", "60").Replace(c.session.EventSourceURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+	// No overall timeout: the stream stays open (the server pings every 60s)
+	httpClient := &http.Client{}
+	if c.HTTP != nil {
+		httpClient.Transport = c.HTTP.Transport
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("EventSource: %s", resp.Status)
+	}
+	return readEvents(resp.Body, func(event string) {
+		if event == "state" {
+			changed()
+		}
+	})
+}
+
+// readEvents parses a text/event-stream and calls fn with the type of each dispatched event.
+func readEvents(r io.Reader, fn func(event string)) error {
+	br := bufio.NewReader(r)
+	event, hasData := "", false
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("EventSource closed by server")
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if hasData {
+				if event == "" {
+					event = "message"
+				}
+				fn(event)
+			}
+			event, hasData = "", false
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			hasData = true
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package jmap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+
+	"igcmailimap/config"
+	"igcmailimap/extract"
+	"igcmailimap/logger"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// getBatch is the number of emails requested per Email/get call.
+const getBatch = 100
+
+// reconnectDelay is the pause before reconnecting a dropped EventSource connection.
+const reconnectDelay = 30 * time.Second
+
+// resyncMargin is how long before the last poll a resynchronisation looks for mail, to allow
+// for a difference between our clock and the server's.
+const resyncMargin = 10 * time.Minute
+
+// Fetcher fetches new Inbox mail from a JMAP server. It implements source.Source,
+// source.RangeFetcher, source.IDFetcher, source.FolderLister and source.Pusher.
+type Fetcher struct {
+	cfg   *config.Config
+	state *state.State
+	log   *logger.Logger
+}
+
+// NewFetcher returns a fetcher for the given config and state. cfg.IMAPServer holds the JMAP
+// session URL or host; with an empty cfg.IMAPUser the password is sent as a Bearer API token.
+// It logs to log (may be nil).
+func NewFetcher(cfg *config.Config, st *state.State, log *logger.Logger) *Fetcher {
+	return &Fetcher{cfg: cfg, state: st, log: log.With(logger.KeyAccount, cfg.IMAPUser)}
+}
+
+// email holds the Email properties requested from the server.
+type email struct {
+	ID         string          `json:"id"`
+	MailboxIDs map[string]bool `json:"mailboxIds"`
+	Subject    string          `json:"subject"`
+	From       []struct {
+		Email string `json:"email"`
+	} `json:"from"`
+	Headers []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"headers"`
+	Attachments []struct {
+		BlobID string `json:"blobId"`
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+	} `json:"attachments"`
+}
+
+var emailProperties = []string{"id", "mailboxIds", "subject", "from", "headers", "attachments"}
+
+func (f *Fetcher) configured() bool {
+	return f.cfg.IMAPServer != "" && f.cfg.IMAPPassword != ""
+}
+
+func (f *Fetcher) connect(ctx context.Context) (*Client, error) {
+	if !f.configured() {
+		return nil, fmt.Errorf("JMAP server and password (or API token) must be set")
+	}
+	c := &Client{SessionURL: SessionURL(f.cfg.IMAPServer), User: f.cfg.IMAPUser, Password: f.cfg.IMAPPassword}
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// inboxID returns the ID of the mailbox with the inbox role.
+func inboxID(ctx context.Context, c *Client) (string, error) {
+	var resp struct {
+		IDs []string `json:"ids"`
+	}
+	err := c.call(ctx, "Mailbox/query", map[string]interface{}{"filter": map[string]string{"role": "inbox"}}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.IDs) == 0 {
+		return "", fmt.Errorf("no Inbox mailbox found")
+	}
+	return resp.IDs[0], nil
+}
+
+// currentState returns the server's current Email state string.
+func currentState(ctx context.Context, c *Client) (string, error) {
+	var resp struct {
+		State string `json:"state"`
+	}
+	err := c.call(ctx, "Email/get", map[string]interface{}{"ids": []string{}}, &resp)
+	return resp.State, err
+}
+
+// TestConnection loads the session and returns the number of messages in the Inbox.
+func (f *Fetcher) TestConnection(ctx context.Context) (uint32, error) {
+	c, err := f.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	inbox, err := inboxID(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Total uint32 `json:"total"`
+	}
+	err = c.call(ctx, "Email/query", map[string]interface{}{
+		"filter": map[string]interface{}{"inMailbox": inbox}, "limit": 0, "calculateTotal": true,
+	}, &resp)
+	return resp.Total, err
+}
+
+// ListFolders returns the names of all mailboxes.
+func (f *Fetcher) ListFolders(ctx context.Context) ([]string, error) {
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		List []struct {
+			Name string `json:"name"`
+		} `json:"list"`
+	}
+	if err := c.call(ctx, "Mailbox/get", map[string]interface{}{"ids": nil, "properties": []string{"name"}}, &resp); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range resp.List {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
+
+// FetchNew returns the Inbox messages created since the saved JMAP state (Email/changes) and
+// saves the new state. On the very first run config.BackfillMode decides what is imported.
+// An email whose attachments cannot be downloaded is returned with source.Message.Err set, so
+// it is retried without holding up the others.
+func (f *Fetcher) FetchNew(ctx context.Context) ([]source.Message, error) {
+	if !f.configured() {
+		return nil, nil // no config, skip
+	}
+	started := time.Now() // before the server state is read, so a resync misses nothing
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inbox, err := inboxID(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	var newState string
+	if f.state.JMAPState == "" {
+		ids, newState, err = f.backfill(ctx, c, inbox)
+	} else {
+		ids, newState, err = changes(ctx, c, f.state.JMAPState)
+		var me *MethodError
+		if errors.As(err, &me) && me.Type == "cannotCalculateChanges" {
+			ids, newState, err = f.resync(ctx, c, inbox)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := f.messages(ctx, c, inbox, ids)
+	if err != nil {
+		// State not saved: the same changes are fetched again next poll
+		return nil, err
+	}
+	path, _ := config.StatePath()
+	if err := state.UpdateJMAPState(path, f.state, newState, started); err != nil {
+		f.log.Error("Failed to save state", logger.KeyError, err)
+	}
+	return out, nil
+}
+
+// resync is used when the server can no longer calculate the changes since the saved state: it
+// takes the current state and queries the Inbox for mail received since the last poll, so that
+// mail is not skipped. Mail extracted before is recognised as a duplicate by the engine.
+func (f *Fetcher) resync(ctx context.Context, c *Client, inbox string) ([]string, string, error) {
+	st, err := currentState(ctx, c)
+	if err != nil {
+		return nil, "", err
+	}
+	if f.state.JMAPPolled.IsZero() {
+		// Saved before the poll time was kept: nothing tells which mail was missed
+		f.log.Warning("JMAP state expired, resynchronising; mail received meanwhile is skipped", "state", f.state.JMAPState)
+		return nil, st, nil
+	}
+	since := f.state.JMAPPolled.Add(-resyncMargin)
+	f.log.Warning("JMAP state expired, fetching Inbox mail received since the last poll", "state", f.state.JMAPState, "since", since.Format(time.RFC3339))
+	ids, err := query(ctx, c, inbox, since, time.Time{})
+	return ids, st, err
+}
+
+// backfill returns the Inbox emails to import on the first run and the state to continue from.
+func (f *Fetcher) backfill(ctx context.Context, c *Client, inbox string) ([]string, string, error) {
+	// Take the state first so mail arriving during the query is seen by the next Email/changes
+	st, err := currentState(ctx, c)
+	if err != nil {
+		return nil, "", err
+	}
+	switch f.cfg.BackfillMode {
+	case config.BackfillNow:
+		return nil, st, nil
+	case config.BackfillSince:
+		since, err := f.cfg.BackfillSinceDate()
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid backfill date %q: %w", f.cfg.BackfillSince, err)
+		}
+		ids, err := query(ctx, c, inbox, since, time.Time{})
+		return ids, st, err
+	default: // config.BackfillAll
+		ids, err := query(ctx, c, inbox, time.Time{}, time.Time{})
+		return ids, st, err
+	}
+}
+
+// FetchRange returns the Inbox messages received in [since, before) without changing the state.
+func (f *Fetcher) FetchRange(ctx context.Context, since, before time.Time) ([]source.Message, error) {
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inbox, err := inboxID(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := query(ctx, c, inbox, since, before)
+	if err != nil {
+		return nil, err
+	}
+	return f.messages(ctx, c, inbox, ids)
+}
+
+// FetchIDs returns the emails with the given IDs that still exist, wherever they were moved to,
+// for retrying failed messages. It does not change the state.
+func (f *Fetcher) FetchIDs(ctx context.Context, ids []string) ([]source.Message, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f.messages(ctx, c, "", ids)
+}
+
+// query returns the IDs of Inbox emails received in [since, before) (zero = unbounded), oldest first.
+func query(ctx context.Context, c *Client, inbox string, since, before time.Time) ([]string, error) {
+	filter := map[string]interface{}{"inMailbox": inbox}
+	if !since.IsZero() {
+		filter["after"] = since.UTC().Format(time.RFC3339)
+	}
+	if !before.IsZero() {
+		filter["before"] = before.UTC().Format(time.RFC3339)
+	}
+	var ids []string
+	for {
+		var resp struct {
+			IDs []string `json:"ids"`
+		}
+		err := c.call(ctx, "Email/query", map[string]interface{}{
+			"filter":   filter,
+			"sort":     []map[string]interface{}{{"property": "receivedAt", "isAscending": true}},
+			"position": len(ids),
+			"limit":    getBatch,
+		}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, resp.IDs...)
+		if len(resp.IDs) < getBatch {
+			return ids, nil
+		}
+	}
+}
+
+// changes returns the IDs of emails created since sinceState and the state reached.
+func changes(ctx context.Context, c *Client, sinceState string) ([]string, string, error) {
+	var ids []string
+	for {
+		var resp struct {
+			NewState       string   `json:"newState"`
+			HasMoreChanges bool     `json:"hasMoreChanges"`
+			Created        []string `json:"created"`
+		}
+		err := c.call(ctx, "Email/changes", map[string]interface{}{"sinceState": sinceState, "maxChanges": getBatch}, &resp)
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, resp.Created...)
+		sinceState = resp.NewState
+		if !resp.HasMoreChanges {
+			return ids, sinceState, nil
+		}
+	}
+}
+
+// messages loads the given emails, keeps those in the inbox mailbox (any mailbox if empty) and
+// rebuilds each as a MIME message holding only the accepted attachments, which are the only
+// blobs downloaded.
+func (f *Fetcher) messages(ctx context.Context, c *Client, inbox string, ids []string) ([]source.Message, error) {
+	var out []source.Message
+	for start := 0; start < len(ids); start += getBatch {
+		end := start + getBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var resp struct {
+			List []email `json:"list"`
+		}
+		err := c.call(ctx, "Email/get", map[string]interface{}{"ids": ids[start:end], "properties": emailProperties}, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range resp.List {
+			if inbox != "" && !e.MailboxIDs[inbox] {
+				continue
+			}
+			msg := source.Message{ID: e.ID, Folder: "INBOX", Subject: e.Subject}
+			if len(e.From) > 0 {
+				msg.From = e.From[0].Email
+			}
+			msg.Body, err = f.compose(ctx, c, e)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// Only this email is retried (see source.IDFetcher); the others go ahead
+				f.log.Error("JMAP attachment download failed", logger.KeyID, e.ID, logger.KeyError, err)
+				msg.Err = fmt.Errorf("download attachments: %w", err)
+			}
+			out = append(out, msg)
+		}
+	}
+	return out, nil
+}
+
+// compose writes the email's original header fields (so filter rules see them) and its accepted
+// attachments as a multipart message for extract.ExtractIGCAttachments.
+func (f *Fetcher) compose(ctx context.Context, c *Client, e email) ([]byte, error) {
+	var h mail.Header
+	for _, field := range e.Headers {
+		switch strings.ToLower(field.Name) {
+		case "content-type", "content-transfer-encoding", "content-disposition", "mime-version":
+			continue
+		}
+		// Raw values keep their folding; unfold so the header is rewritten cleanly
+		v := strings.NewReplacer("\r\n", "", "\n", "").Replace(field.Value)
+		h.Add(field.Name, strings.TrimSpace(v))
+	}
+
+	var buf bytes.Buffer
+	w, err := mail.CreateWriter(&buf, h)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range e.Attachments {
+		if a.Name == "" || !extract.AcceptsName(f.cfg.AcceptedFormats, a.Name) {
+			continue
+		}
+		data, err := c.Download(ctx, a.BlobID, a.Name, a.Type)
+		if err != nil {
+			return nil, err
+		}
+		var ah mail.AttachmentHeader
+		ah.Set("Content-Type", "application/octet-stream")
+		ah.Set("Content-Transfer-Encoding", "base64")
+		ah.SetFilename(a.Name)
+		aw, err := w.CreateAttachment(ah)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := aw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := aw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Watch keeps an EventSource connection open and calls changed on every Email state change,
+// reconnecting after errors, until ctx is done.
+func (f *Fetcher) Watch(ctx context.Context, changed func()) {
+	for {
+		c, err := f.connect(ctx)
+		if err == nil {
+			err = c.events(ctx, changed)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		f.log.Warning("JMAP push connection lost, reconnecting", logger.KeyError, err, "delay_seconds", int(reconnectDelay.Seconds()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package jmap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"igcmailimap/config"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// stubServer is a JMAP server with an Inbox and an Archive mailbox. Every added email bumps the
+// Email state; Email/changes fails with cannotCalculateChanges for states older than oldest.
+type stubServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	emails    []stubEmail
+	state     int
+	oldest    int
+	downloads []string // blob IDs downloaded
+	auth      []string // Authorization headers seen
+}
+
+type stubEmail struct {
+	id, mailbox, subject string
+	received             time.Time
+	attachments          []stubBlob
+	created              int // state the email was created in
+}
+
+type stubBlob struct {
+	id, name string
+	broken   bool // download fails
+}
+
+func newStubServer(t *testing.T) *stubServer {
+	t.Helper()
+	s := &stubServer{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/jmap", s.session)
+	mux.HandleFunc("/api", s.api)
+	mux.HandleFunc("/download/", s.download)
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *stubServer) add(e stubEmail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state++
+	e.created = s.state
+	s.emails = append(s.emails, e)
+}
+
+// forget makes the server unable to calculate changes from any state handed out so far.
+func (s *stubServer) forget() {
+	s.mu.Lock()
+	s.oldest = s.state
+	s.mu.Unlock()
+}
+
+func (s *stubServer) downloaded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.downloads...)
+}
+
+func (s *stubServer) session(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	s.mu.Unlock()
+	// Relative URLs, as some servers send them
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiUrl":          "/api",
+		"downloadUrl":     "/download/{accountId}/{blobId}/{name}?type={type}",
+		"eventSourceUrl":  "/events?types={types}",
+		"primaryAccounts": map[string]string{capMail: "acc1"},
+	})
+}
+
+func (s *stubServer) api(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MethodCalls []invocation `json:"methodCalls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.MethodCalls) != 1 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	call := req.MethodCalls[0]
+	var args struct {
+		AccountID  string          `json:"accountId"`
+		IDs        []string        `json:"ids"`
+		Filter     json.RawMessage `json:"filter"`
+		Position   int             `json:"position"`
+		Limit      *int            `json:"limit"`
+		SinceState string          `json:"sinceState"`
+	}
+	if err := json.Unmarshal(call.Args, &args); err != nil || args.AccountID != "acc1" {
+		http.Error(w, "bad arguments", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	name, result := call.Name, s.method(call.Name, args.IDs, args.Filter, args.Position, args.Limit, args.SinceState)
+	s.mu.Unlock()
+	if result == nil {
+		s.t.Errorf("unexpected method %s", call.Name)
+		result = &MethodError{Type: "unknownMethod"}
+	}
+	if _, ok := result.(*MethodError); ok {
+		name = "error"
+	}
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"methodResponses": []invocation{{Name: name, Args: raw, ID: call.ID}},
+	})
+}
+
+// method answers one method call; s.mu is held.
+func (s *stubServer) method(name string, ids []string, filter json.RawMessage, position int, limit *int, sinceState string) interface{} {
+	st := "s" + strconv.Itoa(s.state)
+	switch name {
+	case "Mailbox/query":
+		return map[string]interface{}{"ids": []string{"inbox"}}
+	case "Mailbox/get":
+		return map[string]interface{}{"list": []map[string]string{{"id": "inbox", "name": "Inbox"}, {"id": "archive", "name": "Archive"}}}
+	case "Email/get":
+		list := []map[string]interface{}{}
+		for _, id := range ids {
+			for _, e := range s.emails {
+				if e.id == id {
+					list = append(list, e.json())
+				}
+			}
+		}
+		return map[string]interface{}{"state": st, "list": list}
+	case "Email/query":
+		var f struct {
+			InMailbox     string `json:"inMailbox"`
+			After, Before string
+		}
+		json.Unmarshal(filter, &f)
+		var matched []stubEmail
+		for _, e := range s.emails {
+			if f.InMailbox != "" && e.mailbox != f.InMailbox {
+				continue
+			}
+			if after, err := time.Parse(time.RFC3339, f.After); err == nil && e.received.Before(after) {
+				continue
+			}
+			if before, err := time.Parse(time.RFC3339, f.Before); err == nil && !e.received.Before(before) {
+				continue
+			}
+			matched = append(matched, e)
+		}
+		sort.Slice(matched, func(i, j int) bool { return matched[i].received.Before(matched[j].received) })
+		out := []string{}
+		for i, e := range matched {
+			if i >= position && (limit == nil || len(out) < *limit) {
+				out = append(out, e.id)
+			}
+		}
+		return map[string]interface{}{"ids": out, "total": len(matched)}
+	case "Email/changes":
+		since, err := strconv.Atoi(strings.TrimPrefix(sinceState, "s"))
+		if err != nil || since < s.oldest {
+			return &MethodError{Type: "cannotCalculateChanges"}
+		}
+		created := []string{}
+		for _, e := range s.emails {
+			if e.created > since {
+				created = append(created, e.id)
+			}
+		}
+		return map[string]interface{}{"newState": st, "hasMoreChanges": false, "created": created}
+	}
+	return nil
+}
+
+func (e stubEmail) json() map[string]interface{} {
+	var atts []map[string]string
+	for _, b := range e.attachments {
+		atts = append(atts, map[string]string{"blobId": b.id, "name": b.name, "type": "application/octet-stream"})
+	}
+	return map[string]interface{}{
+		"id":          e.id,
+		"mailboxIds":  map[string]bool{e.mailbox: true},
+		"subject":     e.subject,
+		"from":        []map[string]string{{"email": "pilot@club.org"}},
+		"headers":     []map[string]string{{"name": "Subject", "value": e.subject}, {"name": "Content-Type", "value": "text/plain"}},
+		"attachments": atts,
+	}
+}
+
+func (s *stubServer) download(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/download/"), "/")
+	if len(parts) != 3 || parts[0] != "acc1" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.emails {
+		for _, b := range e.attachments {
+			if b.id == parts[1] {
+				s.downloads = append(s.downloads, b.id)
+				if b.broken {
+					http.Error(w, "blob unavailable", http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, "AXXX %s\r\n", b.name)
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func testFetcher(t *testing.T, s *stubServer, mode string) *Fetcher {
+	t.Helper()
+	config.SetDir(t.TempDir())
+	cfg := &config.Config{
+		Protocol: config.ProtocolJMAP, IMAPServer: s.srv.URL + "/.well-known/jmap", IMAPPassword: "token",
+		BackfillMode: mode, BackfillSince: "2024-06-01", AcceptedFormats: []string{"igc"},
+	}
+	return NewFetcher(cfg, &state.State{}, nil)
+}
+
+func ids(msgs []source.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+var (
+	mayEmail     = stubEmail{id: "m1", mailbox: "inbox", subject: "May flight", received: time.Date(2024, 5, 11, 10, 0, 0, 0, time.UTC)}
+	juneEmail    = stubEmail{id: "m2", mailbox: "inbox", subject: "June flight", received: time.Date(2024, 6, 2, 10, 0, 0, 0, time.UTC)}
+	archiveEmail = stubEmail{id: "a1", mailbox: "archive", subject: "Archived flight", received: time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)}
+)
+
+func TestFetchNewBackfill(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{config.BackfillNow, nil},
+		{config.BackfillSince, []string{"m2"}},
+		{config.BackfillAll, []string{"m1", "m2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			s := newStubServer(t)
+			s.add(mayEmail)
+			s.add(juneEmail)
+			s.add(archiveEmail)
+			f := testFetcher(t, s, tt.mode)
+			msgs, err := f.FetchNew(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("first poll = %q, want %q", got, tt.want)
+			}
+
+			// Later polls only return Inbox mail created since, found with Email/changes
+			s.add(stubEmail{id: "m3", mailbox: "inbox", subject: "New flight", received: time.Now()})
+			s.add(stubEmail{id: "a2", mailbox: "archive", subject: "Filed", received: time.Now()})
+			msgs, err = f.FetchNew(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(msgs); !reflect.DeepEqual(got, []string{"m3"}) {
+				t.Errorf("second poll = %q, want [m3]", got)
+			}
+			if msgs[0].Subject != "New flight" || msgs[0].From != "pilot@club.org" || msgs[0].UID != 0 {
+				t.Errorf("message = %+v", msgs[0])
+			}
+
+			// The state survives a restart
+			path, _ := config.StatePath()
+			st, err := state.Load(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.JMAPState != "s5" || st.JMAPPolled.IsZero() {
+				t.Errorf("saved state %q polled at %v, want s5 and a time", st.JMAPState, st.JMAPPolled)
+			}
+			if got := s.auth[0]; got != "Bearer token" {
+				t.Errorf("Authorization = %q, want the API token", got)
+			}
+		})
+	}
+}
+
+func TestAcceptedAttachmentsOnly(t *testing.T) {
+	s := newStubServer(t)
+	f := testFetcher(t, s, config.BackfillAll)
+	s.add(stubEmail{id: "m1", mailbox: "inbox", received: mayEmail.received, attachments: []stubBlob{
+		{id: "b1", name: "flight.igc"},
+		{id: "b2", name: "photo.jpg"},
+		{id: "b3", name: "TRACK.IGC"},
+	}})
+	msgs, err := f.FetchNew(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.downloaded(); !reflect.DeepEqual(got, []string{"b1", "b3"}) {
+		t.Errorf("downloaded %q, want [b1 b3]", got)
+	}
+	body := string(msgs[0].Body)
+	if !strings.Contains(body, "flight.igc") || !strings.Contains(body, "TRACK.IGC") || strings.Contains(body, "photo.jpg") {
+		t.Errorf("composed message:\n%s", body)
+	}
+	if strings.Contains(body, "text/plain") {
+		t.Error("original Content-Type kept in the composed message")
+	}
+}
+
+func TestDownloadFailure(t *testing.T) {
+	s := newStubServer(t)
+	f := testFetcher(t, s, config.BackfillAll)
+	s.add(stubEmail{id: "m1", mailbox: "inbox", received: mayEmail.received, attachments: []stubBlob{{id: "b1", name: "a.igc", broken: true}}})
+	s.add(stubEmail{id: "m2", mailbox: "inbox", received: juneEmail.received, attachments: []stubBlob{{id: "b2", name: "b.igc"}}})
+	msgs, err := f.FetchNew(context.Background())
+	if err != nil {
+		t.Fatalf("one failed download failed the poll: %v", err)
+	}
+	if got := ids(msgs); !reflect.DeepEqual(got, []string{"m1", "m2"}) {
+		t.Fatalf("fetched %q, want [m1 m2]", got)
+	}
+	if msgs[0].Err == nil || msgs[1].Err != nil {
+		t.Errorf("errors = %v, %v; want only the first message failed", msgs[0].Err, msgs[1].Err)
+	}
+	if f.state.JMAPState != "s2" {
+		t.Errorf("state = %q, want s2 (the poll went ahead)", f.state.JMAPState)
+	}
+}
+
+func TestCannotCalculateChanges(t *testing.T) {
+	s := newStubServer(t)
+	f := testFetcher(t, s, config.BackfillNow)
+	s.add(mayEmail)
+	if _, err := f.FetchNew(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mail arrives, then the server expires our state before the next poll
+	s.add(stubEmail{id: "m3", mailbox: "inbox", subject: "Missed", received: time.Now()})
+	s.add(stubEmail{id: "a2", mailbox: "archive", subject: "Filed", received: time.Now()})
+	s.forget()
+	msgs, err := f.FetchNew(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(msgs); !reflect.DeepEqual(got, []string{"m3"}) {
+		t.Errorf("after resync = %q, want [m3]", got)
+	}
+	if f.state.JMAPState != "s3" {
+		t.Errorf("state = %q, want s3", f.state.JMAPState)
+	}
+
+	// Without a saved poll time the gap cannot be recovered: continue from the current state
+	f.state.JMAPPolled = time.Time{}
+	s.add(stubEmail{id: "m4", mailbox: "inbox", subject: "Lost", received: time.Now()})
+	s.forget()
+	msgs, err = f.FetchNew(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 || f.state.JMAPState != "s4" {
+		t.Errorf("fetched %q with state %q, want nothing and s4", ids(msgs), f.state.JMAPState)
+	}
+}
+
+func TestFetchIDs(t *testing.T) {
+	s := newStubServer(t)
+	s.add(mayEmail)
+	s.add(archiveEmail)
+	f := testFetcher(t, s, config.BackfillAll)
+	msgs, err := f.FetchIDs(context.Background(), []string{"a1", "gone", "m1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Moved out of the Inbox since failing still counts
+	if got := ids(msgs); !reflect.DeepEqual(got, []string{"a1", "m1"}) {
+		t.Errorf("FetchIDs = %q, want [a1 m1]", got)
+	}
+	if f.state.JMAPState != "" {
+		t.Error("FetchIDs changed the state")
+	}
+}
+
+func TestListFolders(t *testing.T) {
+	s := newStubServer(t)
+	s.add(mayEmail)
+	s.add(juneEmail)
+	s.add(archiveEmail)
+	f := testFetcher(t, s, config.BackfillAll)
+	names, err := f.ListFolders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"Inbox", "Archive"}) {
+		t.Errorf("ListFolders = %q", names)
+	}
+	n, err := f.TestConnection(context.Background())
+	if err != nil || n != 2 {
+		t.Errorf("TestConnection = %d, %v; want 2 Inbox messages", n, err)
+	}
+}
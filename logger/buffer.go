@@ -0,0 +1,184 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecentSize is the number of records kept in memory for the log viewer.
+const RecentSize = 2000
+
+// recent collects the records of every logger created with New, including loggers whose file
+// output is disabled, and survives loggers being recreated when settings change.
+var recent = NewBuffer(RecentSize)
+
+// Recent returns the in-memory buffer of recent log records.
+func Recent() *Buffer {
+	return recent
+}
+
+// Entry is a log record kept in a Buffer.
+type Entry struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   string // attributes formatted as key=value pairs
+}
+
+// String formats the entry as a single line, e.g. "14:02:11 INFO Fetch completed messages=2".
+func (e Entry) String() string {
+	s := fmt.Sprintf("%s %-5s %s", e.Time.Format("15:04:05"), e.Level, e.Message)
+	if e.Attrs != "" {
+		s += " " + e.Attrs
+	}
+	return s
+}
+
+// Buffer is a fixed-size ring of the most recent log entries, safe for concurrent use.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int  // index the next entry is written to
+	full    bool // entries has wrapped around
+	seq     uint64
+}
+
+// NewBuffer returns a buffer that keeps the last size entries.
+func NewBuffer(size int) *Buffer {
+	return &Buffer{entries: make([]Entry, size)}
+}
+
+func (b *Buffer) add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	b.seq++
+}
+
+// Entries returns a copy of the buffered entries, oldest first.
+func (b *Buffer) Entries() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.full {
+		return append([]Entry(nil), b.entries[:b.next]...)
+	}
+	return append(append([]Entry(nil), b.entries[b.next:]...), b.entries[:b.next]...)
+}
+
+// Seq returns a counter that increases with every added entry, so viewers can poll cheaply
+// for changes.
+func (b *Buffer) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// bufferHandler is a slog.Handler that records every level into a Buffer.
+type bufferHandler struct {
+	buf    *Buffer
+	attrs  string // preformatted attributes added with WithAttrs
+	prefix string // group prefix for attribute keys, e.g. "req."
+}
+
+func (h *bufferHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *bufferHandler) Handle(_ context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&sb, h.prefix, a)
+		return true
+	})
+	h.buf.add(Entry{Time: r.Time, Level: r.Level, Message: r.Message, Attrs: sb.String()})
+	return nil
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var sb strings.Builder
+	sb.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&sb, h.prefix, a)
+	}
+	return &bufferHandler{buf: h.buf, attrs: sb.String(), prefix: h.prefix}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{buf: h.buf, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// appendAttr writes a as " key=value" (without the leading space for the first attribute),
+// quoting values that contain spaces like slog's text handler does.
+func appendAttr(sb *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(sb, prefix, ga)
+		}
+		return
+	}
+	if sb.Len() > 0 {
+		sb.WriteByte(' ')
+	}
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		v = strconv.Quote(v)
+	}
+	sb.WriteString(prefix + a.Key + "=" + v)
+}
+
+// teeHandler sends each record to all handlers that accept its level.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if hErr := h.Handle(ctx, r.Clone()); err == nil {
+				err = hErr
+			}
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
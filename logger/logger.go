@@ -1,26 +1,73 @@
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"time"
+	"strings"
 )
 
 const logFileName = "igcmailimap.log"
 
-// Logger handles logging to a file in the output directory
+// Output formats (Options.Format).
+const (
+	FormatText = "text" // key=value lines (default)
+	FormatJSON = "json" // one JSON object per line, for log ingestion
+)
+
+// Attribute keys shared by all log records, so logs can be grepped and queried by field.
+const (
+	KeyUID     = "uid"
+	KeyID      = "id" // POP3 UIDL or JMAP Email id of a message without UID
+	KeySubject = "subject"
+	KeyFrom    = "from"
+	KeyFiles   = "files"
+	KeyAccount = "account"
+	KeyError   = "error"
+)
+
+// Levels lists the accepted Options.Level values, most verbose first.
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// Options configures the log output and the rotation of log files.
+type Options struct {
+	Level  string // minimum level: "debug", "info" (default), "warn" or "error"
+	Format string // FormatText (default) or FormatJSON
+
+	MaxSizeMB  int // rotate the file once it exceeds this size (0 = DefaultMaxSizeMB)
+	MaxAgeDays int // also rotate once the file's first record is this old (0 = no age limit)
+	Keep       int // number of compressed archives kept (0 = DefaultKeep)
+}
+
+// ParseLevel returns the slog level for a name in Levels; unknown names mean info.
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Logger handles logging to a file in the output directory. A nil *Logger discards records.
 type Logger struct {
-	enabled bool
-	logFile *os.File
-	logger  *log.Logger
+	logFile io.Closer
+	logger  *slog.Logger
 }
 
-// New creates a new logger that writes to the specified output directory
-func New(outputDir string, enabled bool) (*Logger, error) {
+// New creates a new logger that writes to igcmailimap.log in the specified directory
+// (the output folder, or the config folder), rotating it according to opts. Records are also
+// kept in the Recent buffer, even when file logging is disabled.
+func New(outputDir string, enabled bool, opts Options) (*Logger, error) {
+	memory := &bufferHandler{buf: recent}
 	l := &Logger{
-		enabled: enabled,
+		logger: slog.New(memory),
 	}
 
 	if enabled && outputDir != "" {
@@ -32,72 +79,84 @@ func New(outputDir string, enabled bool) (*Logger, error) {
 		}
 
 		// Open log file in append mode, create if it doesn't exist
-		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		file, err := OpenFile(logPath, opts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open log file: %w", err)
 		}
 
 		l.logFile = file
-		l.logger = log.New(file, "", log.LstdFlags)
+		l.logger = slog.New(teeHandler{newHandler(file, opts), memory})
 	}
 
 	return l, nil
 }
 
+// NewWriter creates an enabled logger that writes to w (e.g. stdout for the headless daemon).
+func NewWriter(w io.Writer, opts Options) *Logger {
+	return &Logger{
+		logger: slog.New(newHandler(w, opts)),
+	}
+}
+
+func newHandler(w io.Writer, opts Options) slog.Handler {
+	ho := &slog.HandlerOptions{Level: ParseLevel(opts.Level)}
+	if strings.ToLower(opts.Format) == FormatJSON {
+		return slog.NewJSONHandler(w, ho)
+	}
+	return slog.NewTextHandler(w, ho)
+}
+
+// With returns a logger that adds the given attributes (key-value pairs) to every record,
+// e.g. With(logger.KeyAccount, user). It shares the log file of l.
+func (l *Logger) With(args ...any) *Logger {
+	if l == nil || l.logger == nil {
+		return l
+	}
+	return &Logger{logFile: l.logFile, logger: l.logger.With(args...)}
+}
+
 // Close closes the log file if it's open
 func (l *Logger) Close() error {
-	if l.logFile != nil {
+	if l != nil && l.logFile != nil {
 		return l.logFile.Close()
 	}
 	return nil
 }
 
-// Info logs an info message
-func (l *Logger) Info(message string) {
-	if l.enabled && l.logger != nil {
-		l.logger.Println("[INFO] " + message)
+func (l *Logger) log(level slog.Level, message string, args ...any) {
+	if l != nil && l.logger != nil {
+		l.logger.Log(context.Background(), level, message, args...)
 	}
 }
 
-// Error logs an error message
-func (l *Logger) Error(message string) {
-	if l.enabled && l.logger != nil {
-		l.logger.Println("[ERROR] " + message)
-	}
+// Info logs an info message with optional attributes (key-value pairs)
+func (l *Logger) Info(message string, args ...any) {
+	l.log(slog.LevelInfo, message, args...)
 }
 
-// Warning logs a warning message
-func (l *Logger) Warning(message string) {
-	if l.enabled && l.logger != nil {
-		l.logger.Println("[WARNING] " + message)
-	}
+// Error logs an error message with optional attributes (key-value pairs)
+func (l *Logger) Error(message string, args ...any) {
+	l.log(slog.LevelError, message, args...)
 }
 
-// Debug logs a debug message
-func (l *Logger) Debug(message string) {
-	if l.enabled && l.logger != nil {
-		l.logger.Println("[DEBUG] " + message)
-	}
+// Warning logs a warning message with optional attributes (key-value pairs)
+func (l *Logger) Warning(message string, args ...any) {
+	l.log(slog.LevelWarn, message, args...)
+}
+
+// Debug logs a debug message with optional attributes (key-value pairs)
+func (l *Logger) Debug(message string, args ...any) {
+	l.log(slog.LevelDebug, message, args...)
 }
 
 // LogFetch logs information about a fetch operation
 func (l *Logger) LogFetch(messagesFound int, outputDir string, uids []uint32) {
-	if l.enabled && l.logger != nil {
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
-		message := fmt.Sprintf("[%s] IMAP fetch completed: %d new messages found (UIDs: %v), output directory: %s",
-			timestamp, messagesFound, uids, outputDir)
-		l.logger.Println("[FETCH] " + message)
-	}
+	l.Info("Fetch completed", "messages", messagesFound, "uids", uids, "output", outputDir)
 }
 
-// LogMessageDetails logs details about fetched messages
-func (l *Logger) LogMessageDetails(uid uint32, subject, from string) {
-	if l.enabled && l.logger != nil {
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
-		message := fmt.Sprintf("[%s] Fetched message UID %d - Subject: '%s', From: '%s'",
-			timestamp, uid, subject, from)
-		l.logger.Println("[MESSAGE] " + message)
-	}
+// LogMessageDetails logs details about fetched messages; l carries the UID or ID (see With)
+func (l *Logger) LogMessageDetails(subject, from string) {
+	l.Debug("Fetched message", KeySubject, subject, KeyFrom, from)
 }
 
 // ExtractResult holds information about a single extracted file
@@ -106,26 +165,16 @@ type ExtractResult struct {
 	Path     string // Full path to the saved file
 }
 
-// LogMessageExtract logs details about files extracted from a message
-func (l *Logger) LogMessageExtract(uid uint32, subject, from string, results []ExtractResult, outputDir string) {
-	if l.enabled && l.logger != nil {
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
-		filenames := make([]string, len(results))
-		for i, result := range results {
-			filenames[i] = result.Filename
-		}
-		message := fmt.Sprintf("[%s] Extracted %d files from UID %d (Subject: '%s', From: '%s') - Files: %v",
-			timestamp, len(results), uid, subject, from, filenames)
-		l.logger.Println("[EXTRACT] " + message)
+// LogMessageExtract logs details about files extracted from a message; l carries the UID or ID
+func (l *Logger) LogMessageExtract(subject, from string, results []ExtractResult, outputDir string) {
+	filenames := make([]string, len(results))
+	for i, result := range results {
+		filenames[i] = result.Filename
 	}
+	l.Info("Extracted files", KeySubject, subject, KeyFrom, from, KeyFiles, filenames, "output", outputDir)
 }
 
 // LogExtract logs information about file extraction
 func (l *Logger) LogExtract(filesSaved int, outputDir string) {
-	if l.enabled && l.logger != nil {
-		timestamp := time.Now().Format("2006-01-02 15:04:05")
-		message := fmt.Sprintf("[%s] IGC extraction completed: %d files saved to %s",
-			timestamp, filesSaved, outputDir)
-		l.logger.Println("[EXTRACT] " + message)
-	}
+	l.Info("Extraction completed", "saved", filesSaved, "output", outputDir)
 }
@@ -0,0 +1,219 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rotation defaults, used when the corresponding Options field is zero.
+const (
+	DefaultMaxSizeMB = 10
+	DefaultKeep      = 5
+)
+
+// rotatingFile is an append-only log file that is renamed and gzipped once it exceeds a size
+// or age; only the newest archives are kept.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration // 0 = no age limit
+	keep    int
+
+	f       *os.File // nil after Close, or if reopening after a rotation failed
+	closed  bool
+	size    int64
+	started time.Time // time of the first record in the current file
+}
+
+// OpenFile opens (or creates) a log file at path that rotates according to opts.
+func OpenFile(path string, opts Options) (io.WriteCloser, error) {
+	r := &rotatingFile{
+		path:    path,
+		maxSize: int64(opts.MaxSizeMB) * 1024 * 1024,
+		maxAge:  time.Duration(opts.MaxAgeDays) * 24 * time.Hour,
+		keep:    opts.Keep,
+	}
+	if r.maxSize <= 0 {
+		r.maxSize = DefaultMaxSizeMB * 1024 * 1024
+	}
+	if r.keep <= 0 {
+		r.keep = DefaultKeep
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	r.started = time.Now()
+	if r.size > 0 {
+		if t, ok := firstRecordTime(r.path); ok {
+			r.started = t
+		}
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	tooBig := r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	tooOld := r.maxAge > 0 && r.size > 0 && time.Since(r.started) > r.maxAge
+	if r.f != nil && (tooBig || tooOld) {
+		if err := r.rotate(); err != nil {
+			// Keep logging into the current file rather than losing records
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	if r.f == nil {
+		// The file could not be reopened after a rotation: try again with every record
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// rotate compresses the current file to base-YYYYMMDD-HHMMSS.mmm.log.gz, starts a new one and
+// removes archives beyond the retention count.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	archive := r.archivePath(time.Now())
+	compressErr := compressFile(r.path, archive)
+	if compressErr == nil {
+		compressErr = os.Remove(r.path)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	if compressErr != nil {
+		return compressErr
+	}
+	return r.prune()
+}
+
+func (r *rotatingFile) archivePath(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	// Milliseconds keep names unique and in order even for rotations within one second
+	return fmt.Sprintf("%s-%s%s.gz", base, t.Format("20060102-150405.000"), ext)
+}
+
+// prune deletes the oldest archives so that at most keep remain.
+func (r *rotatingFile) prune() error {
+	archives, err := Archives(r.path)
+	if err != nil {
+		return err
+	}
+	for len(archives) > r.keep {
+		if err := os.Remove(archives[0]); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+	return nil
+}
+
+// Archives returns the rotated, gzipped archives of the log file at path, oldest first.
+func Archives(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	matches, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext + ".gz")
+	if err != nil {
+		return nil, err
+	}
+	// The timestamp in the name makes lexical order chronological
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(src)
+	_, err = io.Copy(gz, in)
+	if cErr := gz.Close(); err == nil {
+		err = cErr
+	}
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// recordTime matches the timestamp of a text ("time=...") or JSON ("time":"...") record,
+// and the "2006/01/02 15:04:05" prefix of logs written before the switch to slog.
+var recordTime = regexp.MustCompile(`^(?:time=|\{"time":")([0-9T:.+\-Z]+)|^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
+
+// firstRecordTime returns the time of the first record in the log file, so the age limit
+// survives restarts.
+func firstRecordTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	m := recordTime.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return time.Time{}, false
+	case m[1] != "":
+		t, err := time.Parse(time.RFC3339Nano, m[1])
+		return t, err == nil
+	default:
+		t, err := time.ParseInLocation("2006/01/02 15:04:05", m[2], time.Local)
+		return t, err == nil
+	}
+}
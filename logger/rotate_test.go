@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T, r *rotatingFile) *rotatingFile {
+	t.Helper()
+	r.path = filepath.Join(t.TempDir(), "test.log")
+	if r.keep == 0 {
+		r.keep = DefaultKeep
+	}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func write(t *testing.T, r *rotatingFile, record string) {
+	t.Helper()
+	if _, err := r.Write([]byte(record)); err != nil {
+		t.Fatalf("Write(%q): %v", record, err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotateSize(t *testing.T) {
+	r := openTest(t, &rotatingFile{maxSize: 30})
+	write(t, r, "first record\n")
+	write(t, r, "second record\n") // 27 bytes: still fits
+	write(t, r, "third record\n")
+
+	archives, err := Archives(r.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want 1", archives)
+	}
+	if got := readGzip(t, archives[0]); got != "first record\nsecond record\n" {
+		t.Errorf("archive = %q", got)
+	}
+	if got := readFile(t, r.path); got != "third record\n" {
+		t.Errorf("log file = %q", got)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	r := openTest(t, &rotatingFile{maxSize: 1 << 20, maxAge: time.Hour})
+	write(t, r, "old record\n")
+	write(t, r, "recent record\n")
+	if archives, _ := Archives(r.path); len(archives) != 0 {
+		t.Fatalf("rotated a fresh file: %v", archives)
+	}
+
+	r.started = time.Now().Add(-2 * time.Hour)
+	write(t, r, "new record\n")
+	archives, _ := Archives(r.path)
+	if len(archives) != 1 || readGzip(t, archives[0]) != "old record\nrecent record\n" {
+		t.Errorf("archives = %v", archives)
+	}
+	if got := readFile(t, r.path); got != "new record\n" {
+		t.Errorf("log file = %q", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	r := openTest(t, &rotatingFile{maxSize: 1 << 20, keep: 2})
+	var names []string
+	for i, ts := range []string{"20240601-120000.000", "20240601-120000.001", "20240602-080000.000", "20240603-000000.000"} {
+		name := filepath.Join(filepath.Dir(r.path), "test-"+ts+".log.gz")
+		if err := os.WriteFile(name, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := r.prune(); err != nil {
+		t.Fatal(err)
+	}
+	archives, _ := Archives(r.path)
+	if !reflect.DeepEqual(archives, names[2:]) {
+		t.Errorf("kept %v, want the newest two %v", archives, names[2:])
+	}
+}
+
+func TestWriteReopens(t *testing.T) {
+	r := openTest(t, &rotatingFile{maxSize: 1 << 20})
+	write(t, r, "before\n")
+
+	// As left by a rotation that could not reopen the file
+	r.f.Close()
+	r.f = nil
+	write(t, r, "after\n")
+	if got := readFile(t, r.path); got != "before\nafter\n" {
+		t.Errorf("log file = %q", got)
+	}
+
+	r.Close()
+	if _, err := r.Write([]byte("closed\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close: %v, want os.ErrClosed", err)
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"log"
+	"os"
 
+	"igcmailimap/cli"
 	"igcmailimap/ui"
 )
 
 func main() {
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	app, err := ui.New()
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,201 @@
+package pilots
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UnknownID is the pilot ID (and folder) used for flights that match no directory entry.
+const UnknownID = "unknown"
+
+// Pilot maps the identifiers found in mails and IGC files to one canonical pilot ID.
+type Pilot struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name,omitempty"`
+	Emails        []string `json:"emails,omitempty"`
+	IGCNames      []string `json:"igc_names,omitempty"`      // HFPLTPILOT values as written by the logger
+	LoggerSerials []string `json:"logger_serials,omitempty"` // "ABC" or manufacturer+serial "XCSABC"
+}
+
+// Directory is the list of known pilots, stored as JSON or CSV.
+type Directory struct {
+	Pilots []Pilot `json:"pilots"`
+}
+
+// csvHeader is the column layout of CSV directories; list columns are separated by ";".
+var csvHeader = []string{"id", "name", "emails", "igc_names", "logger_serials"}
+
+// Load reads a directory from a .json or .csv file. A missing file returns an empty directory.
+func Load(path string) (*Directory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Directory{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if isCSV(path) {
+		return readCSV(f)
+	}
+	var d Directory
+	if err := json.NewDecoder(f).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Save writes the directory as CSV or JSON depending on the file extension.
+func Save(path string, d *Directory) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if isCSV(path) {
+		err = writeCSV(f, d)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+func isCSV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+func readCSV(r io.Reader) (*Directory, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	d := &Directory{}
+	for i, rec := range records {
+		if i == 0 && len(rec) > 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "id") {
+			continue // header row
+		}
+		col := func(n int) string {
+			if n < len(rec) {
+				return strings.TrimSpace(rec[n])
+			}
+			return ""
+		}
+		if col(0) == "" {
+			continue
+		}
+		d.Pilots = append(d.Pilots, Pilot{
+			ID:            col(0),
+			Name:          col(1),
+			Emails:        splitList(col(2)),
+			IGCNames:      splitList(col(3)),
+			LoggerSerials: splitList(col(4)),
+		})
+	}
+	return d, nil
+}
+
+func writeCSV(w io.Writer, d *Directory) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, p := range d.Pilots {
+		rec := []string{p.ID, p.Name, strings.Join(p.Emails, ";"), strings.Join(p.IGCNames, ";"), strings.Join(p.LoggerSerials, ";")}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// splitList splits a ";"-separated cell, dropping blanks.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Validate checks that every pilot has a unique, path-safe ID.
+func (d *Directory) Validate() error {
+	seen := make(map[string]bool)
+	for i, p := range d.Pilots {
+		if p.ID == "" {
+			return fmt.Errorf("pilot %d: missing ID", i+1)
+		}
+		if sanitize(p.ID) != p.ID {
+			return fmt.Errorf("pilot %q: ID must not contain path separators or special characters", p.ID)
+		}
+		if strings.EqualFold(p.ID, UnknownID) {
+			return fmt.Errorf("pilot ID %q is reserved for unmatched flights", UnknownID)
+		}
+		key := strings.ToLower(p.ID)
+		if seen[key] {
+			return fmt.Errorf("duplicate pilot ID %q", p.ID)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Match finds the pilot for a flight. The IGC pilot name is tried first, then the logger
+// (manufacturer+serial or serial alone), then the sender address. Empty arguments are ignored.
+func (d *Directory) Match(igcName, manufacturer, serial, email string) (Pilot, bool) {
+	if d == nil {
+		return Pilot{}, false
+	}
+	if name := normaliseName(igcName); name != "" {
+		for _, p := range d.Pilots {
+			for _, n := range p.IGCNames {
+				if normaliseName(n) == name {
+					return p, true
+				}
+			}
+		}
+	}
+	if serial != "" {
+		full := strings.ToUpper(manufacturer + serial)
+		for _, p := range d.Pilots {
+			for _, s := range p.LoggerSerials {
+				s = strings.ToUpper(strings.TrimSpace(s))
+				if s == full || s == strings.ToUpper(serial) {
+					return p, true
+				}
+			}
+		}
+	}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		for _, p := range d.Pilots {
+			for _, e := range p.Emails {
+				if strings.ToLower(strings.TrimSpace(e)) == email {
+					return p, true
+				}
+			}
+		}
+	}
+	return Pilot{}, false
+}
+
+// normaliseName lower-cases and collapses whitespace so "DOE  John" matches "Doe John".
+func normaliseName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
@@ -0,0 +1,107 @@
+package pilots
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	d := &Directory{Pilots: []Pilot{
+		{ID: "jdoe", IGCNames: []string{"Doe Jane"}, LoggerSerials: []string{"XCSABC"}, Emails: []string{"jane@club.org"}},
+		{ID: "bob", IGCNames: []string{"Bob"}, LoggerSerials: []string{"K12"}, Emails: []string{" Bob@Club.org "}},
+	}}
+	tests := []struct {
+		name                                string
+		igcName, manufacturer, serial, mail string
+		want                                string // "" for no match
+	}{
+		{name: "IGC name ignores case and spacing", igcName: "DOE   jane", want: "jdoe"},
+		{name: "IGC name wins over sender", igcName: "Doe Jane", mail: "bob@club.org", want: "jdoe"},
+		{name: "manufacturer and serial", manufacturer: "XCS", serial: "abc", want: "jdoe"},
+		{name: "serial alone", manufacturer: "LXN", serial: "K12", want: "bob"},
+		{name: "serial of another manufacturer", manufacturer: "LXN", serial: "ABC"},
+		{name: "sender", mail: "BOB@club.org", want: "bob"},
+		{name: "unknown", igcName: "Someone", mail: "x@y.z"},
+		{name: "nothing given"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := d.Match(tt.igcName, tt.manufacturer, tt.serial, tt.mail)
+			if p.ID != tt.want || ok != (tt.want != "") {
+				t.Errorf("Match = %q, %v; want %q", p.ID, ok, tt.want)
+			}
+		})
+	}
+	var nilDir *Directory
+	if _, ok := nilDir.Match("Doe Jane", "", "", ""); ok {
+		t.Error("nil directory matched")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		pilots []Pilot
+		ok     bool
+	}{
+		{"valid", []Pilot{{ID: "jdoe"}, {ID: "bob"}}, true},
+		{"empty", nil, true},
+		{"missing ID", []Pilot{{Name: "Jane"}}, false},
+		{"path separator", []Pilot{{ID: "a/b"}}, false},
+		{"reserved", []Pilot{{ID: "Unknown"}}, false},
+		{"duplicate ignoring case", []Pilot{{ID: "bob"}, {ID: "BOB"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&Directory{Pilots: tt.pilots}).Validate()
+			if (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	d := &Directory{Pilots: []Pilot{
+		{ID: "jdoe", Name: "Jane Doe", Emails: []string{"jane@club.org", "jd@home.example"}, IGCNames: []string{"Doe Jane"}, LoggerSerials: []string{"XCSABC"}},
+		{ID: "bob"},
+	}}
+	for _, name := range []string{"pilots.json", "pilots.csv", "PILOTS.CSV"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub", name)
+			if err := Save(path, d); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, d) {
+				t.Errorf("loaded %+v, want %+v", got, d)
+			}
+		})
+	}
+}
+
+func TestLoadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pilots.csv")
+	// Hand-written: no header, short rows, blank lines and spaces
+	data := "jdoe, Jane Doe, jane@club.org ; ;jd@home.example\n\n,ignored\nbob\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pilot{{ID: "jdoe", Name: "Jane Doe", Emails: []string{"jane@club.org", "jd@home.example"}}, {ID: "bob"}}
+	if !reflect.DeepEqual(d.Pilots, want) {
+		t.Errorf("pilots = %+v, want %+v", d.Pilots, want)
+	}
+
+	if d, err := Load(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(d.Pilots) != 0 {
+		t.Errorf("missing file: %+v, %v; want an empty directory", d, err)
+	}
+}
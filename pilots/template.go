@@ -0,0 +1,107 @@
+package pilots
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+// DefaultTemplate routes each file into its pilot's folder, keeping the original name.
+const DefaultTemplate = "{pilot}/{filename}"
+
+// TemplateHelp describes the placeholders accepted in filename templates.
+const TemplateHelp = "{pilot} pilot ID (or \"unknown\"), {pilotname}, {filename} original name, {name} name without extension, " +
+	"{ext}, {date} flight date YYYY-MM-DD, {year}, {serial} logger serial, {sender} sender address. Use / for folders."
+
+// Route returns the save path, relative to the output folder, for an attachment. The pilot is
+// looked up from the IGC header (when the data is an IGC file) and the sender address.
+// The original extension is appended if the expanded template does not end with it.
+func Route(d *Directory, tmpl, filename, sender string, data []byte) string {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	ext := fileExt(filename)
+	var fl *igc.Flight
+	if strings.EqualFold(ext, ".igc") {
+		fl, _ = igc.Parse(bytes.NewReader(data))
+	}
+
+	var igcName, manufacturer, serial string
+	date := time.Now()
+	if fl != nil {
+		igcName, manufacturer, serial = fl.Pilot, fl.Manufacturer, fl.LoggerSerial
+		if !fl.Date.IsZero() {
+			date = fl.Date
+		}
+	}
+	pilotID, pilotName := UnknownID, igcName
+	if p, ok := d.Match(igcName, manufacturer, serial, sender); ok {
+		pilotID = p.ID
+		if p.Name != "" {
+			pilotName = p.Name
+		}
+	}
+	if pilotName == "" {
+		pilotName = pilotID
+	}
+	if serial == "" {
+		serial = UnknownID
+	}
+
+	r := strings.NewReplacer(
+		"{pilot}", sanitize(pilotID),
+		"{pilotname}", sanitize(pilotName),
+		"{filename}", sanitize(filename),
+		"{name}", sanitize(strings.TrimSuffix(filename, filename[len(filename)-len(ext):])),
+		"{ext}", ext,
+		"{date}", date.Format("2006-01-02"),
+		"{year}", date.Format("2006"),
+		"{serial}", sanitize(manufacturer+serial),
+		"{sender}", sanitize(sender),
+	)
+	out := cleanRelative(r.Replace(tmpl))
+	if out == "" {
+		out = sanitize(filename)
+	}
+	if ext != "" && !strings.HasSuffix(strings.ToLower(out), strings.ToLower(ext)) {
+		out += ext
+	}
+	return filepath.FromSlash(out)
+}
+
+// fileExt is filepath.Ext, except that ".igc.gz" is kept as one extension.
+func fileExt(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".igc.gz") {
+		return name[len(name)-len(".igc.gz"):]
+	}
+	return filepath.Ext(name)
+}
+
+// cleanRelative turns a template result into a clean forward-slash path that cannot leave
+// the output folder (no leading slash, no "..", no empty segments).
+func cleanRelative(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	var parts []string
+	for _, seg := range strings.Split(path.Clean("/"+p), "/") {
+		if seg = strings.TrimSpace(seg); seg != "" && seg != "." && seg != ".." {
+			parts = append(parts, seg)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// sanitize makes a value safe to use as (part of) a single path segment.
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.Trim(s, " .")
+}
@@ -0,0 +1,63 @@
+package pilots
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const flight = "AXCSABC\r\n" +
+	"HFDTE150723\r\n" +
+	"HFPLTPILOTINCHARGE:DOE  Jane\r\n" +
+	"B1100004730000N00830000EA0100001050\r\n"
+
+func TestRoute(t *testing.T) {
+	d := &Directory{Pilots: []Pilot{
+		{ID: "jdoe", Name: "Jane Doe", IGCNames: []string{"Doe Jane"}},
+		{ID: "bob", Emails: []string{"Bob@Club.org"}},
+	}}
+	today := time.Now().Format("2006-01-02")
+	tests := []struct {
+		name     string
+		tmpl     string
+		filename string
+		sender   string
+		data     string
+		want     string
+	}{
+		{"default template", "", "flight.igc", "", flight, "jdoe/flight.igc"},
+		{"matched by sender", "", "track.gpx", "bob@club.org", "", "bob/track.gpx"},
+		{"unknown pilot", "", "flight.igc", "x@y.z", "no track", "unknown/flight.igc"},
+		{"all placeholders", "{year}/{date}_{pilotname}_{serial}_{name}{ext}", "flight.IGC", "", flight, "2023/2023-07-15_Jane Doe_XCSABC_flight.IGC"},
+		{"extension added", "{pilot}/{date}", "flight.igc", "", flight, "jdoe/2023-07-15.igc"},
+		{"igc.gz is one extension", "{name}-{pilot}", "flight.igc.gz", "bob@club.org", "", "flight-bob.igc.gz"},
+		{"undated files use today", "{date}/{filename}", "track.kml", "", "", today + "/track.kml"},
+		{"sender sanitised", "{sender}/{filename}", "a.igc", "a/b:c@d.e", "", "a_b_c@d.e/a.igc"},
+		{"cannot leave the folder", "../../{pilot}//./{filename}", "flight.igc", "", flight, "jdoe/flight.igc"},
+		{"absolute template", "/etc/{filename}", "flight.igc", "", flight, "etc/flight.igc"},
+		{"empty result", "{nothing}/..", "flight.igc", "", flight, "flight.igc"},
+		{"unknown serial", "{serial}/{filename}", "t.gpx", "", "", "unknown/t.gpx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Route(d, tt.tmpl, tt.filename, tt.sender, []byte(tt.data))
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("Route(%q, %q) = %q, want %q", tt.tmpl, tt.filename, got, want)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Jane Doe", "Jane Doe"},
+		{`a/b\c:d*e?f"g<h>i|j`, "a_b_c_d_e_f_g_h_i_j"},
+		{" .hidden. ", "hidden"},
+		{"tab\there", "tab_here"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.in); got != tt.want {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package pop3 fetches mail over POP3 (RFC 1939) with TLS or STLS (RFC 2595), for providers
+// that do not offer IMAP. Messages are tracked by UIDL so each is processed once.
+package pop3
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeouts so a dead or hung server does not block a poll forever.
+const (
+	dialTimeout    = 30 * time.Second // connection setup, greeting and STLS
+	commandTimeout = 2 * time.Minute  // one command, including a message download
+)
+
+// rootCAs replaces the system certificate pool when set (tests against a stub server).
+var rootCAs *x509.CertPool
+
+// Client is a minimal POP3 client: the commands needed to list, download and delete messages.
+type Client struct {
+	conn net.Conn
+	text *textproto.Conn
+	stop func() bool // unregisters the close on context cancellation
+}
+
+// Entry is one line of the UIDL listing.
+type Entry struct {
+	Num  int    // message number in this session
+	UIDL string // unique ID, stable across sessions
+}
+
+// Dial connects to addr (host:port) with implicit TLS, or upgrades a plain connection with
+// STLS when startTLS is set, and reads the greeting. The connection is closed when ctx is done,
+// which makes the pending command fail.
+func Dial(ctx context.Context, addr string, startTLS bool) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: host, RootCAs: rootCAs}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	if startTLS {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, text: textproto.NewConn(conn)}
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := c.response(); err != nil {
+		c.close()
+		return nil, err
+	}
+	if startTLS {
+		if _, err := c.cmd("STLS"); err != nil {
+			c.close()
+			return nil, fmt.Errorf("STLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			c.close()
+			return nil, err
+		}
+		c.conn, c.text = tlsConn, textproto.NewConn(tlsConn)
+	}
+	return c, nil
+}
+
+// close closes the connection without QUIT (nothing is deleted).
+func (c *Client) close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+// response reads a status line and returns the text after "+OK".
+func (c *Client) response() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "+OK") {
+		return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+	}
+	return "", fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+}
+
+// cmd sends a command and reads its status line; the command and any multi-line answer
+// read after it must complete within commandTimeout.
+func (c *Client) cmd(format string, args ...interface{}) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// multiline runs a command with a dot-terminated multi-line answer and returns its body.
+func (c *Client) multiline(format string, args ...interface{}) ([]byte, error) {
+	if _, err := c.cmd(format, args...); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(c.text.DotReader())
+}
+
+// Login authenticates with USER/PASS.
+func (c *Client) Login(user, password string) error {
+	if _, err := c.cmd("USER %s", user); err != nil {
+		return err
+	}
+	_, err := c.cmd("PASS %s", password)
+	return err
+}
+
+// Stat returns the number of messages in the maildrop.
+func (c *Client) Stat() (int, error) {
+	resp, err := c.cmd("STAT")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(resp)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid STAT response %q", resp)
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// UIDL lists the unique IDs of all messages, in message number order.
+func (c *Client) UIDL() ([]Entry, error) {
+	body, err := c.multiline("UIDL")
+	if err != nil {
+		return nil, err
+	}
+	var out []Entry
+	for _, line := range strings.Split(string(body), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		out = append(out, Entry{Num: n, UIDL: fields[1]})
+	}
+	return out, nil
+}
+
+// Retr downloads a whole message.
+func (c *Client) Retr(n int) ([]byte, error) {
+	return c.multiline("RETR %d", n)
+}
+
+// Top downloads the header of a message and the first lines of its body.
+func (c *Client) Top(n, lines int) ([]byte, error) {
+	return c.multiline("TOP %d %d", n, lines)
+}
+
+// Dele marks a message for deletion; the server removes it when the session ends with Quit.
+func (c *Client) Dele(n int) error {
+	_, err := c.cmd("DELE %d", n)
+	return err
+}
+
+// Quit ends the session (committing deletions) and closes the connection.
+func (c *Client) Quit() error {
+	_, err := c.cmd("QUIT")
+	if cErr := c.close(); err == nil {
+		err = cErr
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package pop3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+
+	"igcmailimap/config"
+	"igcmailimap/filter"
+	"igcmailimap/logger"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// Fetcher downloads new messages from a POP3 maildrop. It implements source.Source,
+// source.IDFetcher and source.Acknowledger.
+type Fetcher struct {
+	cfg   *config.Config
+	state *state.State
+	log   *logger.Logger
+}
+
+// NewFetcher returns a fetcher for the given config and state that logs to log (may be nil).
+func NewFetcher(cfg *config.Config, st *state.State, log *logger.Logger) *Fetcher {
+	return &Fetcher{cfg: cfg, state: st, log: log.With(logger.KeyAccount, cfg.IMAPUser)}
+}
+
+func (f *Fetcher) configured() bool {
+	return f.cfg.IMAPServer != "" && f.cfg.IMAPUser != "" && f.cfg.IMAPPassword != ""
+}
+
+// connect dials and logs in. The caller must Quit.
+func (f *Fetcher) connect(ctx context.Context) (*Client, error) {
+	addr := config.WithDefaultPort(f.cfg.IMAPServer, config.ProtocolPOP3, f.cfg.Security)
+	c, err := Dial(ctx, addr, f.cfg.Security == config.SecurityStartTLS)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(f.cfg.IMAPUser, f.cfg.IMAPPassword); err != nil {
+		f.quit(ctx, c)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (f *Fetcher) quit(ctx context.Context, c *Client) {
+	if ctx.Err() != nil {
+		// Cancelled: the connection is already closed
+		c.close()
+		return
+	}
+	if err := c.Quit(); err != nil {
+		f.log.Warning("POP3 QUIT failed", logger.KeyError, err)
+	}
+}
+
+// TestConnection logs in and returns the number of messages in the maildrop.
+func (f *Fetcher) TestConnection(ctx context.Context) (uint32, error) {
+	if !f.configured() {
+		return 0, fmt.Errorf("POP3 server, user and password must be set")
+	}
+	c, err := f.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer f.quit(ctx, c)
+	n, err := c.Stat()
+	return uint32(n), err
+}
+
+// FetchNew downloads the messages whose UIDL has not been seen before and records them in the state.
+// With config.POP3Delete, the messages the engine has processed since (see Processed) are deleted
+// from the server; messages skipped by the first-run backfill or that failed are left on it.
+// On the very first run config.BackfillMode decides what is imported.
+func (f *Fetcher) FetchNew(ctx context.Context) ([]source.Message, error) {
+	if !f.configured() {
+		return nil, nil // no config, skip
+	}
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer f.quit(ctx, c)
+
+	entries, err := c.UIDL()
+	if err != nil {
+		return nil, fmt.Errorf("UIDL: %w", err)
+	}
+	seen := make(map[string]bool, len(f.state.POP3Seen))
+	for _, u := range f.state.POP3Seen {
+		seen[u] = true
+	}
+
+	var wanted []Entry
+	if !f.state.POP3Initialized {
+		wanted, err = f.backfill(c, entries)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, e := range entries {
+			if !seen[e.UIDL] {
+				wanted = append(wanted, e)
+			}
+		}
+	}
+
+	out, err := retrieve(c, wanted)
+	if err != nil {
+		// Nothing is recorded as seen, so the whole batch is retried next poll
+		return nil, err
+	}
+
+	if f.cfg.POP3Delete {
+		// Only deleted once the server commits the session with QUIT; otherwise they are still
+		// listed and deleted at the next poll
+		processed := make(map[string]bool, len(f.state.POP3Processed))
+		for _, u := range f.state.POP3Processed {
+			processed[u] = true
+		}
+		for _, e := range entries {
+			if processed[e.UIDL] {
+				if err := c.Dele(e.Num); err != nil {
+					f.log.Warning("POP3 DELE failed", logger.KeyID, e.UIDL, logger.KeyError, err)
+				}
+			}
+		}
+	}
+
+	// Everything still listed is now either seen before or fetched above (or skipped by the
+	// backfill); UIDLs no longer on the server are dropped to keep the state small.
+	var keep []string
+	for _, e := range entries {
+		keep = append(keep, e.UIDL)
+	}
+	path, _ := config.StatePath()
+	if err := state.UpdatePOP3Seen(path, f.state, keep); err != nil {
+		f.log.Error("Failed to save state", logger.KeyError, err)
+	}
+	return out, nil
+}
+
+// FetchIDs downloads the messages with the given UIDLs that are still on the server, for
+// retrying failed messages. It does not change the saved state.
+func (f *Fetcher) FetchIDs(ctx context.Context, ids []string) ([]source.Message, error) {
+	if !f.configured() {
+		return nil, fmt.Errorf("POP3 server, user and password must be set")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	c, err := f.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer f.quit(ctx, c)
+
+	entries, err := c.UIDL()
+	if err != nil {
+		return nil, fmt.Errorf("UIDL: %w", err)
+	}
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	var list []Entry
+	for _, e := range entries {
+		if wanted[e.UIDL] {
+			list = append(list, e)
+		}
+	}
+	return retrieve(c, list)
+}
+
+// retrieve downloads the listed messages; it fails if any of them cannot be downloaded.
+func retrieve(c *Client, entries []Entry) ([]source.Message, error) {
+	var out []source.Message
+	for _, e := range entries {
+		body, err := c.Retr(e.Num)
+		if err != nil {
+			return nil, fmt.Errorf("RETR %d: %w", e.Num, err)
+		}
+		m := filter.ParseMessage(body)
+		out = append(out, source.Message{ID: e.UIDL, Folder: "INBOX", Subject: m.Subject, From: m.From, Body: body})
+	}
+	return out, nil
+}
+
+// Processed records the UIDLs of processed messages in the state, so config.POP3Delete deletes
+// them at the next poll.
+func (f *Fetcher) Processed(msgs []source.Message) error {
+	var uidls []string
+	for _, m := range msgs {
+		if m.ID != "" {
+			uidls = append(uidls, m.ID)
+		}
+	}
+	path, err := config.StatePath()
+	if err != nil {
+		return err
+	}
+	return state.AddPOP3Processed(path, f.state, uidls)
+}
+
+// backfill picks the messages to import on the first run according to config.BackfillMode.
+func (f *Fetcher) backfill(c *Client, entries []Entry) ([]Entry, error) {
+	switch f.cfg.BackfillMode {
+	case config.BackfillNow:
+		return nil, nil
+	case config.BackfillSince:
+		since, err := f.cfg.BackfillSinceDate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid backfill date %q: %w", f.cfg.BackfillSince, err)
+		}
+		var out []Entry
+		for _, e := range entries {
+			head, err := c.Top(e.Num, 0)
+			if err != nil {
+				return nil, fmt.Errorf("TOP %d: %w", e.Num, err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(head))
+			if err != nil {
+				out = append(out, e) // unreadable header: import rather than silently skip
+				continue
+			}
+			if date, err := msg.Header.Date(); err != nil || !date.Before(since) {
+				out = append(out, e)
+			}
+		}
+		return out, nil
+	default: // config.BackfillAll
+		return entries, nil
+	}
+}
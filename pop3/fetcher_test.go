@@ -0,0 +1,343 @@
+package pop3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"igcmailimap/config"
+	"igcmailimap/source"
+	"igcmailimap/state"
+)
+
+// stubServer is a POP3 server over TLS holding a maildrop shared by all sessions.
+type stubServer struct {
+	addr string
+
+	mu      sync.Mutex
+	msgs    []stubMessage
+	deleted []string // UIDLs removed by a committed DELE
+}
+
+type stubMessage struct {
+	uidl, date, subject string
+}
+
+func (m stubMessage) raw() string {
+	return "From: pilot@club.org\r\nDate: " + m.date + "\r\nSubject: " + m.subject + "\r\n\r\nflight\r\n"
+}
+
+func newStubServer(t *testing.T, msgs ...stubMessage) *stubServer {
+	t.Helper()
+	cert, pool := testCertificate(t)
+	rootCAs = pool
+	t.Cleanup(func() { rootCAs = nil })
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &stubServer{addr: ln.Addr().String(), msgs: msgs}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *stubServer) add(m stubMessage) {
+	s.mu.Lock()
+	s.msgs = append(s.msgs, m)
+	s.mu.Unlock()
+}
+
+func (s *stubServer) deletedUIDLs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+func (s *stubServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	s.mu.Lock()
+	drop := append([]stubMessage(nil), s.msgs...) // message numbers are fixed for the session
+	s.mu.Unlock()
+	dele := make(map[int]bool)
+
+	msg := func(arg string) (stubMessage, bool) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(drop) {
+			return stubMessage{}, false
+		}
+		return drop[n-1], true
+	}
+	lines := func(body string) {
+		w := text.DotWriter()
+		w.Write([]byte(body))
+		w.Close()
+	}
+
+	text.PrintfLine("+OK stub ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			text.PrintfLine("-ERR empty command")
+			continue
+		}
+		switch strings.ToUpper(f[0]) {
+		case "USER", "PASS":
+			text.PrintfLine("+OK")
+		case "STAT":
+			text.PrintfLine("+OK %d 0", len(drop))
+		case "UIDL":
+			text.PrintfLine("+OK")
+			var b strings.Builder
+			for i, m := range drop {
+				fmt.Fprintf(&b, "%d %s\r\n", i+1, m.uidl)
+			}
+			lines(b.String())
+		case "RETR", "TOP":
+			m, ok := msg(f[1])
+			if !ok {
+				text.PrintfLine("-ERR no such message")
+				continue
+			}
+			text.PrintfLine("+OK")
+			if f[0] == "TOP" {
+				lines(strings.SplitAfter(m.raw(), "\r\n\r\n")[0])
+			} else {
+				lines(m.raw())
+			}
+		case "DELE":
+			n, _ := strconv.Atoi(f[1])
+			dele[n] = true
+			text.PrintfLine("+OK")
+		case "QUIT":
+			s.mu.Lock()
+			var keep []stubMessage
+			for _, m := range s.msgs {
+				gone := false
+				for n := range dele {
+					if drop[n-1].uidl == m.uidl {
+						gone = true
+					}
+				}
+				if gone {
+					s.deleted = append(s.deleted, m.uidl)
+				} else {
+					keep = append(keep, m)
+				}
+			}
+			s.msgs = keep
+			s.mu.Unlock()
+			text.PrintfLine("+OK bye")
+			return
+		default:
+			text.PrintfLine("-ERR unknown command")
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stub"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func testFetcher(t *testing.T, s *stubServer, mode string, del bool) *Fetcher {
+	t.Helper()
+	config.SetDir(t.TempDir())
+	cfg := &config.Config{
+		Protocol: config.ProtocolPOP3, Security: config.SecurityTLS, IMAPServer: s.addr,
+		IMAPUser: "pilot", IMAPPassword: "secret", BackfillMode: mode, BackfillSince: "2024-06-01",
+		POP3Delete: del,
+	}
+	return NewFetcher(cfg, &state.State{}, nil)
+}
+
+func ids(msgs []source.Message) []string {
+	var out []string
+	for _, m := range msgs {
+		if m.UID != 0 {
+			out = append(out, fmt.Sprintf("uid %d", m.UID))
+		}
+		out = append(out, m.ID)
+	}
+	return out
+}
+
+var (
+	mayMsg  = stubMessage{"a1", "Sat, 11 May 2024 10:00:00 +0000", "May flight"}
+	juneMsg = stubMessage{"b2", "Sun, 02 Jun 2024 10:00:00 +0000", "June flight"}
+	newMsg  = stubMessage{"c3", "Mon, 01 Jul 2024 10:00:00 +0000", "New flight"}
+)
+
+func TestFetchNewBackfill(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{config.BackfillNow, nil},
+		{config.BackfillSince, []string{"b2"}},
+		{config.BackfillAll, []string{"a1", "b2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			s := newStubServer(t, mayMsg, juneMsg)
+			f := testFetcher(t, s, tt.mode, false)
+			msgs, err := f.FetchNew(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("first poll = %q, want %q", got, tt.want)
+			}
+
+			// Later polls only return mail that arrived since, whatever the first poll imported
+			s.add(newMsg)
+			msgs, err = f.FetchNew(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(msgs); !reflect.DeepEqual(got, []string{"c3"}) {
+				t.Errorf("second poll = %q, want [c3]", got)
+			}
+
+			// The state survives a restart
+			path, _ := config.StatePath()
+			st, err := state.Load(path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a1", "b2", "c3"}; !reflect.DeepEqual(st.POP3Seen, want) || !st.POP3Initialized {
+				t.Errorf("saved state: seen %q, initialized %v; want %q, true", st.POP3Seen, st.POP3Initialized, want)
+			}
+		})
+	}
+}
+
+func TestDeleteOnlyProcessed(t *testing.T) {
+	s := newStubServer(t, mayMsg)
+	f := testFetcher(t, s, config.BackfillNow, true)
+	ctx := context.Background()
+
+	// a1 is left out by the first-run backfill
+	if _, err := f.FetchNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.add(juneMsg)
+	s.add(newMsg)
+	msgs, err := f.FetchNew(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(msgs); !reflect.DeepEqual(got, []string{"b2", "c3"}) {
+		t.Fatalf("fetched %q, want [b2 c3]", got)
+	}
+	if got := s.deletedUIDLs(); len(got) != 0 {
+		t.Fatalf("deleted %q before anything was processed", got)
+	}
+
+	// c3 failed extraction, so only b2 is acknowledged
+	if err := f.Processed(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.FetchNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.deletedUIDLs(); !reflect.DeepEqual(got, []string{"b2"}) {
+		t.Errorf("deleted %q, want [b2]", got)
+	}
+
+	// Once gone from the server, b2 is dropped from the state
+	if _, err := f.FetchNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.state.POP3Processed) != 0 || !reflect.DeepEqual(f.state.POP3Seen, []string{"a1", "c3"}) {
+		t.Errorf("state: processed %q, seen %q; want [], [a1 c3]", f.state.POP3Processed, f.state.POP3Seen)
+	}
+}
+
+func TestUIDL(t *testing.T) {
+	s := newStubServer(t, mayMsg, juneMsg)
+	c, err := Dial(context.Background(), s.addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+	entries, err := c.UIDL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{{1, "a1"}, {2, "b2"}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("UIDL = %v, want %v", entries, want)
+	}
+}
+
+func TestFetchIDs(t *testing.T) {
+	s := newStubServer(t, mayMsg, juneMsg, newMsg)
+	f := testFetcher(t, s, config.BackfillAll, false)
+	msgs, err := f.FetchIDs(context.Background(), []string{"c3", "gone", "a1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(msgs); !reflect.DeepEqual(got, []string{"a1", "c3"}) {
+		t.Errorf("FetchIDs = %q, want [a1 c3]", got)
+	}
+	if msgs[1].Subject != "New flight" || msgs[1].From != "pilot@club.org" {
+		t.Errorf("message = %+v", msgs[1])
+	}
+	if f.state.POP3Initialized || len(f.state.POP3Seen) != 0 {
+		t.Error("FetchIDs changed the state")
+	}
+}
@@ -0,0 +1,130 @@
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+var (
+	baroLine = color.RGBA{R: 0x15, G: 0x65, B: 0xc0, A: 0xff}
+	baroFill = color.RGBA{R: 0xc5, G: 0xda, B: 0xf0, A: 0xff}
+)
+
+// Barogram draws the altitude over time into w×h pixels. It uses the pressure altitude when
+// the logger recorded one, otherwise the GNSS altitude. Times are UTC, as in the IGC file.
+func Barogram(fl *igc.Flight, w, h int) *image.RGBA {
+	p := newPen(w, h, white)
+	const left, right, top, bottom = 48, 8, 8, 20
+	plotW, plotH := w-left-right, h-top-bottom
+	if len(fl.Fixes) < 2 || plotW < 10 || plotH < 10 {
+		return p.img
+	}
+	alt := baroAltitude(fl.Fixes)
+	start, end := fl.Fixes[0].Time, fl.Fixes[len(fl.Fixes)-1].Time
+	span := end.Sub(start).Seconds()
+	if span <= 0 {
+		return p.img
+	}
+
+	lo, hi := alt(fl.Fixes[0]), alt(fl.Fixes[0])
+	for _, f := range fl.Fixes {
+		lo, hi = min(lo, alt(f)), max(hi, alt(f))
+	}
+	altStep := niceStep(float64(hi-lo) / 4)
+	bottomAlt := math.Floor(float64(lo)/altStep) * altStep
+	topAlt := math.Ceil(float64(hi)/altStep) * altStep
+	if topAlt <= bottomAlt {
+		topAlt = bottomAlt + altStep
+	}
+	xOf := func(t time.Time) float64 { return float64(left) + t.Sub(start).Seconds()/span*float64(plotW) }
+	yOf := func(a float64) float64 { return float64(top+plotH) - (a-bottomAlt)/(topAlt-bottomAlt)*float64(plotH) }
+
+	// Altitude per pixel column, the highest fix in each, gaps interpolated
+	cols := make([]float64, plotW+1)
+	for i := range cols {
+		cols[i] = math.NaN()
+	}
+	for _, f := range fl.Fixes {
+		i := int(xOf(f.Time)) - left
+		if i >= 0 && i < len(cols) && (math.IsNaN(cols[i]) || float64(alt(f)) > cols[i]) {
+			cols[i] = float64(alt(f))
+		}
+	}
+	fillGaps(cols)
+
+	// Altitude grid and labels
+	for a := bottomAlt; a <= topAlt+altStep/2; a += altStep {
+		y := yOf(a)
+		p.line(float64(left), y, float64(left+plotW), y, 1, 0, gridColor)
+		label := fmt.Sprintf("%.0f m", a)
+		p.text(left-4-textWidth(label), int(y)+4, label, inkColor)
+	}
+	// Time grid and labels
+	minutes := niceMinutes(span / 60 / 6)
+	first := start.Truncate(time.Duration(minutes) * time.Minute)
+	for t := first; !t.After(end); t = t.Add(time.Duration(minutes) * time.Minute) {
+		if t.Before(start) {
+			continue
+		}
+		x := xOf(t)
+		p.line(x, float64(top), x, float64(top+plotH), 1, 0, gridColor)
+		p.text(int(x)-textWidth("00:00")/2, h-5, t.UTC().Format("15:04"), inkColor)
+	}
+
+	// Filled profile, then its outline
+	base := float64(top + plotH)
+	for i, a := range cols {
+		if !math.IsNaN(a) {
+			p.line(float64(left+i), yOf(a), float64(left+i), base, 1, 0, baroFill)
+		}
+	}
+	for i := 1; i < len(cols); i++ {
+		if !math.IsNaN(cols[i-1]) && !math.IsNaN(cols[i]) {
+			p.line(float64(left+i-1), yOf(cols[i-1]), float64(left+i), yOf(cols[i]), 2, 0, baroLine)
+		}
+	}
+	p.line(float64(left), base, float64(left+plotW), base, 1, 0, inkColor)
+	p.line(float64(left), float64(top), float64(left), base, 1, 0, inkColor)
+	return p.img
+}
+
+// baroAltitude returns the altitude used for the barogram: pressure if any fix has one.
+func baroAltitude(fixes []igc.Fix) func(igc.Fix) int {
+	for _, f := range fixes {
+		if f.PressureAlt != 0 {
+			return func(f igc.Fix) int { return f.PressureAlt }
+		}
+	}
+	return func(f igc.Fix) int { return f.GNSSAlt }
+}
+
+// fillGaps linearly interpolates the NaN values between known ones.
+func fillGaps(cols []float64) {
+	last := -1
+	for i, v := range cols {
+		if math.IsNaN(v) {
+			continue
+		}
+		if last >= 0 && i-last > 1 {
+			for j := last + 1; j < i; j++ {
+				cols[j] = cols[last] + (v-cols[last])*float64(j-last)/float64(i-last)
+			}
+		}
+		last = i
+	}
+}
+
+// niceMinutes returns a round time grid spacing of at least rough minutes.
+func niceMinutes(rough float64) int {
+	for _, m := range []int{5, 10, 15, 30, 60, 120, 180, 360} {
+		if float64(m) >= rough {
+			return m
+		}
+	}
+	return 720
+}
@@ -0,0 +1,114 @@
+package preview
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// face is the label font; it needs no font files and covers ASCII.
+var face = basicfont.Face7x13
+
+// pen draws lines, dots and labels on an RGBA image.
+type pen struct {
+	img *image.RGBA
+}
+
+func newPen(w, h int, bg color.RGBA) pen {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+	return pen{img: img}
+}
+
+// line draws a line width pixels thick. dash > 0 draws dashes of that many pixels.
+func (p pen) line(x0, y0, x1, y1 float64, width, dash int, c color.RGBA) {
+	dx, dy := x1-x0, y1-y0
+	steps := int(math.Ceil(math.Max(math.Abs(dx), math.Abs(dy))))
+	if steps == 0 {
+		p.square(int(x0), int(y0), width, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		if dash > 0 && (i/dash)%2 == 1 {
+			continue
+		}
+		t := float64(i) / float64(steps)
+		p.square(int(math.Round(x0+dx*t)), int(math.Round(y0+dy*t)), width, c)
+	}
+}
+
+func (p pen) square(x, y, width int, c color.RGBA) {
+	lo := -(width - 1) / 2
+	for j := lo; j < lo+width; j++ {
+		for i := lo; i < lo+width; i++ {
+			p.img.SetRGBA(x+i, y+j, c)
+		}
+	}
+}
+
+// dot draws a filled circle with a white outline.
+func (p pen) dot(x, y float64, r int, c color.RGBA) {
+	cx, cy := int(math.Round(x)), int(math.Round(y))
+	for j := -r - 1; j <= r+1; j++ {
+		for i := -r - 1; i <= r+1; i++ {
+			d := i*i + j*j
+			switch {
+			case d <= r*r:
+				p.img.SetRGBA(cx+i, cy+j, c)
+			case d <= (r+1)*(r+1):
+				p.img.SetRGBA(cx+i, cy+j, white)
+			}
+		}
+	}
+}
+
+// rect fills a rectangle.
+func (p pen) rect(r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(p.img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// text draws s with its baseline at y, starting at x.
+func (p pen) text(x, y int, s string, c color.RGBA) {
+	d := font.Drawer{Dst: p.img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
+
+// textWidth returns the width of s in pixels.
+func textWidth(s string) int {
+	return font.MeasureString(face, s).Ceil()
+}
+
+// niceStep returns the smallest of 1, 2 or 5 times a power of ten that is at least rough.
+func niceStep(rough float64) float64 {
+	if rough <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(rough)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*exp >= rough {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// decimals returns the number of decimals needed to print multiples of step.
+func decimals(step float64) int {
+	if step >= 1 {
+		return 0
+	}
+	return int(math.Ceil(-math.Log10(step) - 1e-9))
+}
+
+var white = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
@@ -0,0 +1,253 @@
+// Package preview renders a flight for a quick look: the track and declared task on a plain
+// latitude/longitude grid with a scale bar (no map tiles or network needed), and a barogram.
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"igcmailimap/igc"
+)
+
+var (
+	paper     = color.RGBA{R: 0xf5, G: 0xf3, B: 0xee, A: 0xff}
+	gridColor = color.RGBA{R: 0xd9, G: 0xd4, B: 0xc8, A: 0xff}
+	inkColor  = color.RGBA{R: 0x5f, G: 0x5b, B: 0x54, A: 0xff}
+	taskColor = color.RGBA{R: 0x8e, G: 0x24, B: 0xaa, A: 0xff}
+	startDot  = color.RGBA{R: 0x2e, G: 0x7d, B: 0x32, A: 0xff}
+	endDot    = color.RGBA{R: 0xc6, G: 0x28, B: 0x28, A: 0xff}
+)
+
+// altitudeColors is the track colour scale, from the lowest to the highest altitude.
+var altitudeColors = []color.RGBA{
+	{R: 0x15, G: 0x65, B: 0xc0, A: 0xff},
+	{R: 0x00, G: 0x96, B: 0x88, A: 0xff},
+	{R: 0x7c, G: 0xb3, B: 0x42, A: 0xff},
+	{R: 0xf9, G: 0xa8, B: 0x25, A: 0xff},
+	{R: 0xd8, G: 0x43, B: 0x15, A: 0xff},
+}
+
+const (
+	margin   = 24      // pixels kept free around the track
+	kmPerDeg = 111.195 // length of one degree of latitude
+)
+
+// Map draws the track, coloured by altitude, and the declared task, fitted into w×h pixels.
+func Map(fl *igc.Flight, w, h int) *image.RGBA {
+	p := newPen(w, h, paper)
+	task := taskPoints(fl)
+	if len(fl.Fixes) == 0 || w < 2*margin || h < 2*margin {
+		return p.img
+	}
+	proj := newProjection(fl.Fixes, task, w, h)
+
+	drawGrid(p, proj, w, h)
+
+	// Declared task: dashed legs and turnpoints; a point used twice (start and finish) is
+	// labelled once with both names
+	labels := make(map[igc.Waypoint]string)
+	var order []igc.Waypoint
+	for i, wp := range task {
+		x, y := proj.xy(wp.Lat, wp.Lon)
+		if i > 0 {
+			px, py := proj.xy(task[i-1].Lat, task[i-1].Lon)
+			p.line(px, py, x, y, 2, 6, taskColor)
+		}
+		at := igc.Waypoint{Lat: wp.Lat, Lon: wp.Lon}
+		if name, ok := labels[at]; ok {
+			labels[at] = name + " / " + wp.Name
+			continue
+		}
+		labels[at] = wp.Name
+		order = append(order, at)
+	}
+	for _, at := range order {
+		x, y := proj.xy(at.Lat, at.Lon)
+		p.dot(x, y, 3, taskColor)
+		p.text(int(x)+6, int(y)-4, labels[at], taskColor)
+	}
+
+	// Track, coloured by altitude; long tracks are thinned to about two fixes per pixel
+	lo, hi := altitudeRange(fl.Fixes)
+	step := len(fl.Fixes)/(2*(w+h)) + 1
+	prev := fl.Fixes[0]
+	for i := step; i < len(fl.Fixes); i += step {
+		f := fl.Fixes[i]
+		x0, y0 := proj.xy(prev.Lat, prev.Lon)
+		x1, y1 := proj.xy(f.Lat, f.Lon)
+		p.line(x0, y0, x1, y1, 2, 0, altitudeColor(f.Altitude(), lo, hi))
+		prev = f
+	}
+	first, last := fl.Fixes[0], fl.Fixes[len(fl.Fixes)-1]
+	x, y := proj.xy(last.Lat, last.Lon)
+	p.dot(x, y, 4, endDot)
+	x, y = proj.xy(first.Lat, first.Lon)
+	p.dot(x, y, 4, startDot)
+
+	drawScaleBar(p, proj, h)
+	drawLegend(p, lo, hi)
+	return p.img
+}
+
+// projection maps latitude/longitude to pixels (equirectangular, north up, scaled for the
+// latitude of the flight so distances look right).
+type projection struct {
+	lat0, lon0 float64 // centre of the flight
+	kx         float64 // cos(lat0): east-west shrink of a degree of longitude
+	scale      float64 // pixels per degree of latitude
+	cx, cy     float64 // image centre
+}
+
+func newProjection(fixes []igc.Fix, task []igc.Waypoint, w, h int) projection {
+	minLat, maxLat := fixes[0].Lat, fixes[0].Lat
+	minLon, maxLon := fixes[0].Lon, fixes[0].Lon
+	grow := func(lat, lon float64) {
+		minLat, maxLat = math.Min(minLat, lat), math.Max(maxLat, lat)
+		minLon, maxLon = math.Min(minLon, lon), math.Max(maxLon, lon)
+	}
+	for _, f := range fixes {
+		grow(f.Lat, f.Lon)
+	}
+	for _, wp := range task {
+		grow(wp.Lat, wp.Lon)
+	}
+	p := projection{lat0: (minLat + maxLat) / 2, lon0: (minLon + maxLon) / 2, cx: float64(w) / 2, cy: float64(h) / 2}
+	p.kx = math.Cos(p.lat0 * math.Pi / 180)
+	spanX := math.Max((maxLon-minLon)*p.kx, 0.005)
+	spanY := math.Max(maxLat-minLat, 0.005)
+	p.scale = math.Min(float64(w-2*margin)/spanX, float64(h-2*margin)/spanY)
+	return p
+}
+
+func (p projection) xy(lat, lon float64) (float64, float64) {
+	return p.cx + (lon-p.lon0)*p.kx*p.scale, p.cy - (lat-p.lat0)*p.scale
+}
+
+// latLon is the inverse of xy.
+func (p projection) latLon(x, y float64) (float64, float64) {
+	return p.lat0 - (y-p.cy)/p.scale, p.lon0 + (x-p.cx)/(p.kx*p.scale)
+}
+
+// drawGrid draws latitude/longitude lines at a round spacing, labelled along the left and
+// bottom edges.
+func drawGrid(p pen, proj projection, w, h int) {
+	top, left := proj.latLon(0, 0)
+	bottom, right := proj.latLon(float64(w), float64(h))
+	step := niceStep(math.Max(top-bottom, right-left) / 6)
+	format := fmt.Sprintf("%%.%df%%s", decimals(step))
+
+	for lat := math.Ceil(bottom/step) * step; lat <= top; lat += step {
+		_, y := proj.xy(lat, proj.lon0)
+		p.line(0, y, float64(w), y, 1, 0, gridColor)
+		p.text(3, int(y)-3, fmt.Sprintf(format, math.Abs(lat), hemisphere(lat, "N", "S")), inkColor)
+	}
+	for lon := math.Ceil(left/step) * step; lon <= right; lon += step {
+		x, _ := proj.xy(proj.lat0, lon)
+		p.line(x, 0, x, float64(h), 1, 0, gridColor)
+		p.text(int(x)+3, h-4, fmt.Sprintf(format, math.Abs(lon), hemisphere(lon, "E", "W")), inkColor)
+	}
+}
+
+func hemisphere(v float64, pos, neg string) string {
+	if v < 0 {
+		return neg
+	}
+	return pos
+}
+
+// drawScaleBar draws a bar of a round length, about a fifth of the width, in the bottom left corner.
+func drawScaleBar(p pen, proj projection, h int) {
+	pxPerKm := proj.scale / kmPerDeg
+	km := niceStep(float64(p.img.Bounds().Dx()) / 5 / pxPerKm)
+	length := km * pxPerKm
+	label := fmt.Sprintf("%g km", km)
+	x0, y := 12.0, float64(h-24)
+	p.rect(image.Rect(int(x0)-6, int(y)-20, int(x0+length)+8, int(y)+6), paper)
+	p.line(x0, y, x0+length, y, 3, 0, inkColor)
+	p.line(x0, y-5, x0, y+3, 1, 0, inkColor)
+	p.line(x0+length, y-5, x0+length, y+3, 1, 0, inkColor)
+	p.text(int(x0), int(y)-7, label, inkColor)
+}
+
+// drawLegend draws the altitude colour scale in the top right corner.
+func drawLegend(p pen, lo, hi int) {
+	const width, height = 100, 8
+	right := p.img.Bounds().Dx() - 12
+	label := fmt.Sprintf("%d - %d m", lo, hi)
+	left := right - width
+	p.rect(image.Rect(left-4, 4, right+4, 36), paper)
+	for i := 0; i < width; i++ {
+		alt := lo + (hi-lo)*i/width
+		p.rect(image.Rect(left+i, 8, left+i+1, 8+height), altitudeColor(alt, lo, hi))
+	}
+	p.text(right-textWidth(label), 30, label, inkColor)
+}
+
+// altitudeRange returns the lowest and highest altitude of the fixes.
+func altitudeRange(fixes []igc.Fix) (lo, hi int) {
+	lo, hi = fixes[0].Altitude(), fixes[0].Altitude()
+	for _, f := range fixes {
+		lo, hi = min(lo, f.Altitude()), max(hi, f.Altitude())
+	}
+	return lo, hi
+}
+
+// altitudeColor interpolates altitudeColors for alt in [lo, hi].
+func altitudeColor(alt, lo, hi int) color.RGBA {
+	if hi <= lo {
+		return altitudeColors[0]
+	}
+	t := float64(alt-lo) / float64(hi-lo) * float64(len(altitudeColors)-1)
+	i := int(t)
+	if i >= len(altitudeColors)-1 {
+		return altitudeColors[len(altitudeColors)-1]
+	}
+	a, b, f := altitudeColors[i], altitudeColors[i+1], t-float64(i)
+	mix := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 0xff}
+}
+
+// taskPoints returns the declared turnpoints, without the 0,0 placeholders loggers write for
+// an undeclared takeoff or landing.
+func taskPoints(fl *igc.Flight) []igc.Waypoint {
+	var out []igc.Waypoint
+	for _, wp := range fl.Task {
+		if wp.Lat != 0 || wp.Lon != 0 {
+			out = append(out, wp)
+		}
+	}
+	return out
+}
+
+// Summary holds the key figures of a flight.
+type Summary struct {
+	Takeoff, Landing time.Time // times of the first and last fix (UTC)
+	MinAlt, MaxAlt   int       // metres
+	Distance         float64   // length of the track, km
+}
+
+// Summarize computes the summary of a flight with at least one fix.
+func Summarize(fl *igc.Flight) Summary {
+	if len(fl.Fixes) == 0 {
+		return Summary{}
+	}
+	s := Summary{Takeoff: fl.Fixes[0].Time, Landing: fl.Fixes[len(fl.Fixes)-1].Time}
+	s.MinAlt, s.MaxAlt = altitudeRange(fl.Fixes)
+	for i := 1; i < len(fl.Fixes); i++ {
+		s.Distance += distance(fl.Fixes[i-1], fl.Fixes[i])
+	}
+	return s
+}
+
+// distance returns the great-circle distance between two fixes in km (haversine).
+func distance(a, b igc.Fix) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lon - a.Lon) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,133 @@
+// Package source defines the mail-source interface (IMAP, POP3, ...) the engine polls, so the
+// filtering and extraction pipeline does not depend on the protocol.
+package source
+
+import (
+	"context"
+	"time"
+
+	"igcmailimap/filter"
+)
+
+// Message is a raw RFC 822 message fetched from a mail source, ready for extraction.
+type Message struct {
+	UID     uint32 // IMAP UID; 0 for other sources
+	ID      string // stable ID of a message without UID: POP3 UIDL or JMAP Email id
+	Folder  string // mailbox the message was fetched from, or the archive file it was read from
+	Subject string
+	From    string
+	Body    []byte
+	// Err is set when the message could not be fetched completely (e.g. an attachment failed to
+	// download): it is recorded as failed and retried instead of being extracted.
+	Err error
+}
+
+// FilterMessage returns the fields filter rules are evaluated on: From and Subject as reported by
+// the source (e.g. the IMAP envelope), To/Cc, List-Id and other headers from the message itself.
+func (m Message) FilterMessage() filter.Message {
+	fm := filter.ParseMessage(m.Body)
+	if m.From != "" {
+		fm.From = m.From
+	}
+	if m.Subject != "" {
+		fm.Subject = m.Subject
+	}
+	return fm
+}
+
+// Source is a mailbox polled for new mail. Implementations record what they returned in
+// state.State so each message is processed once, and apply config.BackfillMode on the first run.
+// All methods give up when ctx is done; a fetch that is cancelled leaves the state unchanged.
+type Source interface {
+	// FetchNew returns the messages not fetched before and updates the saved state. Messages it
+	// returns count as fetched even if ctx is done by then, or if they come with an error (the
+	// fetch broke off after them); the caller must not drop them.
+	FetchNew(ctx context.Context) ([]Message, error)
+	// TestConnection logs in and returns the number of messages in the mailbox.
+	TestConnection(ctx context.Context) (uint32, error)
+}
+
+// RangeFetcher is implemented by sources that can re-import mail by date (IMAP SEARCH).
+type RangeFetcher interface {
+	// FetchRange returns the messages received in [since, before) without changing the state.
+	FetchRange(ctx context.Context, since, before time.Time) ([]Message, error)
+}
+
+// UIDFetcher is implemented by sources that can fetch a message again by its UID (IMAP), used
+// to retry failed messages and to reprocess messages from the history.
+type UIDFetcher interface {
+	// FetchUIDs returns the INBOX messages with the given UIDs that still exist.
+	FetchUIDs(ctx context.Context, uids []uint32) ([]Message, error)
+}
+
+// IDFetcher is implemented by sources without UIDs that can fetch a message again by its ID
+// (POP3 UIDL, JMAP Email id), used to retry failed messages.
+type IDFetcher interface {
+	// FetchIDs returns the messages with the given IDs that are still on the server.
+	FetchIDs(ctx context.Context, ids []string) ([]Message, error)
+}
+
+// Acknowledger is implemented by sources that act on messages once they have been processed
+// (POP3 deletes them from the server with config.POP3Delete).
+type Acknowledger interface {
+	// Processed records that the messages were processed: extracted, without flights, skipped
+	// by the filters or duplicates. Messages that failed are not passed, so they are kept.
+	Processed(msgs []Message) error
+}
+
+// FolderLister is implemented by sources with more than one mailbox.
+type FolderLister interface {
+	ListFolders(ctx context.Context) ([]string, error)
+}
+
+// Pusher is implemented by sources that can signal new mail without polling (JMAP EventSource).
+type Pusher interface {
+	// Watch calls changed whenever the mailbox may have new mail, until ctx is done.
+	// It reconnects by itself after errors.
+	Watch(ctx context.Context, changed func())
+}
+
+// Connection test steps (Step.Name), in the order they run.
+const (
+	StepConnect      = "Connect"
+	StepCapabilities = "Capabilities"
+	StepLogin        = "Log in"
+	StepFolders      = "Folders"
+)
+
+// Step is one stage of a connection test.
+type Step struct {
+	Name   string
+	Detail string // what was found, e.g. "imap.gmail.com:993 over TLS"
+	Err    error  // nil if the step succeeded
+}
+
+// Folder is a mailbox and the number of messages it holds.
+type Folder struct {
+	Name     string
+	Messages uint32
+}
+
+// Diagnosis is the result of a step-by-step connection test. The test stops at the first
+// failed step, which is then the last one.
+type Diagnosis struct {
+	Steps          []Step
+	Capabilities   []string // notable server capabilities, e.g. IDLE, MOVE, CONDSTORE
+	AuthMechanisms []string // SASL mechanisms offered, e.g. PLAIN, XOAUTH2
+	Folders        []Folder
+}
+
+// Failed returns the step that failed, or nil if the test succeeded.
+func (d *Diagnosis) Failed() *Step {
+	if n := len(d.Steps); n > 0 && d.Steps[n-1].Err != nil {
+		return &d.Steps[n-1]
+	}
+	return nil
+}
+
+// Diagnoser is implemented by sources that can test the connection step by step (IMAP).
+type Diagnoser interface {
+	// Diagnose connects, reads the server capabilities, logs in and counts the messages in
+	// each folder, stopping at the first failure.
+	Diagnose(ctx context.Context) *Diagnosis
+}
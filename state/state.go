@@ -2,45 +2,342 @@ package state
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/emersion/go-imap"
 )
 
-// State holds the last seen UID for incremental IMAP fetch.
+// State holds the position of one account (see Load) for incremental fetch.
 type State struct {
+	key string // account the state belongs to, see Load
+
 	LastUID uint32 `json:"last_uid"`
+	// UIDValidity is the INBOX UIDVALIDITY the UIDs belong to (IMAP); when the server reports
+	// another one the mailbox was recreated and the UIDs refer to other messages.
+	UIDValidity uint32 `json:"uid_validity,omitempty"`
+	// Initialized is set once the first-run backfill choice (config.BackfillMode) has been applied.
+	Initialized bool `json:"initialized,omitempty"`
+
+	// POP3 has no ordered UIDs: the UIDL of every message already fetched is kept instead.
+	POP3Seen        []string `json:"pop3_seen,omitempty"`
+	POP3Initialized bool     `json:"pop3_initialized,omitempty"` // first-run backfill done for POP3
+	// POP3Processed lists the UIDLs the engine has processed (see source.Acknowledger): unlike
+	// messages skipped by the first-run backfill or that failed, these may be deleted.
+	POP3Processed []string `json:"pop3_processed,omitempty"`
+
+	// JMAPState is the server's Email state string after the last fetch (empty before the first run).
+	JMAPState string `json:"jmap_state,omitempty"`
+	// JMAPPolled is when the fetch that read JMAPState started. Should the server no longer be
+	// able to calculate changes from JMAPState, mail received since is queried instead.
+	JMAPPolled time.Time `json:"jmap_polled,omitempty"`
+
+	// Failed lists the messages the position (LastUID, POP3Seen, JMAPState) has moved past but
+	// that could not be fetched or extracted. They are fetched again on later polls until they
+	// succeed or reach MaxAttempts.
+	Failed []Failed `json:"failed,omitempty"`
+}
+
+// MaxAttempts is the number of failed attempts after which a message is given up on (Failed.Permanent).
+const MaxAttempts = 5
+
+// Ref identifies a message on the server: by UID over IMAP, by ID for sources without UIDs.
+type Ref struct {
+	UID uint32 `json:"uid,omitempty"`
+	ID  string `json:"id,omitempty"` // POP3 UIDL or JMAP Email id
+}
+
+// IsZero reports whether r identifies no message (e.g. mail imported from an archive).
+func (r Ref) IsZero() bool {
+	return r == Ref{}
+}
+
+// String returns "UID 42" or "ID <id>", for lists and logs.
+func (r Ref) String() string {
+	if r.ID != "" {
+		return "ID " + r.ID
+	}
+	return fmt.Sprintf("UID %d", r.UID)
+}
+
+// Failed is a message whose fetch or extraction failed.
+type Failed struct {
+	Ref
+	Subject   string    `json:"subject,omitempty"`
+	From      string    `json:"from,omitempty"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	NextRetry time.Time `json:"next_retry,omitempty"`
+	Permanent bool      `json:"permanent,omitempty"` // MaxAttempts reached; only retried on request
+}
+
+// retryDelay returns the wait after the given number of failed attempts: 1, 4, 16, 64 minutes...
+func retryDelay(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 4
+	}
+	return d
+}
+
+// RecordFailure counts a failed attempt for the message and schedules the next retry with
+// exponential backoff. It reports whether the message has now failed permanently. The caller saves.
+func (s *State) RecordFailure(ref Ref, subject, from, errMsg string, now time.Time) bool {
+	i := s.failedIndex(ref)
+	if i < 0 {
+		s.Failed = append(s.Failed, Failed{Ref: ref})
+		i = len(s.Failed) - 1
+	}
+	f := &s.Failed[i]
+	if subject != "" || from != "" {
+		f.Subject, f.From = subject, from
+	}
+	f.Attempts++
+	f.LastError = errMsg
+	f.NextRetry = now.Add(retryDelay(f.Attempts))
+	f.Permanent = f.Attempts >= MaxAttempts
+	return f.Permanent
+}
+
+// ClearFailure forgets a message (it succeeded or was dismissed) and reports whether it was listed.
+// The caller saves.
+func (s *State) ClearFailure(ref Ref) bool {
+	i := s.failedIndex(ref)
+	if i < 0 {
+		return false
+	}
+	s.Failed = append(s.Failed[:i], s.Failed[i+1:]...)
+	return true
+}
+
+// ResetFailure makes a permanently failed message due for retry on the next poll, with a fresh
+// attempt count. The caller saves.
+func (s *State) ResetFailure(ref Ref) {
+	if i := s.failedIndex(ref); i >= 0 {
+		s.Failed[i].Attempts = 0
+		s.Failed[i].Permanent = false
+		s.Failed[i].NextRetry = time.Time{}
+	}
+}
+
+// DueRetries returns the failed messages whose next retry time has come.
+func (s *State) DueRetries(now time.Time) []Ref {
+	var refs []Ref
+	for _, f := range s.Failed {
+		if !f.Permanent && !now.Before(f.NextRetry) {
+			refs = append(refs, f.Ref)
+		}
+	}
+	return refs
+}
+
+// PermanentFailures returns the messages that reached MaxAttempts.
+func (s *State) PermanentFailures() []Failed {
+	var out []Failed
+	for _, f := range s.Failed {
+		if f.Permanent {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (s *State) failedIndex(ref Ref) int {
+	for i := range s.Failed {
+		if s.Failed[i].Ref == ref {
+			return i
+		}
+	}
+	return -1
+}
+
+// NeedsBackfill reports whether the first-run backfill has yet to run.
+// States saved before Initialized existed count as initialized once they have a LastUID.
+func (s *State) NeedsBackfill() bool {
+	return !s.Initialized && s.LastUID == 0
+}
+
+// Empty reports whether nothing has been fetched or recorded yet.
+func (s *State) Empty() bool {
+	return s.NeedsBackfill() && !s.POP3Initialized && len(s.POP3Seen) == 0 && s.JMAPState == "" && len(s.Failed) == 0
+}
+
+// MarkInitialized records the first-run backfill as done, with lastUID as the starting point, and saves.
+func MarkInitialized(path string, s *State, lastUID uint32) error {
+	s.Initialized = true
+	if lastUID > s.LastUID {
+		s.LastUID = lastUID
+	}
+	return Save(path, s)
+}
+
+// UpdatePOP3Seen replaces the fetched POP3 UIDLs (pruned to those still on the server),
+// marks the POP3 backfill as done, and saves. Processed UIDLs no longer on the server are dropped.
+func UpdatePOP3Seen(path string, s *State, uidls []string) error {
+	listed := make(map[string]bool, len(uidls))
+	for _, u := range uidls {
+		listed[u] = true
+	}
+	var processed []string
+	for _, u := range s.POP3Processed {
+		if listed[u] {
+			processed = append(processed, u)
+		}
+	}
+	s.POP3Seen = uidls
+	s.POP3Processed = processed
+	s.POP3Initialized = true
+	return Save(path, s)
+}
+
+// AddPOP3Processed records POP3 UIDLs as processed and saves.
+func AddPOP3Processed(path string, s *State, uidls []string) error {
+	if len(uidls) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(s.POP3Processed))
+	for _, u := range s.POP3Processed {
+		known[u] = true
+	}
+	for _, u := range uidls {
+		if !known[u] {
+			known[u] = true
+			s.POP3Processed = append(s.POP3Processed, u)
+		}
+	}
+	return Save(path, s)
+}
+
+// UpdateJMAPState records the JMAP Email state reached by the fetch started at polled and saves.
+func UpdateJMAPState(path string, s *State, emailState string, polled time.Time) error {
+	s.JMAPState = emailState
+	s.JMAPPolled = polled
+	return Save(path, s)
+}
+
+// file is the layout of the state file: one State per account, keyed by config.Config.StateKey.
+type file struct {
+	Accounts map[string]*State `json:"accounts"`
+}
+
+// Load reads the state of the account key from the JSON file. An account without saved state
+// (or a missing file) gets an empty State. A file written before states were kept per account
+// holds a single State; it is taken as the state of key.
+func Load(path, key string) (*State, error) {
+	f, legacy, err := readFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	s := f.Accounts[key]
+	if s == nil {
+		s = &State{}
+	}
+	s.key = key
+	if legacy {
+		// Written back right away, so the old state is not taken for another account later
+		if err := Save(path, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
-// Load reads state from the JSON file. If the file does not exist, returns LastUID 0.
-func Load(path string) (*State, error) {
+// readFile reads the state file, reporting whether it had the single-State layout (adopted for key).
+func readFile(path, key string) (f *file, legacy bool, err error) {
+	f = &file{}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &State{LastUID: 0}, nil
+			return &file{Accounts: make(map[string]*State)}, false, nil
 		}
-		return nil, err
+		return nil, false, err
 	}
-	var s State
-	if err := json.Unmarshal(data, &s); err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, false, err
 	}
-	return &s, nil
+	if f.Accounts == nil {
+		var old State
+		if err := json.Unmarshal(data, &old); err != nil {
+			return nil, false, err
+		}
+		f.Accounts = map[string]*State{key: &old}
+		legacy = true
+	}
+	return f, legacy, nil
+}
+
+// Key returns the account the state belongs to.
+func (s *State) Key() string {
+	return s.key
+}
+
+// Rekey makes s the state of another account, e.g. the same mailbox under a new server name.
+// The caller saves.
+func (s *State) Rekey(key string) {
+	s.key = key
 }
 
-// Save writes state to the JSON file. Creates the parent directory if needed (e.g. on macOS).
+// saveMu serializes Save, which reads the file before writing it back.
+var saveMu sync.Mutex
+
+// Save writes the state of its account to the JSON file, keeping those of other accounts.
+// Creates the parent directory if needed (e.g. on macOS).
 func Save(path string, s *State) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
 	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(s, "", "  ")
+	f, _, err := readFile(path, s.key)
+	if err != nil {
+		return err
+	}
+	f.Accounts[s.key] = s
+	data, err := json.MarshalIndent(f, "", "  ")
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(path, data, 0600)
 }
 
+// Clone returns a deep copy of s for the same account, e.g. for a fetch that runs while s is in use.
+func (s *State) Clone() *State {
+	c := *s
+	c.POP3Seen = append([]string(nil), s.POP3Seen...)
+	c.POP3Processed = append([]string(nil), s.POP3Processed...)
+	c.Failed = append([]Failed(nil), s.Failed...)
+	return &c
+}
+
+// TakePosition copies the fetch position (UIDs, POP3 UIDLs, JMAP state) from a Clone of s that a
+// source has advanced, keeping the failed messages of s. If the clone saw a new UIDVALIDITY, the
+// failed messages refer to the old mailbox and are dropped (see ResetUIDs). The caller saves.
+func (s *State) TakePosition(from *State) {
+	if s.UIDValidity != 0 && from.UIDValidity != s.UIDValidity {
+		s.Failed = nil
+	}
+	s.LastUID = from.LastUID
+	s.UIDValidity = from.UIDValidity
+	s.Initialized = from.Initialized
+	s.POP3Seen = append([]string(nil), from.POP3Seen...)
+	s.POP3Initialized = from.POP3Initialized
+	s.POP3Processed = append([]string(nil), from.POP3Processed...)
+	s.JMAPState = from.JMAPState
+	s.JMAPPolled = from.JMAPPolled
+}
+
+// ResetUIDs forgets the position and failed messages after the mailbox was recreated with a new
+// UIDVALIDITY, so the next poll runs the first-run backfill again. The caller saves.
+func (s *State) ResetUIDs(uidValidity uint32) {
+	s.LastUID = 0
+	s.Initialized = false
+	s.Failed = nil
+	s.UIDValidity = uidValidity
+}
+
 // UpdateLastUID updates state with the highest UID from the given set and saves.
 func UpdateLastUID(path string, s *State, uids []uint32) error {
 	var max uint32
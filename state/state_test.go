@@ -0,0 +1,328 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFailures(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	imap := Ref{UID: 42}
+	pop3 := Ref{ID: "a1b2"}
+	s := &State{}
+
+	s.RecordFailure(imap, "Task 1", "pilot@club.org", "disk full", now)
+	s.RecordFailure(pop3, "Task 2", "other@club.org", "disk full", now)
+	if due := s.DueRetries(now); len(due) != 0 {
+		t.Errorf("due right after failing: %v", due)
+	}
+	if due := s.DueRetries(now.Add(time.Minute)); !reflect.DeepEqual(due, []Ref{imap, pop3}) {
+		t.Errorf("due after a minute = %v, want both", due)
+	}
+
+	// UID and ID are separate keys: a POP3 message never clears an IMAP failure
+	if s.ClearFailure(Ref{ID: "42"}) {
+		t.Error("cleared a failure by the wrong key")
+	}
+	if !s.ClearFailure(pop3) || s.ClearFailure(pop3) {
+		t.Error("ClearFailure did not remove the POP3 failure exactly once")
+	}
+
+	// Backoff: 1, 4, 16, 64 minutes, then given up
+	at := now
+	for attempt := 2; attempt <= MaxAttempts; attempt++ {
+		at = s.Failed[0].NextRetry
+		permanent := s.RecordFailure(imap, "", "", "still full", at)
+		if permanent != (attempt == MaxAttempts) {
+			t.Fatalf("attempt %d: permanent = %v", attempt, permanent)
+		}
+	}
+	f := s.Failed[0]
+	if f.Subject != "Task 1" || f.LastError != "still full" || f.Attempts != MaxAttempts {
+		t.Errorf("failure = %+v", f)
+	}
+	if due := s.DueRetries(at.Add(24 * time.Hour)); len(due) != 0 {
+		t.Errorf("permanent failure still due: %v", due)
+	}
+	if got := s.PermanentFailures(); len(got) != 1 || got[0].Ref != imap {
+		t.Errorf("PermanentFailures = %v", got)
+	}
+
+	s.ResetFailure(imap)
+	if due := s.DueRetries(at); !reflect.DeepEqual(due, []Ref{imap}) {
+		t.Errorf("due after ResetFailure = %v", due)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 4 * time.Minute},
+		{3, 16 * time.Minute},
+		{4, 64 * time.Minute},
+		{5, 256 * time.Minute},
+		{8, 1024 * time.Minute}, // stops growing past 6 hours
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetrySchedule(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	ref := Ref{UID: 7}
+	tests := []struct {
+		attempts  int
+		wait      time.Duration // until the next retry
+		permanent bool
+	}{
+		{1, time.Minute, false},
+		{2, 4 * time.Minute, false},
+		{3, 16 * time.Minute, false},
+		{MaxAttempts - 1, 64 * time.Minute, false},
+		{MaxAttempts, 256 * time.Minute, true},
+		{MaxAttempts + 2, 1024 * time.Minute, true}, // retried on request and failed again
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d attempts", tt.attempts), func(t *testing.T) {
+			s := &State{}
+			var permanent bool
+			for i := 0; i < tt.attempts; i++ {
+				permanent = s.RecordFailure(ref, "Task", "pilot@club.org", "disk full", now)
+			}
+			f := s.Failed[0]
+			if permanent != tt.permanent || f.Permanent != tt.permanent || f.Attempts != tt.attempts {
+				t.Errorf("permanent %v, failure %+v; want permanent %v", permanent, f, tt.permanent)
+			}
+			if wait := f.NextRetry.Sub(now); wait != tt.wait {
+				t.Errorf("next retry in %v, want %v", wait, tt.wait)
+			}
+			if due := s.DueRetries(now.Add(tt.wait - time.Second)); len(due) != 0 {
+				t.Errorf("due before the wait: %v", due)
+			}
+			due := s.DueRetries(now.Add(tt.wait))
+			if gotDue := len(due) == 1; gotDue == tt.permanent {
+				t.Errorf("due after the wait = %v, want due only while not permanent", due)
+			}
+			if got := len(s.PermanentFailures()) == 1; got != tt.permanent {
+				t.Errorf("PermanentFailures = %v", s.PermanentFailures())
+			}
+		})
+	}
+}
+
+func TestFailedJSON(t *testing.T) {
+	// Failures saved before POP3 and JMAP were retried only had a UID
+	var f Failed
+	if err := json.Unmarshal([]byte(`{"uid": 7, "attempts": 2, "last_error": "x"}`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Ref != (Ref{UID: 7}) || f.Attempts != 2 {
+		t.Errorf("decoded %+v", f)
+	}
+	data, err := json.Marshal(Failed{Ref: Ref{ID: "M123"}, Attempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["uid"]; ok || fields["id"] != "M123" {
+		t.Errorf("encoded %s, want an id and no uid", data)
+	}
+}
+
+func TestRefString(t *testing.T) {
+	tests := []struct {
+		ref  Ref
+		want string
+	}{
+		{Ref{UID: 42}, "UID 42"},
+		{Ref{ID: "a1b2"}, "ID a1b2"},
+	}
+	for _, tt := range tests {
+		if got := tt.ref.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+	if !(Ref{}).IsZero() || (Ref{UID: 1}).IsZero() {
+		t.Error("IsZero is wrong")
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	// Written before states were kept per account
+	legacy := `{"last_uid": 42, "uid_validity": 7, "initialized": true, "failed": [{"uid": 40, "attempts": 1}]}`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path, "pilot@imap.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastUID != 42 || s.UIDValidity != 7 || !s.Initialized || len(s.Failed) != 1 || s.Key() != "pilot@imap.example" {
+		t.Errorf("adopted state = %+v (key %q)", s, s.Key())
+	}
+
+	// Migrated on disk, so another account does not adopt it too
+	var f file
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &f); err != nil || len(f.Accounts) != 1 || f.Accounts["pilot@imap.example"] == nil {
+		t.Fatalf("file after Load:\n%s", data)
+	}
+	other, err := Load(path, "other@pop.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !other.Empty() {
+		t.Errorf("other account got %+v, want an empty state", other)
+	}
+}
+
+func TestSavePerAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	a, err := Load(path, "a")
+	if err != nil || !a.Empty() {
+		t.Fatalf("missing file: %+v, %v", a, err)
+	}
+	b, _ := Load(path, "b")
+	if err := MarkInitialized(path, a, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateJMAPState(path, b, "s1", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, check := range map[string]func(*State) bool{
+		"a": func(s *State) bool { return s.LastUID == 10 && s.Initialized && s.JMAPState == "" },
+		"b": func(s *State) bool { return s.JMAPState == "s1" && !s.JMAPPolled.IsZero() && s.LastUID == 0 },
+	} {
+		s, err := Load(path, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !check(s) {
+			t.Errorf("account %s = %+v", key, s)
+		}
+	}
+
+	// A renamed account keeps its position under the new key; the old entry stays until removed
+	a.Rekey("a2")
+	if err := Save(path, a); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := Load(path, "a2"); s.LastUID != 10 {
+		t.Errorf("rekeyed account = %+v", s)
+	}
+}
+
+func TestNeedsBackfill(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     State
+		needs bool
+		empty bool
+	}{
+		{"new", State{}, true, true},
+		{"initialized", State{Initialized: true}, false, false},
+		{"saved before Initialized existed", State{LastUID: 5}, false, false},
+		{"POP3", State{POP3Initialized: true}, true, false},
+		{"JMAP", State{JMAPState: "s1"}, true, false},
+		{"failures only", State{Failed: []Failed{{Ref: Ref{UID: 1}}}}, true, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.NeedsBackfill(); got != tt.needs {
+			t.Errorf("%s: NeedsBackfill = %v, want %v", tt.name, got, tt.needs)
+		}
+		if got := tt.s.Empty(); got != tt.empty {
+			t.Errorf("%s: Empty = %v, want %v", tt.name, got, tt.empty)
+		}
+	}
+}
+
+func TestPOP3Processed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := &State{}
+	if err := UpdatePOP3Seen(path, s, []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPOP3Processed(path, s, []string{"b", "c", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(s.POP3Processed, want) {
+		t.Errorf("processed = %q, want %q", s.POP3Processed, want)
+	}
+	// b was deleted from the server
+	if err := UpdatePOP3Seen(path, s, []string{"a", "c", "d"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.POP3Processed, []string{"c"}) || !reflect.DeepEqual(loaded.POP3Seen, []string{"a", "c", "d"}) || !loaded.POP3Initialized {
+		t.Errorf("saved state = %+v", loaded)
+	}
+}
+
+func TestUpdateLastUID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := &State{LastUID: 5}
+	if err := UpdateLastUID(path, s, []uint32{9, 7, 12, 8}); err != nil || s.LastUID != 12 {
+		t.Errorf("LastUID = %d, %v; want 12", s.LastUID, err)
+	}
+	if err := UpdateLastUID(path, s, nil); err != nil || s.LastUID != 12 {
+		t.Errorf("LastUID after no UIDs = %d, %v; want 12", s.LastUID, err)
+	}
+	s.ResetUIDs(99)
+	if s.LastUID != 0 || s.Initialized || s.UIDValidity != 99 || !s.NeedsBackfill() {
+		t.Errorf("after ResetUIDs: %+v", s)
+	}
+}
+
+func TestTakePosition(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := &State{LastUID: 10, UIDValidity: 7, Initialized: true, POP3Seen: []string{"a"}}
+	s.Rekey("acct")
+
+	work := s.Clone()
+	if work.Key() != "acct" {
+		t.Errorf("clone key = %q", work.Key())
+	}
+	work.LastUID = 12
+	work.POP3Seen[0] = "b"
+	work.RecordFailure(Ref{UID: 11}, "", "", "no body", now)
+	// Recorded on the original while the fetch runs
+	s.RecordFailure(Ref{UID: 3}, "", "", "disk full", now)
+	if s.POP3Seen[0] != "a" {
+		t.Error("clone shares POP3Seen with the original")
+	}
+
+	s.TakePosition(work)
+	if s.LastUID != 12 || !reflect.DeepEqual(s.POP3Seen, []string{"b"}) {
+		t.Errorf("position = %d %v, want 12 [b]", s.LastUID, s.POP3Seen)
+	}
+	if len(s.Failed) != 1 || s.Failed[0].Ref != (Ref{UID: 3}) {
+		t.Errorf("failed = %v, want only UID 3", s.Failed)
+	}
+
+	// A new UIDVALIDITY makes the failed UIDs refer to another mailbox
+	work = s.Clone()
+	work.ResetUIDs(8)
+	s.TakePosition(work)
+	if s.LastUID != 0 || s.UIDValidity != 8 || len(s.Failed) != 0 {
+		t.Errorf("after UIDVALIDITY change: last %d, validity %d, failed %v", s.LastUID, s.UIDValidity, s.Failed)
+	}
+}
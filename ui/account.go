@@ -0,0 +1,100 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+	"igcmailimap/logger"
+	"igcmailimap/state"
+)
+
+// confirmAccountChange saves cfg, whose account differs from old, once the user has seen where
+// extraction starts on the new account: where it stopped if the account was polled before,
+// otherwise a chosen initial import or the old account's position. Nothing is asked on first
+// run, when neither account has a state.
+func (a *App) confirmAccountChange(old, cfg *config.Config, done func()) {
+	saved := &state.State{}
+	if path, err := config.StatePath(); err == nil {
+		if saved, err = state.Load(path, cfg.StateKey()); err != nil {
+			a.Logger.Error("Failed to load state", logger.KeyError, err)
+			saved = &state.State{}
+		}
+	}
+	hadState := a.engine.HasState()
+	if !hadState && saved.Empty() {
+		a.applySettings(cfg, false, done)
+		return
+	}
+
+	intro := fmt.Sprintf("The mail account changes from %s on %s to %s on %s.", old.IMAPUser, old.IMAPServer, cfg.IMAPUser, cfg.IMAPServer)
+	var dlg dialog.Dialog
+	var content fyne.CanvasObject
+	var apply func() bool // saves with the chosen start point, false if the choice is invalid
+	if !saved.Empty() {
+		msg := widget.NewLabel(intro + "\n\nMail was fetched from this account before, so polling continues after the " +
+			"last message fetched then. Mail that arrived meanwhile is fetched on the next poll.")
+		msg.Wrapping = fyne.TextWrapWord
+		content = msg
+		apply = func() bool {
+			a.applySettings(cfg, false, done)
+			return true
+		}
+	} else {
+		msg := widget.NewLabel(intro + "\n\nNothing has been fetched from this account yet. Extraction starts from:")
+		msg.Wrapping = fyne.TextWrapWord
+		keepLabel := fmt.Sprintf("Where %s stopped (the same mailbox under a new name)", old.IMAPUser)
+		choices := append([]string(nil), backfillLabels...)
+		if hadState {
+			choices = append(choices, keepLabel)
+		}
+		dateEntry := widget.NewEntry()
+		dateEntry.SetPlaceHolder("YYYY-MM-DD")
+		dateEntry.SetText(cfg.BackfillSince)
+		startRadio := widget.NewRadioGroup(choices, func(choice string) {
+			if backfillMode(choice) == config.BackfillSince {
+				dateEntry.Enable()
+			} else {
+				dateEntry.Disable()
+			}
+		})
+		startRadio.SetSelected(backfillLabel(cfg.BackfillMode))
+		content = container.NewVBox(msg, startRadio, container.NewGridWithColumns(2, widget.NewLabel("Since date"), dateEntry))
+		apply = func() bool {
+			if startRadio.Selected == keepLabel {
+				a.applySettings(cfg, true, done)
+				return true
+			}
+			cfg.BackfillMode = backfillMode(startRadio.Selected)
+			cfg.BackfillSince = strings.TrimSpace(dateEntry.Text)
+			if cfg.BackfillMode == config.BackfillSince {
+				if _, err := cfg.BackfillSinceDate(); err != nil {
+					dialog.ShowError(errors.New("the date must be YYYY-MM-DD"), a.Win)
+					return false
+				}
+			}
+			// Show the choice in the form, so it is not taken for an unsaved change
+			a.backfillSelect.SetSelected(backfillLabel(cfg.BackfillMode))
+			a.backfillDateEntry.SetText(cfg.BackfillSince)
+			a.applySettings(cfg, false, done)
+			return true
+		}
+	}
+
+	saveBtn := widget.NewButton("Save", func() {
+		if apply() {
+			dlg.Hide()
+		}
+	})
+	saveBtn.Importance = widget.HighImportance
+	buttons := container.NewHBox(widget.NewButton("Cancel", func() { dlg.Hide() }), saveBtn)
+	dlg = dialog.NewCustomWithoutButtons("Account changed", container.NewBorder(nil, buttons, nil, nil, content), a.Win)
+	dlg.Resize(fyne.NewSize(540, 320))
+	a.Win.Show()
+	dlg.Show()
+}
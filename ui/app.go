@@ -1,10 +1,14 @@
 package ui
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,8 +20,10 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"igcmailimap/config"
+	"igcmailimap/convert"
+	"igcmailimap/engine"
 	"igcmailimap/extract"
-	"igcmailimap/imap"
+	"igcmailimap/history"
 	"igcmailimap/logger"
 	"igcmailimap/startup"
 	"igcmailimap/state"
@@ -41,6 +47,9 @@ type App struct {
 
 	// Form fields (kept for read/write)
 	serverEntry        *widget.Entry
+	protocolSelect     *widget.Select
+	securitySelect     *widget.Select
+	pop3DeleteCheck    *widget.Check
 	userEntry          *widget.Entry
 	passEntry          *widget.Entry
 	outputEntry        *widget.Entry
@@ -48,27 +57,65 @@ type App struct {
 	intervalEntry      *widget.Entry
 	startupCheck       *widget.Check
 	loggingCheck       *widget.Check
+	logLevelSelect     *widget.Select
+	logFormatSelect    *widget.Select
+	logSizeEntry       *widget.Entry
+	logAgeEntry        *widget.Entry
+	logKeepEntry       *widget.Entry
+	logConfigDirCheck  *widget.Check
 	notificationsCheck *widget.Check
+	convertGroup       *widget.CheckGroup
+	acceptGroup        *widget.CheckGroup
+	toIGCCheck         *widget.Check
+	backfillSelect     *widget.Select
+	backfillDateEntry  *widget.Entry
 	startBtn           *widget.Button
 	stopBtn            *widget.Button
+	fetchBtn           *widget.Button
+	failuresBtn        *widget.Button // shown while messages have been given up on
 
 	// Original values for change tracking
-	originalServer      string
-	originalUser        string
-	originalPassword    string
-	originalOutput      string
-	originalInterval    int
-	originalStartup     bool
-	originalLogging     bool
+	originalServer        string
+	originalProtocol      string
+	originalSecurity      string
+	originalPOP3Delete    bool
+	originalUser          string
+	originalPassword      string
+	originalOutput        string
+	originalInterval      int
+	originalStartup       bool
+	originalLogging       bool
+	originalLogLevel      string
+	originalLogFormat     string
+	originalLogSize       int
+	originalLogAge        int
+	originalLogKeep       int
+	originalLogConfigDir  bool
 	originalNotifications bool
-	saveBtn             *widget.Button
+	originalConvert       []string
+	originalAccept        []string
+	originalToIGC         bool
+	originalBackfillMode  string
+	originalBackfillSince string
+	saveBtn               *widget.Button
 
 	// Tray menu items (for dynamic updates)
 	startPollItem *fyne.MenuItem
 	stopPollItem  *fyne.MenuItem
+	fetchNowItem  *fyne.MenuItem
+	status        engine.Status // last poll status from the engine, shown in the tray menu
+	trayVariant   string        // tray icon variant currently shown (trayIdle, ...)
 
-	// pollStop is non-nil while the poll loop is running; close it to stop.
-	pollStop    chan struct{}
+	logWin fyne.Window // open activity log window, if any
+
+	recent     []recentFlight // last extracted files, newest first
+	recentList *widget.List
+
+	// engine runs the poll loop (fetch, filter, extract) independently of the UI.
+	engine       *engine.Engine
+	history      *history.DB     // nil if the history database could not be opened
+	ctx          context.Context // cancelled on shutdown, stopping manual imports and reprocessing
+	cancel       context.CancelFunc
 	shuttingDown bool
 	mu           sync.Mutex
 }
@@ -82,13 +129,13 @@ func New() (*App, error) {
 	}
 	statePath, _ := config.StatePath()
 	cfgPath, _ := config.ConfigPath()
-	st, err := state.Load(statePath)
+	st, err := state.Load(statePath, cfg.StateKey())
 	if err != nil {
 		return nil, err
 	}
 
 	// Initialize logger
-	log, err := logger.New(cfg.OutputFolder, cfg.LoggingEnabled)
+	log, err := logger.New(cfg.LogFolder(), cfg.LoggingEnabled, cfg.LogOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -101,10 +148,20 @@ func New() (*App, error) {
 		statePath: statePath,
 		cfgPath:   cfgPath,
 	}
+	ap.ctx, ap.cancel = context.WithCancel(context.Background())
+	ap.engine = engine.New(cfg, st, log, engine.Hooks{Info: ap.notifyInfo, Error: ap.notifyError, Failures: ap.updateFailures, Status: ap.updateStatus})
+	if histPath, err := config.HistoryPath(); err == nil {
+		if ap.history, err = history.Open(histPath); err != nil {
+			// Keep running without dedupe and history; the history window explains why
+			log.Warning("Message history unavailable", logger.KeyError, err)
+		} else {
+			ap.engine.SetHistory(ap.history)
+		}
+	}
 
 	ap.Win = a.NewWindow("IGCmail IMAP")
 	ap.buildConfigForm()
-	ap.Win.Resize(fyne.NewSize(480, 380))
+	ap.Win.Resize(fyne.NewSize(900, 640))
 	ap.Win.CenterOnScreen()
 
 	// Set application and window icons
@@ -131,7 +188,7 @@ func New() (*App, error) {
 			time.Sleep(100 * time.Millisecond)
 			// If we're not shutting down and the app is still running, show notification
 			ap.mu.Lock()
-			stillRunning := ap.pollStop != nil || !ap.shuttingDown
+			stillRunning := ap.engine.Running() || !ap.shuttingDown
 			ap.mu.Unlock()
 			if stillRunning {
 				ap.notifyInfo("IGCmail IMAP is running in the background. Use the system tray icon to access it.")
@@ -140,20 +197,11 @@ func New() (*App, error) {
 	})
 
 	// System tray
-	if desk, ok := a.(desktop.App); ok {
+	if _, ok := a.(desktop.App); ok {
 		ap.startPollItem = fyne.NewMenuItem("Start polling", func() { ap.StartPolling() })
 		ap.stopPollItem = fyne.NewMenuItem("Stop polling", func() { ap.StopPolling() })
-
-		m := fyne.NewMenu("IGCmail IMAP",
-			fyne.NewMenuItem("Show", func() { ap.Win.Show() }),
-			fyne.NewMenuItemSeparator(),
-			ap.startPollItem,
-			ap.stopPollItem,
-			fyne.NewMenuItemSeparator(),
-			fyne.NewMenuItem("Quit", func() { ap.quit() }),
-		)
-		desk.SetSystemTrayMenu(m)
-		ap.updateTrayMenu()
+		ap.fetchNowItem = fyne.NewMenuItem("Fetch now", func() { ap.fetchNow() })
+		ap.updateStatus(ap.engine.Status())
 	}
 
 	return ap, nil
@@ -168,6 +216,19 @@ func (a *App) buildConfigForm() {
 		a.updatePollButtons()
 	}
 
+	a.pop3DeleteCheck = widget.NewCheck("Delete messages from the POP3 server once processed", func(bool) { a.updateSaveButtonState() })
+	a.pop3DeleteCheck.SetChecked(a.Config.POP3Delete)
+	a.protocolSelect = widget.NewSelect([]string{"IMAP", "POP3", "JMAP"}, nil)
+	a.protocolSelect.SetSelected(strings.ToUpper(protocol(a.Config.Protocol)))
+	a.securitySelect = widget.NewSelect([]string{"TLS", "STARTTLS"}, nil)
+	a.securitySelect.SetSelected(strings.ToUpper(security(a.Config.Security)))
+	a.updatePOP3Options()
+	a.protocolSelect.OnChanged = func(string) {
+		a.updatePOP3Options()
+		a.updateSaveButtonState()
+	}
+	a.securitySelect.OnChanged = func(string) { a.updateSaveButtonState() }
+
 	a.userEntry = widget.NewEntry()
 	a.userEntry.SetPlaceHolder("user@example.com")
 	a.userEntry.SetText(a.Config.IMAPUser)
@@ -215,11 +276,45 @@ func (a *App) buildConfigForm() {
 
 	a.loggingCheck = widget.NewCheck("Enable logging", nil)
 	a.loggingCheck.SetChecked(a.Config.LoggingEnabled)
+	a.logLevelSelect = widget.NewSelect(logger.Levels, func(string) { a.updateSaveButtonState() })
+	a.logLevelSelect.SetSelected(logLevel(a.Config.LogLevel))
+	a.logFormatSelect = widget.NewSelect([]string{logger.FormatText, logger.FormatJSON}, func(string) { a.updateSaveButtonState() })
+	a.logFormatSelect.SetSelected(logFormat(a.Config.LogFormat))
+	a.logSizeEntry = newNumberEntry("MB (10)", a.Config.LogMaxSizeMB, a.updateSaveButtonState)
+	a.logAgeEntry = newNumberEntry("days (no limit)", a.Config.LogMaxAgeDays, a.updateSaveButtonState)
+	a.logKeepEntry = newNumberEntry("archives (5)", a.Config.LogKeep, a.updateSaveButtonState)
+	a.logConfigDirCheck = widget.NewCheck("Keep logs in the settings folder instead of the output folder", func(bool) { a.updateSaveButtonState() })
+	a.logConfigDirCheck.SetChecked(a.Config.LogInConfigDir)
 
 	a.notificationsCheck = widget.NewCheck("Enable notifications", nil)
 	a.notificationsCheck.SetChecked(a.Config.NotificationsEnabled)
 
-	a.saveBtn = widget.NewButton("Save", func() { a.save() })
+	var convertLabels []string
+	for _, f := range convert.Formats {
+		convertLabels = append(convertLabels, formatLabel(f))
+	}
+	a.convertGroup = widget.NewCheckGroup(convertLabels, nil)
+	a.convertGroup.Horizontal = true
+	a.convertGroup.SetSelected(formatLabels(a.Config.ConvertFormats))
+
+	a.acceptGroup = widget.NewCheckGroup(extract.Formats(), nil)
+	a.acceptGroup.Horizontal = true
+	a.acceptGroup.SetSelected(acceptedFormats(a.Config.AcceptedFormats))
+
+	a.toIGCCheck = widget.NewCheck("Convert other track formats to IGC", nil)
+	a.toIGCCheck.SetChecked(a.Config.ConvertToIGC)
+
+	a.backfillDateEntry = widget.NewEntry()
+	a.backfillDateEntry.SetPlaceHolder("YYYY-MM-DD")
+	a.backfillDateEntry.SetText(a.Config.BackfillSince)
+	a.backfillSelect = widget.NewSelect(backfillLabels, nil)
+	a.backfillSelect.SetSelected(backfillLabel(a.Config.BackfillMode))
+	a.updateBackfillDate()
+
+	var testBtn *widget.Button
+	testBtn = widget.NewButton("Test connection", func() { a.testConnection(testBtn) })
+
+	a.saveBtn = widget.NewButton("Save", func() { a.save(nil) })
 	// Preserve existing startup check functionality
 	originalStartupOnChanged := a.startupCheck.OnChanged
 	a.startupCheck.OnChanged = func(checked bool) {
@@ -232,12 +327,31 @@ func (a *App) buildConfigForm() {
 
 	a.loggingCheck.OnChanged = func(bool) { a.updateSaveButtonState() }
 	a.notificationsCheck.OnChanged = func(bool) { a.updateSaveButtonState() }
+	a.convertGroup.OnChanged = func([]string) { a.updateSaveButtonState() }
+	a.acceptGroup.OnChanged = func([]string) { a.updateSaveButtonState() }
+	a.toIGCCheck.OnChanged = func(bool) { a.updateSaveButtonState() }
+	a.backfillSelect.OnChanged = func(string) {
+		a.updateBackfillDate()
+		a.updateSaveButtonState()
+	}
+	a.backfillDateEntry.OnChanged = func(string) { a.updateSaveButtonState() }
 
 	a.startBtn = widget.NewButton("Start polling", func() { a.StartPolling() })
 	a.stopBtn = widget.NewButton("Stop polling", func() { a.StopPolling() })
+	a.fetchBtn = widget.NewButton("Fetch now", func() { a.fetchNow() })
 	a.updatePollButtons()
 
 	quitBtn := widget.NewButton("Quit", func() { a.quit() })
+	filtersBtn := widget.NewButton("Filters...", func() { a.showFiltersWindow() })
+	pilotsBtn := widget.NewButton("Pilots...", func() { a.showPilotsWindow() })
+	reimportBtn := widget.NewButton("Re-import range...", func() { a.showReimportDialog() })
+	importBtn := widget.NewButton("Import mail archive...", func() { a.showImportArchiveDialog() })
+	logBtn := widget.NewButton("Activity log...", func() { a.showLogWindow() })
+	historyBtn := widget.NewButton("History...", func() { a.showHistoryWindow() })
+	setupBtn := widget.NewButton("Setup wizard...", func() { a.showSetupWizard() })
+	a.failuresBtn = widget.NewButtonWithIcon("", theme.WarningIcon(), func() { a.showFailuresWindow() })
+	a.failuresBtn.Importance = widget.WarningImportance
+	a.updateFailures(a.State.PermanentFailures())
 	minimizeBtn := widget.NewButton("Minimize to tray", func() {
 		a.Win.Hide()
 		if !a.shuttingDown {
@@ -250,21 +364,143 @@ func (a *App) buildConfigForm() {
 	a.updateSaveButtonState()
 
 	form := widget.NewForm(
-		widget.NewFormItem("IMAP server", a.serverEntry),
+		widget.NewFormItem("", a.failuresBtn),
+		widget.NewFormItem("Mail server", a.serverEntry),
+		widget.NewFormItem("Protocol", container.NewGridWithColumns(2, a.protocolSelect, a.securitySelect)),
+		widget.NewFormItem("", a.pop3DeleteCheck),
 		widget.NewFormItem("User", a.userEntry),
 		widget.NewFormItem("Password", a.passEntry),
+		widget.NewFormItem("", testBtn),
 		widget.NewFormItem("Output folder", container.NewBorder(nil, nil, nil, a.outputBrowseBtn, a.outputEntry)),
 		widget.NewFormItem("Interval (seconds)", a.intervalEntry),
 		widget.NewFormItem("", a.startupCheck),
 		widget.NewFormItem("", a.loggingCheck),
+		widget.NewFormItem("Log level / format", container.NewGridWithColumns(2, a.logLevelSelect, a.logFormatSelect)),
+		widget.NewFormItem("Rotate log at", container.NewGridWithColumns(3, a.logSizeEntry, a.logAgeEntry, a.logKeepEntry)),
+		widget.NewFormItem("", a.logConfigDirCheck),
 		widget.NewFormItem("", a.notificationsCheck),
+		widget.NewFormItem("Accepted files", a.acceptGroup),
+		widget.NewFormItem("", a.toIGCCheck),
+		widget.NewFormItem("Also save as", a.convertGroup),
+		widget.NewFormItem("Initial import", container.NewGridWithColumns(2, a.backfillSelect, a.backfillDateEntry)),
+		widget.NewFormItem("", container.NewGridWithColumns(2, filtersBtn, pilotsBtn, reimportBtn, importBtn, logBtn, historyBtn, setupBtn)),
 		widget.NewFormItem("", a.startBtn),
 		widget.NewFormItem("", a.stopBtn),
+		widget.NewFormItem("", a.fetchBtn),
 		widget.NewFormItem("", a.saveBtn),
 		widget.NewFormItem("", minimizeBtn),
 		widget.NewFormItem("", quitBtn),
 	)
-	a.Win.SetContent(form)
+	a.loadRecentFlights()
+	split := container.NewHSplit(container.NewVScroll(form), a.buildRecentPane())
+	split.Offset = 0.6
+	a.Win.SetContent(split)
+}
+
+// backfillLabels are the "Initial import" choices, in the order of backfillModes.
+var (
+	backfillLabels = []string{"From now (new mail only)", "Since date", "Everything in the mailbox"}
+	backfillModes  = []string{config.BackfillNow, config.BackfillSince, config.BackfillAll}
+)
+
+func backfillLabel(mode string) string {
+	for i, m := range backfillModes {
+		if m == mode {
+			return backfillLabels[i]
+		}
+	}
+	return backfillLabels[len(backfillLabels)-1] // unset means everything (previous behaviour)
+}
+
+func backfillMode(label string) string {
+	for i, l := range backfillLabels {
+		if l == label {
+			return backfillModes[i]
+		}
+	}
+	return config.BackfillAll
+}
+
+// updateBackfillDate enables the date entry only for the "Since date" choice.
+func (a *App) updateBackfillDate() {
+	if backfillMode(a.backfillSelect.Selected) == config.BackfillSince {
+		a.backfillDateEntry.Enable()
+	} else {
+		a.backfillDateEntry.Disable()
+	}
+}
+
+// protocol returns the config protocol, defaulting to IMAP.
+func protocol(p string) string {
+	if p == config.ProtocolPOP3 || p == config.ProtocolJMAP {
+		return p
+	}
+	return config.ProtocolIMAP
+}
+
+// security returns the config security mode, defaulting to implicit TLS.
+func security(s string) string {
+	if s == config.SecurityStartTLS {
+		return s
+	}
+	return config.SecurityTLS
+}
+
+// updatePOP3Options enables the delete-from-server option only for POP3, and the TLS choice
+// only for IMAP and POP3 (JMAP uses the scheme of its session URL).
+func (a *App) updatePOP3Options() {
+	p := strings.ToLower(a.protocolSelect.Selected)
+	if p == config.ProtocolPOP3 {
+		a.pop3DeleteCheck.Enable()
+	} else {
+		a.pop3DeleteCheck.Disable()
+	}
+	if p == config.ProtocolJMAP {
+		a.securitySelect.Disable()
+		a.serverEntry.SetPlaceHolder("api.fastmail.com or https://host/.well-known/jmap")
+	} else {
+		a.securitySelect.Enable()
+		a.serverEntry.SetPlaceHolder("imap.example.com:993")
+	}
+}
+
+// logLevel returns the configured level name, defaulting to info.
+func logLevel(level string) string {
+	for _, l := range logger.Levels {
+		if l == level {
+			return l
+		}
+	}
+	return "info"
+}
+
+// logFormat returns the configured log format, defaulting to text.
+func logFormat(format string) string {
+	if format == logger.FormatJSON {
+		return format
+	}
+	return logger.FormatText
+}
+
+// newNumberEntry returns an entry for an optional positive number (0 shows as empty, so the
+// placeholder can show the default).
+func newNumberEntry(placeholder string, value int, changed func()) *widget.Entry {
+	e := widget.NewEntry()
+	e.SetPlaceHolder(placeholder)
+	if value > 0 {
+		e.SetText(strconv.Itoa(value))
+	}
+	e.OnChanged = func(string) { changed() }
+	return e
+}
+
+// parseOptionalInt parses a positive number; anything else means 0 (use the default).
+func parseOptionalInt(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
 }
 
 func parseInt(s string) int {
@@ -275,6 +511,61 @@ func parseInt(s string) int {
 	return n
 }
 
+// formatLabel returns the UI label for a convert format ("gpx" -> "GPX").
+func formatLabel(format string) string {
+	if format == convert.GeoJSON {
+		return "GeoJSON"
+	}
+	return strings.ToUpper(format)
+}
+
+func formatLabels(formats []string) []string {
+	labels := make([]string, len(formats))
+	for i, f := range formats {
+		labels[i] = formatLabel(f)
+	}
+	return labels
+}
+
+// selectedFormats returns the convert formats ticked in the form, in convert.Formats order.
+func (a *App) selectedFormats() []string {
+	var out []string
+	for _, f := range convert.Formats {
+		for _, label := range a.convertGroup.Selected {
+			if label == formatLabel(f) {
+				out = append(out, f)
+			}
+		}
+	}
+	return out
+}
+
+// acceptedFormats returns the configured attachment formats, or extract.DefaultFormats when unset.
+func acceptedFormats(formats []string) []string {
+	if len(formats) == 0 {
+		return extract.DefaultFormats
+	}
+	return formats
+}
+
+// sameStrings reports whether x and y hold the same strings, ignoring order
+// (CheckGroup.Selected is in click order).
+func sameStrings(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	xs := append([]string(nil), x...)
+	ys := append([]string(nil), y...)
+	sort.Strings(xs)
+	sort.Strings(ys)
+	for i := range xs {
+		if xs[i] != ys[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func startupCheckLabel() string {
 	switch runtime.GOOS {
 	case "darwin":
@@ -286,42 +577,142 @@ func startupCheckLabel() string {
 	}
 }
 
-func (a *App) save() {
+// formConfig returns a copy of the config with the values entered in the form.
+func (a *App) formConfig() (*config.Config, error) {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.Config.IMAPServer = a.serverEntry.Text
-	a.Config.IMAPUser = a.userEntry.Text
-	a.Config.IMAPPassword = a.passEntry.Text
-	a.Config.OutputFolder = a.outputEntry.Text
-	a.Config.IntervalSec = parseInt(a.intervalEntry.Text)
-	if a.Config.IntervalSec <= 0 {
-		a.Config.IntervalSec = 60
+	cfg := *a.Config
+	a.mu.Unlock()
+	cfg.IMAPServer = a.serverEntry.Text
+	cfg.Protocol = protocol(strings.ToLower(a.protocolSelect.Selected))
+	cfg.Security = security(strings.ToLower(a.securitySelect.Selected))
+	cfg.POP3Delete = a.pop3DeleteCheck.Checked
+	cfg.IMAPUser = a.userEntry.Text
+	cfg.IMAPPassword = a.passEntry.Text
+	cfg.OutputFolder = a.outputEntry.Text
+	cfg.IntervalSec = parseInt(a.intervalEntry.Text)
+	cfg.RunAtStartup = a.startupCheck.Checked
+	cfg.LoggingEnabled = a.loggingCheck.Checked
+	cfg.LogLevel = a.logLevelSelect.Selected
+	cfg.LogFormat = a.logFormatSelect.Selected
+	cfg.LogMaxSizeMB = parseOptionalInt(a.logSizeEntry.Text)
+	cfg.LogMaxAgeDays = parseOptionalInt(a.logAgeEntry.Text)
+	cfg.LogKeep = parseOptionalInt(a.logKeepEntry.Text)
+	cfg.LogInConfigDir = a.logConfigDirCheck.Checked
+	cfg.NotificationsEnabled = a.notificationsCheck.Checked
+	cfg.ConvertFormats = a.selectedFormats()
+	cfg.AcceptedFormats = append([]string(nil), a.acceptGroup.Selected...)
+	cfg.ConvertToIGC = a.toIGCCheck.Checked
+	cfg.BackfillMode = backfillMode(a.backfillSelect.Selected)
+	cfg.BackfillSince = strings.TrimSpace(a.backfillDateEntry.Text)
+	if cfg.BackfillMode == config.BackfillSince {
+		if _, err := cfg.BackfillSinceDate(); err != nil {
+			return nil, errors.New("Initial import date must be YYYY-MM-DD")
+		}
 	}
-	a.Config.RunAtStartup = a.startupCheck.Checked
-	a.Config.LoggingEnabled = a.loggingCheck.Checked
-	a.Config.NotificationsEnabled = a.notificationsCheck.Checked
+	return &cfg, nil
+}
 
-	// Update logger with new settings
-	if a.Logger != nil {
-		a.Logger.Close()
-	}
-	newLogger, err := logger.New(a.Config.OutputFolder, a.Config.LoggingEnabled)
+// save saves the settings in the form and applies them to the running poller. A new account
+// is confirmed first, with where extraction starts (see confirmAccountChange). done, if not
+// nil, runs once the settings are saved.
+func (a *App) save(done func()) {
+	cfg, err := a.formConfig()
 	if err != nil {
-		a.notifyError("Failed to initialize logger: " + err.Error())
+		a.notifyError(err.Error())
+		return
+	}
+	a.mu.Lock()
+	old := *a.Config
+	a.mu.Unlock()
+	if !config.Diff(&old, cfg).Account {
+		a.applySettings(cfg, false, done)
 		return
 	}
-	a.Logger = newLogger
+	a.confirmAccountChange(&old, cfg, done)
+}
+
+// applySettings makes cfg the config: it recreates the logger if its settings changed, saves
+// the file and hands the config to the engine, which reschedules or switches account at once
+// (keepState: see engine.ApplyConfig). On error the old config and logger stay in use.
+func (a *App) applySettings(cfg *config.Config, keepState bool, done func()) {
+	a.mu.Lock()
+	old := *a.Config
+	a.mu.Unlock()
 
-	if err := config.Save(a.Config); err != nil {
+	var newLogger *logger.Logger
+	if config.Diff(&old, cfg).Logging {
+		var err error
+		newLogger, err = logger.New(cfg.LogFolder(), cfg.LoggingEnabled, cfg.LogOptions())
+		if err != nil {
+			a.notifyError("Failed to initialize logger: " + err.Error())
+			return
+		}
+	}
+	if err := config.Save(cfg); err != nil {
+		if newLogger != nil {
+			newLogger.Close()
+		}
 		a.notifyError("Save failed: " + err.Error())
 		return
 	}
-	_ = startup.SetEnabled(a.Config.RunAtStartup)
+
+	a.mu.Lock()
+	*a.Config = *cfg
+	a.mu.Unlock()
+	if newLogger != nil {
+		oldLogger := a.Logger
+		a.Logger = newLogger
+		a.engine.SetLogger(newLogger)
+		if oldLogger != nil {
+			oldLogger.Close()
+		}
+	}
+	change := a.engine.ApplyConfig(cfg, keepState)
+	_ = startup.SetEnabled(cfg.RunAtStartup)
 	a.notifyInfo("Settings saved")
 
 	// Reset original values and disable save button
 	a.storeOriginalValues()
 	a.updateSaveButtonState()
+	a.updatePollButtons()
+	if change.Output {
+		a.offerMoveOutput(old.OutputFolder, cfg.OutputFolder)
+	}
+	if done != nil {
+		done()
+	}
+}
+
+// offerMoveOutput offers to move the files extracted so far into the new output folder.
+func (a *App) offerMoveOutput(oldDir, newDir string) {
+	files, err := a.engine.OutputFiles(oldDir)
+	if err != nil {
+		a.Logger.Error("Failed to read message history", logger.KeyError, err)
+		return
+	}
+	if len(files) == 0 || newDir == "" {
+		return
+	}
+	dialog.ShowConfirm("Output folder changed",
+		fmt.Sprintf("%d file(s) extracted so far are in %s.\n\nMove them to %s? Their history entries follow them, "+
+			"so the recent flights still find them. Either way, the messages are not extracted again.",
+			len(files), oldDir, newDir),
+		func(move bool) {
+			if !move {
+				return
+			}
+			go func() {
+				n, err := a.engine.MoveOutput(a.ctx, oldDir, newDir)
+				if err != nil {
+					a.Logger.Error("Failed to move extracted files", logger.KeyError, err)
+					a.notifyError("Move files: " + err.Error())
+				} else {
+					a.notifyInfo(fmt.Sprintf("Moved %d file(s) to %s", n, newDir))
+				}
+				a.loadRecentFlights()
+				a.updateTrayMenu()
+			}()
+		}, a.Win)
 }
 
 // loadAppIcon loads the embedded application icon
@@ -345,24 +736,52 @@ func loadAppIcon() (fyne.Resource, error) {
 
 func (a *App) storeOriginalValues() {
 	a.originalServer = a.Config.IMAPServer
+	a.originalProtocol = protocol(a.Config.Protocol)
+	a.originalSecurity = security(a.Config.Security)
+	a.originalPOP3Delete = a.Config.POP3Delete
 	a.originalUser = a.Config.IMAPUser
 	a.originalPassword = a.Config.IMAPPassword
 	a.originalOutput = a.Config.OutputFolder
 	a.originalInterval = a.Config.IntervalSec
 	a.originalStartup = a.Config.RunAtStartup
 	a.originalLogging = a.Config.LoggingEnabled
+	a.originalLogLevel = logLevel(a.Config.LogLevel)
+	a.originalLogFormat = logFormat(a.Config.LogFormat)
+	a.originalLogSize = a.Config.LogMaxSizeMB
+	a.originalLogAge = a.Config.LogMaxAgeDays
+	a.originalLogKeep = a.Config.LogKeep
+	a.originalLogConfigDir = a.Config.LogInConfigDir
 	a.originalNotifications = a.Config.NotificationsEnabled
+	a.originalConvert = append([]string(nil), a.Config.ConvertFormats...)
+	a.originalAccept = append([]string(nil), acceptedFormats(a.Config.AcceptedFormats)...)
+	a.originalToIGC = a.Config.ConvertToIGC
+	a.originalBackfillMode = backfillMode(backfillLabel(a.Config.BackfillMode))
+	a.originalBackfillSince = a.Config.BackfillSince
 }
 
 func (a *App) hasUnsavedChanges() bool {
 	return a.serverEntry.Text != a.originalServer ||
+		protocol(strings.ToLower(a.protocolSelect.Selected)) != a.originalProtocol ||
+		security(strings.ToLower(a.securitySelect.Selected)) != a.originalSecurity ||
+		a.pop3DeleteCheck.Checked != a.originalPOP3Delete ||
 		a.userEntry.Text != a.originalUser ||
 		a.passEntry.Text != a.originalPassword ||
 		a.outputEntry.Text != a.originalOutput ||
 		parseInt(a.intervalEntry.Text) != a.originalInterval ||
 		a.startupCheck.Checked != a.originalStartup ||
 		a.loggingCheck.Checked != a.originalLogging ||
-		a.notificationsCheck.Checked != a.originalNotifications
+		a.logLevelSelect.Selected != a.originalLogLevel ||
+		a.logFormatSelect.Selected != a.originalLogFormat ||
+		parseOptionalInt(a.logSizeEntry.Text) != a.originalLogSize ||
+		parseOptionalInt(a.logAgeEntry.Text) != a.originalLogAge ||
+		parseOptionalInt(a.logKeepEntry.Text) != a.originalLogKeep ||
+		a.logConfigDirCheck.Checked != a.originalLogConfigDir ||
+		a.notificationsCheck.Checked != a.originalNotifications ||
+		!sameStrings(a.selectedFormats(), a.originalConvert) ||
+		!sameStrings(a.acceptGroup.Selected, a.originalAccept) ||
+		a.toIGCCheck.Checked != a.originalToIGC ||
+		backfillMode(a.backfillSelect.Selected) != a.originalBackfillMode ||
+		strings.TrimSpace(a.backfillDateEntry.Text) != a.originalBackfillSince
 }
 
 func (a *App) updateSaveButtonState() {
@@ -388,6 +807,9 @@ func (a *App) notifyInfo(msg string) {
 	}
 }
 
+// shutdownTimeout bounds how long cleanup waits for a cancelled fetch to close its connection.
+const shutdownTimeout = 5 * time.Second
+
 // cleanup handles the shutdown logging and cleanup operations
 func (a *App) cleanup() {
 	a.mu.Lock()
@@ -400,18 +822,24 @@ func (a *App) cleanup() {
 
 	a.Logger.Info("IGCmail IMAP application shutting down")
 
-	a.mu.Lock()
-	pollingWasRunning := a.pollStop != nil
-	if a.pollStop != nil {
-		close(a.pollStop)
-		a.pollStop = nil
+	pollingWasRunning := a.engine.Running()
+	a.cancel()
+	select {
+	case <-a.engine.Stop():
+	case <-time.After(shutdownTimeout):
+		a.Logger.Warning("In-flight fetch did not stop in time")
 	}
-	a.mu.Unlock()
 
 	if pollingWasRunning {
 		a.Logger.Info("IMAP polling stopped during application shutdown")
 	}
 
+	// Stop recording before closing the history database
+	a.engine.SetHistory(nil)
+	if a.history != nil {
+		a.history.Close()
+	}
+
 	// Close logger
 	if a.Logger != nil {
 		a.Logger.Close()
@@ -423,16 +851,16 @@ func (a *App) quit() {
 	a.Fyne.Quit()
 }
 
-// hasValidConfiguration checks if all required fields are filled
+// hasValidConfiguration reports whether server, user and password are filled
+// (JMAP may use an API token without a user).
 func (a *App) hasValidConfiguration() bool {
-	return a.serverEntry.Text != "" && a.userEntry.Text != "" && a.passEntry.Text != ""
+	userOK := a.userEntry.Text != "" || strings.ToLower(a.protocolSelect.Selected) == config.ProtocolJMAP
+	return a.serverEntry.Text != "" && userOK && a.passEntry.Text != ""
 }
 
 // updatePollButtons enables/disables Start and Stop based on polling state and configuration validity.
 func (a *App) updatePollButtons() {
-	a.mu.Lock()
-	running := a.pollStop != nil
-	a.mu.Unlock()
+	running := a.engine.Running()
 	if a.startBtn != nil {
 		if running {
 			a.startBtn.Disable()
@@ -446,6 +874,11 @@ func (a *App) updatePollButtons() {
 			}
 			a.stopBtn.Disable()
 		}
+		if a.hasValidConfiguration() {
+			a.fetchBtn.Enable()
+		} else {
+			a.fetchBtn.Disable()
+		}
 	}
 	a.updateTrayMenu()
 }
@@ -455,10 +888,9 @@ func (a *App) updateTrayMenu() {
 	if a.startPollItem == nil || a.stopPollItem == nil {
 		return
 	}
+	a.fetchNowItem.Disabled = !a.hasValidConfiguration()
 
-	a.mu.Lock()
-	running := a.pollStop != nil
-	a.mu.Unlock()
+	running := a.engine.Running()
 
 	if running {
 		a.startPollItem.Disabled = true
@@ -471,51 +903,48 @@ func (a *App) updateTrayMenu() {
 
 	// Refresh the tray menu by re-setting it
 	if desk, ok := a.Fyne.(desktop.App); ok {
-		m := fyne.NewMenu("IGCmail IMAP",
+		a.mu.Lock()
+		items := statusLines(a.status)
+		a.mu.Unlock()
+		items = append(items,
+			fyne.NewMenuItemSeparator(),
+			a.recentTrayItem(),
+			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Show", func() { a.Win.Show() }),
+			fyne.NewMenuItem("Activity log", func() { a.showLogWindow() }),
 			fyne.NewMenuItemSeparator(),
 			a.startPollItem,
 			a.stopPollItem,
+			a.fetchNowItem,
 			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Quit", func() { a.quit() }),
 		)
-		desk.SetSystemTrayMenu(m)
+		desk.SetSystemTrayMenu(fyne.NewMenu("IGCmail IMAP", items...))
 	}
 }
 
 // StartPolling starts the poll loop and saves PollingEnabled = true.
 func (a *App) StartPolling() {
-	a.mu.Lock()
-	if a.pollStop != nil {
-		a.mu.Unlock()
+	if !a.engine.Start() {
 		return
 	}
-	a.pollStop = make(chan struct{})
-	a.mu.Unlock()
 	a.Config.PollingEnabled = true
 	_ = config.Save(a.Config)
 	a.updatePollButtons()
 
 	// Log that polling has started
-	a.Logger.Info(fmt.Sprintf("IMAP polling started for %s from %s with %d second intervals", a.Config.IMAPUser, a.Config.IMAPServer, a.Config.IntervalSec))
+	a.Logger.Info("IMAP polling started", logger.KeyAccount, a.Config.IMAPUser, "server", a.Config.IMAPServer, "interval_seconds", a.Config.IntervalSec)
 
 	// Notify user that polling has started
 	a.notifyInfo(fmt.Sprintf("IMAP polling started (%d second intervals)", a.Config.IntervalSec))
-
-	go a.pollLoop(a.pollStop)
 }
 
 // StopPolling stops the poll loop and saves PollingEnabled = false.
 func (a *App) StopPolling() {
-	a.mu.Lock()
-	if a.pollStop == nil {
-		a.mu.Unlock()
+	if !a.engine.Running() {
 		return
 	}
-	ch := a.pollStop
-	a.pollStop = nil
-	a.mu.Unlock()
-	close(ch)
+	a.engine.Stop()
 	a.Config.PollingEnabled = false
 	_ = config.Save(a.Config)
 	a.updatePollButtons()
@@ -527,9 +956,29 @@ func (a *App) StopPolling() {
 	a.notifyInfo("IMAP polling stopped")
 }
 
+// fetchNow checks for new mail right away, in the background, without waiting for the next poll.
+func (a *App) fetchNow() {
+	go func() {
+		n, err := a.engine.FetchNow(a.ctx)
+		switch {
+		case errors.Is(err, engine.ErrFetchInProgress):
+			a.notifyInfo("Already checking for new mail")
+		case err != nil:
+			// Reported by the engine
+		case n == 0:
+			a.notifyInfo("No new mail")
+		default:
+			a.notifyInfo(fmt.Sprintf("Processed %d new message(s)", n))
+		}
+	}()
+}
+
 // Run shows the window and, if config.PollingEnabled, starts the poll loop (restores previous state).
 func (a *App) Run() {
 	a.Logger.Info("IGCmail IMAP application started")
+	if !config.Exists() {
+		a.showSetupWizard()
+	}
 
 	if a.Config.PollingEnabled {
 		a.StartPolling()
@@ -538,88 +987,3 @@ func (a *App) Run() {
 	}
 	a.Win.ShowAndRun()
 }
-
-func (a *App) pollLoop(stopCh chan struct{}) {
-	interval := time.Duration(a.intervalSeconds()) * time.Second
-	time.Sleep(2 * time.Second)
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	for {
-		a.fetchAndExtract()
-		select {
-		case <-stopCh:
-			return
-		case <-ticker.C:
-			// next iteration
-		}
-	}
-}
-
-func (a *App) intervalSeconds() int {
-	a.mu.Lock()
-	sec := a.Config.IntervalSec
-	a.mu.Unlock()
-	if sec <= 0 {
-		return 60
-	}
-	return sec
-}
-
-func (a *App) fetchAndExtract() {
-	a.mu.Lock()
-	cfg := *a.Config
-	st := a.State
-	a.mu.Unlock()
-
-	if cfg.OutputFolder == "" || cfg.IMAPServer == "" {
-		return
-	}
-
-	fetcher := imap.NewFetcher(&cfg, st)
-	msgs, err := fetcher.FetchNew()
-	if err != nil {
-		a.Logger.Error("IMAP fetch failed: " + err.Error())
-		a.notifyError("IMAP: " + err.Error())
-		return
-	}
-
-	if len(msgs) == 0 {
-		return
-	}
-
-	// Log fetch summary and individual messages only when there are new messages
-	var uids []uint32
-	for _, msg := range msgs {
-		uids = append(uids, msg.UID)
-		a.Logger.LogMessageDetails(msg.UID, msg.Subject, msg.From)
-	}
-	a.Logger.LogFetch(len(msgs), cfg.OutputFolder, uids)
-
-	saveDir := extract.NewSaveDir(cfg.OutputFolder)
-	var allResults []logger.ExtractResult
-	for _, m := range msgs {
-		results, err := extract.ExtractIGCAttachments(m.Body, saveDir)
-		if err != nil {
-			a.Logger.Error(fmt.Sprintf("IGC extraction failed for UID %d: %s", m.UID, err.Error()))
-			a.notifyError("Extract: " + err.Error())
-			continue
-		}
-
-		// Convert extract.ExtractResult to logger.ExtractResult
-		var loggerResults []logger.ExtractResult
-		for _, result := range results {
-			loggerResults = append(loggerResults, logger.ExtractResult{
-				Filename: result.Filename,
-				Path:     result.Path,
-			})
-		}
-		allResults = append(allResults, loggerResults...)
-
-		// Log details for this message
-		if len(loggerResults) > 0 {
-			a.Logger.LogMessageExtract(m.UID, m.Subject, m.From, loggerResults, cfg.OutputFolder)
-		}
-	}
-
-	a.Logger.LogExtract(len(allResults), cfg.OutputFolder)
-}
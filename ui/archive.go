@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// showImportArchiveDialog lets the user pick an .eml/mbox file or a Maildir (or any folder
+// holding such files) and imports the messages it contains.
+func (a *App) showImportArchiveDialog() {
+	var d dialog.Dialog
+	fileBtn := widget.NewButton("Choose .eml or mbox file...", func() {
+		d.Hide()
+		fd := dialog.NewFileOpen(func(rc fyne.URIReadCloser, err error) {
+			if err != nil || rc == nil {
+				return
+			}
+			rc.Close()
+			go a.importArchive(rc.URI().Path())
+		}, a.Win)
+		fd.Show()
+	})
+	folderBtn := widget.NewButton("Choose Maildir or folder...", func() {
+		d.Hide()
+		fd := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err != nil || uri == nil {
+				return
+			}
+			go a.importArchive(uri.Path())
+		}, a.Win)
+		fd.Show()
+	})
+	help := widget.NewLabel("Messages are filtered and extracted like newly fetched mail. " +
+		"Folders are searched for .eml files, mbox files and Maildirs. The IMAP state is not changed.")
+	help.Wrapping = fyne.TextWrapWord
+
+	d = dialog.NewCustom("Import mail archive", "Cancel", container.NewVBox(help, fileBtn, folderBtn), a.Win)
+	d.Resize(fyne.NewSize(420, 220))
+	d.Show()
+}
+
+// importArchive runs an archive import in the engine and reports the outcome.
+func (a *App) importArchive(path string) {
+	a.notifyInfo("Importing mail archive " + path)
+	n, err := a.engine.ImportArchive(a.ctx, path)
+	if err != nil {
+		a.notifyError(fmt.Sprintf("Import: %s (%d messages processed)", err.Error(), n))
+		return
+	}
+	a.loadRecentFlights()
+	a.updateTrayMenu()
+	a.notifyInfo(fmt.Sprintf("Import finished: %d messages processed", n))
+}
@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/logger"
+	"igcmailimap/state"
+)
+
+// updateFailures shows the failed-messages button while some messages have been given up on.
+// It is the engine's Failures hook.
+func (a *App) updateFailures(permanent []state.Failed) {
+	if a.failuresBtn == nil {
+		return
+	}
+	if len(permanent) == 0 {
+		a.failuresBtn.Hide()
+		return
+	}
+	if len(permanent) == 1 {
+		a.failuresBtn.SetText("1 message could not be processed — Review...")
+	} else {
+		a.failuresBtn.SetText(fmt.Sprintf("%d messages could not be processed — Review...", len(permanent)))
+	}
+	a.failuresBtn.Show()
+}
+
+// showFailuresWindow lists the messages waiting for a retry or given up on, with buttons to
+// retry or dismiss them.
+func (a *App) showFailuresWindow() {
+	w := a.Fyne.NewWindow("Failed messages")
+	failed := a.engine.FailedMessages()
+
+	var list *widget.List
+	refresh := func() {
+		failed = a.engine.FailedMessages()
+		list.Refresh()
+		a.updateFailures(permanentOnly(failed))
+	}
+	list = widget.NewList(
+		func() int { return len(failed) },
+		func() fyne.CanvasObject {
+			retry := widget.NewButtonWithIcon("Retry", theme.ViewRefreshIcon(), nil)
+			dismiss := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+			text := widget.NewLabel("")
+			text.Wrapping = fyne.TextWrapWord
+			return container.NewBorder(nil, nil, nil, container.NewHBox(retry, dismiss), text)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			f := failed[id]
+			row := obj.(*fyne.Container)
+			status := "retry at " + f.NextRetry.Local().Format("15:04")
+			if f.Permanent {
+				status = "gave up"
+			}
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s  %s — %s\n%d attempts, %s: %s",
+				f.Ref, f.From, f.Subject, f.Attempts, status, f.LastError))
+			buttons := row.Objects[1].(*fyne.Container)
+			buttons.Objects[0].(*widget.Button).OnTapped = func() {
+				if err := a.engine.RetryFailed(f.Ref); err != nil {
+					a.Logger.Error("Failed to save state", logger.KeyError, err)
+					a.notifyError("State: " + err.Error())
+				}
+				refresh()
+			}
+			buttons.Objects[1].(*widget.Button).OnTapped = func() {
+				if err := a.engine.DismissFailed(f.Ref); err != nil {
+					a.Logger.Error("Failed to save state", logger.KeyError, err)
+					a.notifyError("State: " + err.Error())
+				}
+				refresh()
+			}
+		},
+	)
+	help := widget.NewLabel("Messages that could not be fetched or extracted are retried with increasing delays. " +
+		"After several failed attempts they are given up on: Retry schedules them for the next poll, the bin forgets them.")
+	help.Wrapping = fyne.TextWrapWord
+	w.SetContent(container.NewBorder(help, nil, nil, nil, list))
+	w.Resize(fyne.NewSize(640, 420))
+	w.Show()
+}
+
+func permanentOnly(failed []state.Failed) []state.Failed {
+	var out []state.Failed
+	for _, f := range failed {
+		if f.Permanent {
+			out = append(out, f)
+		}
+	}
+	return out
+}
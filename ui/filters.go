@@ -0,0 +1,162 @@
+package ui
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+	"igcmailimap/filter"
+)
+
+const headerFieldOption = "header…"
+
+// showFiltersWindow opens the editor for the sender allow/block lists and filter rules.
+func (a *App) showFiltersWindow() {
+	w := a.Fyne.NewWindow("Filters")
+
+	a.mu.Lock()
+	allow := strings.Join(a.Config.FilterAllow, "\n")
+	deny := strings.Join(a.Config.FilterDeny, "\n")
+	rules := append([]filter.Rule(nil), a.Config.FilterRules...)
+	a.mu.Unlock()
+
+	allowEntry := widget.NewMultiLineEntry()
+	allowEntry.SetPlaceHolder("One address or domain per line (empty = accept everyone)")
+	allowEntry.SetText(allow)
+	allowEntry.SetMinRowsVisible(3)
+
+	denyEntry := widget.NewMultiLineEntry()
+	denyEntry.SetPlaceHolder("One address or domain per line")
+	denyEntry.SetText(deny)
+	denyEntry.SetMinRowsVisible(3)
+
+	var ruleList *widget.List
+	ruleList = widget.NewList(
+		func() int { return len(rules) },
+		func() fyne.CanvasObject {
+			up := widget.NewButtonWithIcon("", theme.MoveUpIcon(), nil)
+			del := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+			return container.NewBorder(nil, nil, nil, container.NewHBox(up, del), widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			row := obj.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(rules[id].String())
+			buttons := row.Objects[1].(*fyne.Container)
+			buttons.Objects[0].(*widget.Button).OnTapped = func() {
+				if id > 0 {
+					rules[id-1], rules[id] = rules[id], rules[id-1]
+					ruleList.Refresh()
+				}
+			}
+			buttons.Objects[1].(*widget.Button).OnTapped = func() {
+				rules = append(rules[:id], rules[id+1:]...)
+				ruleList.Refresh()
+			}
+		},
+	)
+
+	// New rule form
+	headerEntry := widget.NewEntry()
+	headerEntry.SetPlaceHolder("Header name, e.g. X-Mailer")
+	headerEntry.Disable()
+	fieldSelect := widget.NewSelect(append(append([]string(nil), filter.Fields...), headerFieldOption), func(s string) {
+		if s == headerFieldOption {
+			headerEntry.Enable()
+		} else {
+			headerEntry.Disable()
+		}
+	})
+	fieldSelect.SetSelected(filter.FieldFrom)
+	matchSelect := widget.NewSelect(filter.Matches, nil)
+	matchSelect.SetSelected(filter.MatchContains)
+	patternEntry := widget.NewEntry()
+	patternEntry.SetPlaceHolder("Pattern")
+	actionSelect := widget.NewSelect(filter.Actions, nil)
+	actionSelect.SetSelected(filter.ActionAllow)
+	subfolderEntry := widget.NewEntry()
+	subfolderEntry.SetPlaceHolder("Subfolder (optional, allow rules only)")
+
+	addBtn := widget.NewButtonWithIcon("Add rule", theme.ContentAddIcon(), func() {
+		r := filter.Rule{
+			Field:     fieldSelect.Selected,
+			Match:     matchSelect.Selected,
+			Pattern:   patternEntry.Text,
+			Action:    actionSelect.Selected,
+			Subfolder: strings.TrimSpace(subfolderEntry.Text),
+		}
+		if r.Field == headerFieldOption {
+			r.Field = "header:" + strings.TrimSpace(headerEntry.Text)
+		}
+		if r.Action == filter.ActionDeny {
+			r.Subfolder = ""
+		}
+		if _, err := filter.New(nil, nil, []filter.Rule{r}); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		rules = append(rules, r)
+		ruleList.Refresh()
+		patternEntry.SetText("")
+		subfolderEntry.SetText("")
+	})
+
+	newRule := widget.NewForm(
+		widget.NewFormItem("Field", container.NewGridWithColumns(2, fieldSelect, headerEntry)),
+		widget.NewFormItem("Match", container.NewGridWithColumns(2, matchSelect, patternEntry)),
+		widget.NewFormItem("Action", container.NewGridWithColumns(2, actionSelect, subfolderEntry)),
+		widget.NewFormItem("", addBtn),
+	)
+
+	saveBtn := widget.NewButton("Save", func() {
+		allowList := splitLines(allowEntry.Text)
+		denyList := splitLines(denyEntry.Text)
+		if _, err := filter.New(allowList, denyList, rules); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		a.mu.Lock()
+		a.Config.FilterAllow = allowList
+		a.Config.FilterDeny = denyList
+		a.Config.FilterRules = rules
+		err := config.Save(a.Config)
+		a.engine.SetConfig(a.Config)
+		a.mu.Unlock()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		a.Logger.Info("Filter settings saved")
+		w.Close()
+	})
+	cancelBtn := widget.NewButton("Cancel", func() { w.Close() })
+
+	lists := widget.NewForm(
+		widget.NewFormItem("Allow senders", allowEntry),
+		widget.NewFormItem("Block senders", denyEntry),
+	)
+	help := widget.NewLabel("Blocked senders are skipped first, then the first matching rule decides. " +
+		"If the allow list is not empty, other senders are skipped.")
+	help.Wrapping = fyne.TextWrapWord
+
+	top := container.NewVBox(help, lists, widget.NewLabel("Rules (first match wins)"))
+	bottom := container.NewVBox(newRule, container.NewHBox(saveBtn, cancelBtn))
+	w.SetContent(container.NewBorder(top, bottom, nil, nil, ruleList))
+	w.Resize(fyne.NewSize(560, 620))
+	w.CenterOnScreen()
+	w.Show()
+}
+
+// splitLines returns the trimmed, non-empty lines of s.
+func splitLines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
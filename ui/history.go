@@ -0,0 +1,162 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/history"
+	"igcmailimap/logger"
+)
+
+// historyLimit is the number of most recent records loaded into the history window.
+const historyLimit = 1000
+
+const allOutcomes = "All outcomes"
+
+// showHistoryWindow lists the processed messages recorded in the history database, with
+// details of the selected one and a button to fetch and extract it again.
+func (a *App) showHistoryWindow() {
+	if a.history == nil {
+		dialog.ShowInformation("History", "The history database could not be opened; see the activity log.", a.Win)
+		return
+	}
+	w := a.Fyne.NewWindow("History")
+
+	var records, shown []history.Record
+	selected := -1
+
+	details := widget.NewLabel("Select a message to see its details.")
+	details.Wrapping = fyne.TextWrapWord
+	reprocessBtn := widget.NewButton("Reprocess", nil)
+	reprocessBtn.Disable()
+
+	list := widget.NewList(
+		func() int { return len(shown) },
+		func() fyne.CanvasObject {
+			l := widget.NewLabel("")
+			l.Truncation = fyne.TextTruncateEllipsis
+			return l
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			r := shown[id]
+			l := obj.(*widget.Label)
+			switch r.Outcome {
+			case history.OutcomeFailed:
+				l.Importance = widget.DangerImportance
+			case history.OutcomeExtracted:
+				l.Importance = widget.MediumImportance
+			default:
+				l.Importance = widget.LowImportance
+			}
+			l.SetText(fmt.Sprintf("%s  %-9s  %s — %s", r.ProcessedAt.Format("2006-01-02 15:04"), r.Outcome, r.From, r.Subject))
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		selected = id
+		details.SetText(recordDetails(shown[id]))
+		reprocessBtn.Enable()
+	}
+
+	outcomeSelect := widget.NewSelect([]string{allOutcomes, history.OutcomeExtracted, history.OutcomeNoFiles,
+		history.OutcomeSkipped, history.OutcomeDuplicate, history.OutcomeFailed}, nil)
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Search sender, subject, file or Message-ID")
+
+	// filter applies the outcome and search to the loaded records
+	filter := func() {
+		query := strings.ToLower(strings.TrimSpace(searchEntry.Text))
+		shown = nil
+		for _, r := range records {
+			if outcomeSelect.Selected != allOutcomes && r.Outcome != outcomeSelect.Selected {
+				continue
+			}
+			if query != "" && !strings.Contains(strings.ToLower(recordDetails(r)), query) {
+				continue
+			}
+			shown = append(shown, r)
+		}
+		selected = -1
+		list.UnselectAll()
+		details.SetText("Select a message to see its details.")
+		reprocessBtn.Disable()
+		list.Refresh()
+	}
+	load := func() {
+		var err error
+		records, err = a.history.List(historyLimit)
+		if err != nil {
+			a.Logger.Error("Failed to read message history", logger.KeyError, err)
+			dialog.ShowError(err, w)
+		}
+		filter()
+	}
+	outcomeSelect.OnChanged = func(string) { filter() }
+	searchEntry.OnChanged = func(string) { filter() }
+
+	reprocessBtn.OnTapped = func() {
+		if selected < 0 {
+			return
+		}
+		id := shown[selected].ID
+		reprocessBtn.Disable()
+		go func() {
+			n, err := a.engine.Reprocess(a.ctx, []uint64{id})
+			if err != nil {
+				a.Logger.Error("Reprocessing failed", logger.KeyError, err)
+				dialog.ShowError(err, w)
+			} else {
+				a.notifyInfo(fmt.Sprintf("Reprocessed %d message(s)", n))
+			}
+			a.loadRecentFlights()
+			a.updateTrayMenu()
+			load()
+		}()
+	}
+	refreshBtn := widget.NewButton("Refresh", load)
+
+	outcomeSelect.SetSelected(allOutcomes)
+	load()
+
+	top := container.NewBorder(nil, nil, outcomeSelect, nil, searchEntry)
+	bottom := container.NewVBox(
+		widget.NewCard("", "", container.NewVScroll(details)),
+		container.NewHBox(reprocessBtn, refreshBtn),
+	)
+	split := container.NewVSplit(list, bottom)
+	split.Offset = 0.6
+	w.SetContent(container.NewBorder(top, nil, nil, nil, split))
+	w.Resize(fyne.NewSize(820, 560))
+	w.Show()
+}
+
+// recordDetails formats all fields of a history record for the details pane (and search).
+func recordDetails(r history.Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\nSubject: %s\n", r.From, r.Subject)
+	if !r.Date.IsZero() {
+		fmt.Fprintf(&b, "Date: %s\n", r.Date.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(&b, "Message-ID: %s\n", r.MessageID)
+	fmt.Fprintf(&b, "Account: %s on %s, %s", r.Account, r.Server, r.Folder)
+	if r.UID != 0 {
+		fmt.Fprintf(&b, " UID %d", r.UID)
+	}
+	if r.SourceID != "" {
+		fmt.Fprintf(&b, " ID %s", r.SourceID)
+	}
+	fmt.Fprintf(&b, "\nProcessed: %s — %s", r.ProcessedAt.Format("2006-01-02 15:04:05"), r.Outcome)
+	if r.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", r.Reason)
+	}
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "\nFile: %s  sha256:%s", f.Path, f.SHA256)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "\nError: %s", e)
+	}
+	return b.String()
+}
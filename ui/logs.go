@@ -0,0 +1,142 @@
+package ui
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/logger"
+)
+
+// logRefreshInterval is how often the log window checks the buffer for new entries.
+const logRefreshInterval = time.Second
+
+// showLogWindow opens the viewer for the recent log entries kept in memory by the logger, or
+// brings it to the front if it is already open.
+func (a *App) showLogWindow() {
+	if a.logWin != nil {
+		a.logWin.Show()
+		a.logWin.RequestFocus()
+		return
+	}
+	w := a.Fyne.NewWindow("Activity log")
+	a.logWin = w
+
+	buf := logger.Recent()
+	// shown is replaced by refresh, which also runs on the ticker goroutine below
+	var mu sync.Mutex
+	var shown []logger.Entry
+	entries := func() []logger.Entry {
+		mu.Lock()
+		defer mu.Unlock()
+		return shown
+	}
+
+	list := widget.NewList(
+		func() int { return len(entries()) },
+		func() fyne.CanvasObject {
+			l := widget.NewLabel("")
+			l.TextStyle = fyne.TextStyle{Monospace: true}
+			l.Truncation = fyne.TextTruncateEllipsis
+			return l
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			l := obj.(*widget.Label)
+			cur := entries()
+			if id >= len(cur) {
+				return // the list shrank since it asked for the length
+			}
+			e := cur[id]
+			switch {
+			case e.Level >= slog.LevelError:
+				l.Importance = widget.DangerImportance
+			case e.Level >= slog.LevelWarn:
+				l.Importance = widget.WarningImportance
+			case e.Level < slog.LevelInfo:
+				l.Importance = widget.LowImportance
+			default:
+				l.Importance = widget.MediumImportance
+			}
+			l.SetText(e.String())
+		},
+	)
+
+	levelSelect := widget.NewSelect(logger.Levels, nil)
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Search")
+	followCheck := widget.NewCheck("Auto-scroll while polling", nil)
+	followCheck.SetChecked(true)
+	countLabel := widget.NewLabel("")
+
+	// refresh re-applies the level filter and search to the buffer
+	refresh := func() {
+		minLevel := logger.ParseLevel(levelSelect.Selected)
+		query := strings.ToLower(strings.TrimSpace(searchEntry.Text))
+		var out []logger.Entry
+		for _, e := range buf.Entries() {
+			if e.Level < minLevel {
+				continue
+			}
+			if query != "" && !strings.Contains(strings.ToLower(e.String()), query) {
+				continue
+			}
+			out = append(out, e)
+		}
+		mu.Lock()
+		shown = out
+		mu.Unlock()
+		countLabel.SetText(fmt.Sprintf("%d shown", len(out)))
+		list.Refresh()
+	}
+	levelSelect.OnChanged = func(string) { refresh() }
+	searchEntry.OnChanged = func(string) { refresh() }
+	levelSelect.SetSelected(logger.Levels[1]) // info; also runs the first refresh
+
+	copyBtn := widget.NewButton("Copy shown", func() {
+		cur := entries()
+		lines := make([]string, len(cur))
+		for i, e := range cur {
+			lines[i] = e.String()
+		}
+		w.Clipboard().SetContent(strings.Join(lines, "\n"))
+	})
+
+	top := container.NewBorder(nil, nil, levelSelect, nil, searchEntry)
+	bottom := container.NewHBox(followCheck, countLabel, layout.NewSpacer(), copyBtn)
+	w.SetContent(container.NewBorder(top, bottom, nil, nil, list))
+	w.Resize(fyne.NewSize(760, 480))
+	list.ScrollToBottom()
+
+	// Poll the buffer while the window is open
+	done := make(chan struct{})
+	w.SetOnClosed(func() {
+		close(done)
+		a.logWin = nil
+	})
+	go func() {
+		ticker := time.NewTicker(logRefreshInterval)
+		defer ticker.Stop()
+		seq := buf.Seq()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if s := buf.Seq(); s != seq {
+					seq = s
+					refresh()
+					if followCheck.Checked && a.engine.Running() {
+						list.ScrollToBottom()
+					}
+				}
+			}
+		}
+	}()
+	w.Show()
+}
@@ -0,0 +1,205 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+	"igcmailimap/logger"
+	"igcmailimap/pilots"
+)
+
+// showPilotsWindow opens the pilot directory editor (file location, filename template and pilot list).
+func (a *App) showPilotsWindow() {
+	w := a.Fyne.NewWindow("Pilots")
+
+	a.mu.Lock()
+	path := a.Config.PilotDirectory
+	tmpl := a.Config.FilenameTemplate
+	a.mu.Unlock()
+	enabled := path != ""
+	if path == "" {
+		path, _ = config.PilotsPath()
+	}
+
+	dir, err := pilots.Load(path)
+	if err != nil {
+		a.Logger.Error("Failed to load pilot directory", "path", path, logger.KeyError, err)
+		dir = &pilots.Directory{}
+	}
+	list := append([]pilots.Pilot(nil), dir.Pilots...)
+
+	enabledCheck := widget.NewCheck("Save flights into per-pilot folders", nil)
+	enabledCheck.SetChecked(enabled)
+
+	var pilotList *widget.List
+
+	pathEntry := widget.NewEntry()
+	pathEntry.SetText(path)
+	browseBtn := widget.NewButton("Browse...", func() {
+		d := dialog.NewFileOpen(func(rc fyne.URIReadCloser, err error) {
+			if err != nil || rc == nil {
+				return
+			}
+			rc.Close()
+			loaded, err := pilots.Load(rc.URI().Path())
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			pathEntry.SetText(rc.URI().Path())
+			list = loaded.Pilots
+			pilotList.Refresh()
+		}, w)
+		d.Show()
+	})
+
+	templateEntry := widget.NewEntry()
+	templateEntry.SetPlaceHolder(pilots.DefaultTemplate)
+	templateEntry.SetText(tmpl)
+	templateHelp := widget.NewLabel(pilots.TemplateHelp)
+	templateHelp.Wrapping = fyne.TextWrapWord
+
+	// Pilot editor
+	idEntry := widget.NewEntry()
+	idEntry.SetPlaceHolder("Pilot ID (folder name)")
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Full name")
+	emailsEntry := widget.NewEntry()
+	emailsEntry.SetPlaceHolder("Email addresses, separated by ;")
+	igcNamesEntry := widget.NewEntry()
+	igcNamesEntry.SetPlaceHolder("IGC pilot names (HFPLTPILOT), separated by ;")
+	serialsEntry := widget.NewEntry()
+	serialsEntry.SetPlaceHolder("Logger serials, e.g. XCSABC, separated by ;")
+	editing := -1
+
+	pilotList = widget.NewList(
+		func() int { return len(list) },
+		func() fyne.CanvasObject {
+			del := widget.NewButtonWithIcon("", theme.DeleteIcon(), nil)
+			return container.NewBorder(nil, nil, nil, del, widget.NewLabel(""))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			row := obj.(*fyne.Container)
+			p := list[id]
+			row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s — %s", p.ID, p.Name))
+			row.Objects[1].(*widget.Button).OnTapped = func() {
+				list = append(list[:id], list[id+1:]...)
+				editing = -1
+				pilotList.UnselectAll()
+				pilotList.Refresh()
+			}
+		},
+	)
+	pilotList.OnSelected = func(id widget.ListItemID) {
+		p := list[id]
+		editing = id
+		idEntry.SetText(p.ID)
+		nameEntry.SetText(p.Name)
+		emailsEntry.SetText(strings.Join(p.Emails, "; "))
+		igcNamesEntry.SetText(strings.Join(p.IGCNames, "; "))
+		serialsEntry.SetText(strings.Join(p.LoggerSerials, "; "))
+	}
+
+	clearEditor := func() {
+		editing = -1
+		pilotList.UnselectAll()
+		for _, e := range []*widget.Entry{idEntry, nameEntry, emailsEntry, igcNamesEntry, serialsEntry} {
+			e.SetText("")
+		}
+	}
+	applyBtn := widget.NewButtonWithIcon("Add / update pilot", theme.ContentAddIcon(), func() {
+		p := pilots.Pilot{
+			ID:            strings.TrimSpace(idEntry.Text),
+			Name:          strings.TrimSpace(nameEntry.Text),
+			Emails:        splitList(emailsEntry.Text),
+			IGCNames:      splitList(igcNamesEntry.Text),
+			LoggerSerials: splitList(serialsEntry.Text),
+		}
+		updated := append([]pilots.Pilot(nil), list...)
+		if editing >= 0 && editing < len(updated) {
+			updated[editing] = p
+		} else {
+			updated = append(updated, p)
+		}
+		if err := (&pilots.Directory{Pilots: updated}).Validate(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		list = updated
+		pilotList.Refresh()
+		clearEditor()
+	})
+	newBtn := widget.NewButton("New", clearEditor)
+
+	editor := widget.NewForm(
+		widget.NewFormItem("ID", idEntry),
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Emails", emailsEntry),
+		widget.NewFormItem("IGC names", igcNamesEntry),
+		widget.NewFormItem("Loggers", serialsEntry),
+		widget.NewFormItem("", container.NewHBox(applyBtn, newBtn)),
+	)
+
+	saveBtn := widget.NewButton("Save", func() {
+		d := &pilots.Directory{Pilots: list}
+		if err := d.Validate(); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		p := strings.TrimSpace(pathEntry.Text)
+		if p == "" {
+			dialog.ShowError(fmt.Errorf("choose a file for the pilot directory"), w)
+			return
+		}
+		if err := pilots.Save(p, d); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		a.mu.Lock()
+		if enabledCheck.Checked {
+			a.Config.PilotDirectory = p
+		} else {
+			a.Config.PilotDirectory = ""
+		}
+		a.Config.FilenameTemplate = strings.TrimSpace(templateEntry.Text)
+		err := config.Save(a.Config)
+		a.engine.SetConfig(a.Config)
+		a.mu.Unlock()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		a.Logger.Info("Pilot directory saved", "path", p, "pilots", len(list))
+		w.Close()
+	})
+	cancelBtn := widget.NewButton("Cancel", func() { w.Close() })
+
+	settings := widget.NewForm(
+		widget.NewFormItem("", enabledCheck),
+		widget.NewFormItem("Directory file", container.NewBorder(nil, nil, nil, browseBtn, pathEntry)),
+		widget.NewFormItem("Filename template", templateEntry),
+	)
+	top := container.NewVBox(settings, templateHelp, widget.NewLabel("Pilots (unmatched flights go to \"unknown\")"))
+	bottom := container.NewVBox(editor, container.NewHBox(saveBtn, cancelBtn))
+	w.SetContent(container.NewBorder(top, bottom, nil, nil, pilotList))
+	w.Resize(fyne.NewSize(560, 680))
+	w.CenterOnScreen()
+	w.Show()
+}
+
+// splitList splits a ";"-separated entry into trimmed, non-empty values.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/extract"
+	"igcmailimap/logger"
+	"igcmailimap/preview"
+)
+
+// showFlightPreview opens a window with the track of an extracted file on a plain map and
+// its barogram, to check at a glance that it is the expected flight.
+func (a *App) showFlightPreview(path string) {
+	if !a.flightExists(path) {
+		return
+	}
+	fl, err := extract.ReadFlight(path)
+	if err == nil && len(fl.Fixes) < 2 {
+		err = fmt.Errorf("%s has no track", filepath.Base(path))
+	}
+	if err != nil {
+		a.Logger.Error("Failed to read flight", "path", path, logger.KeyError, err)
+		a.notifyError("Preview: " + err.Error())
+		return
+	}
+
+	s := preview.Summarize(fl)
+	var who []string
+	for _, v := range []string{fl.Pilot, fl.GliderType, fl.GliderID} {
+		if v != "" {
+			who = append(who, v)
+		}
+	}
+	header := widget.NewLabel(fmt.Sprintf("%s\n%s  %s–%s UTC (%s)  %d–%d m  %.1f km flown",
+		strings.Join(who, " · "), s.Takeoff.Format("2006-01-02"), s.Takeoff.Format("15:04"), s.Landing.Format("15:04"),
+		s.Landing.Sub(s.Takeoff).Round(time.Minute).String(), s.MinAlt, s.MaxAlt, s.Distance))
+
+	trackMap := canvas.NewRaster(func(w, h int) image.Image { return preview.Map(fl, w, h) })
+	trackMap.SetMinSize(fyne.NewSize(480, 320))
+	barogram := canvas.NewRaster(func(w, h int) image.Image { return preview.Barogram(fl, w, h) })
+	barogram.SetMinSize(fyne.NewSize(480, 140))
+
+	buttons := container.NewHBox(
+		widget.NewButton("Open", func() { a.openFlight(path) }),
+		widget.NewButton("Show in folder", func() { a.revealFlight(path) }),
+		widget.NewButton("Copy path", func() { a.copyFlightPath(path) }),
+	)
+	w := a.Fyne.NewWindow("Preview — " + filepath.Base(path))
+	w.SetContent(container.NewBorder(header, container.NewVBox(barogram, buttons), nil, nil, trackMap))
+	w.Resize(fyne.NewSize(820, 700))
+	w.Show()
+}
@@ -0,0 +1,190 @@
+package ui
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/history"
+	"igcmailimap/logger"
+)
+
+const (
+	recentFlightsMax = 30  // files listed in the recent flights pane
+	recentTrayMax    = 10  // files listed in the tray submenu
+	recentScan       = 500 // history records searched for extracted files
+)
+
+// recentFlight is an extracted file and the message it came from.
+type recentFlight struct {
+	history.File
+	From        string
+	Subject     string
+	ProcessedAt time.Time
+}
+
+// recentFlights returns the last n files extracted, newest first.
+func recentFlights(db *history.DB, n int) ([]recentFlight, error) {
+	records, err := db.List(recentScan)
+	if err != nil {
+		return nil, err
+	}
+	var out []recentFlight
+	for _, r := range records {
+		if r.Outcome != history.OutcomeExtracted {
+			continue
+		}
+		for _, f := range r.Files {
+			out = append(out, recentFlight{File: f, From: r.From, Subject: r.Subject, ProcessedAt: r.ProcessedAt})
+			if len(out) == n {
+				return out, nil
+			}
+		}
+	}
+	return out, nil
+}
+
+// loadRecentFlights reloads the recent flights from the history into the pane. The caller
+// updates the tray menu.
+func (a *App) loadRecentFlights() {
+	if a.history == nil {
+		return
+	}
+	flights, err := recentFlights(a.history, recentFlightsMax)
+	if err != nil {
+		a.Logger.Error("Failed to read message history", logger.KeyError, err)
+		return
+	}
+	a.mu.Lock()
+	a.recent = flights
+	a.mu.Unlock()
+	if a.recentList != nil {
+		a.recentList.Refresh()
+	}
+}
+
+// buildRecentPane returns the "Recent flights" pane of the main window.
+func (a *App) buildRecentPane() fyne.CanvasObject {
+	a.recentList = widget.NewList(
+		func() int {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return len(a.recent)
+		},
+		func() fyne.CanvasObject {
+			name := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+			name.Truncation = fyne.TextTruncateEllipsis
+			from := widget.NewLabel("")
+			from.Truncation = fyne.TextTruncateEllipsis
+			from.Importance = widget.LowImportance
+			buttons := container.NewHBox(
+				widget.NewButtonWithIcon("", theme.VisibilityIcon(), nil),
+				widget.NewButtonWithIcon("", theme.FileIcon(), nil),
+				widget.NewButtonWithIcon("", theme.FolderOpenIcon(), nil),
+				widget.NewButtonWithIcon("", theme.ContentCopyIcon(), nil),
+			)
+			return container.NewBorder(nil, nil, nil, buttons, container.NewVBox(name, from))
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			a.mu.Lock()
+			if id >= len(a.recent) {
+				a.mu.Unlock()
+				return
+			}
+			f := a.recent[id]
+			a.mu.Unlock()
+			row := obj.(*fyne.Container)
+			labels := row.Objects[0].(*fyne.Container)
+			labels.Objects[0].(*widget.Label).SetText(f.Name)
+			labels.Objects[1].(*widget.Label).SetText(fmt.Sprintf("%s  %s — %s", f.ProcessedAt.Format("02.01. 15:04"), f.From, f.Subject))
+			buttons := row.Objects[1].(*fyne.Container)
+			buttons.Objects[0].(*widget.Button).OnTapped = func() { a.showFlightPreview(f.Path) }
+			buttons.Objects[1].(*widget.Button).OnTapped = func() { a.openFlight(f.Path) }
+			buttons.Objects[2].(*widget.Button).OnTapped = func() { a.revealFlight(f.Path) }
+			buttons.Objects[3].(*widget.Button).OnTapped = func() { a.copyFlightPath(f.Path) }
+		},
+	)
+
+	title := widget.NewLabelWithStyle("Recent flights", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	if a.history == nil {
+		note := widget.NewLabel("The history database could not be opened; see the activity log.")
+		note.Wrapping = fyne.TextWrapWord
+		return container.NewBorder(title, nil, nil, nil, note)
+	}
+	return container.NewBorder(title, nil, nil, nil, a.recentList)
+}
+
+// recentTrayItem returns the "Recent flights" tray submenu.
+func (a *App) recentTrayItem() *fyne.MenuItem {
+	a.mu.Lock()
+	flights := a.recent
+	a.mu.Unlock()
+	if len(flights) > recentTrayMax {
+		flights = flights[:recentTrayMax]
+	}
+
+	item := fyne.NewMenuItem("Recent flights", nil)
+	if len(flights) == 0 {
+		item.Disabled = true
+		return item
+	}
+	var entries []*fyne.MenuItem
+	for _, f := range flights {
+		path := f.Path
+		entry := fyne.NewMenuItem(truncate(f.Name+" — "+f.From, 60), nil)
+		entry.ChildMenu = fyne.NewMenu("",
+			fyne.NewMenuItem("Preview", func() { a.showFlightPreview(path) }),
+			fyne.NewMenuItem("Open", func() { a.openFlight(path) }),
+			fyne.NewMenuItem("Show in folder", func() { a.revealFlight(path) }),
+			fyne.NewMenuItem("Copy path", func() { a.copyFlightPath(path) }),
+		)
+		entries = append(entries, entry)
+	}
+	item.ChildMenu = fyne.NewMenu("", entries...)
+	return item
+}
+
+// openFlight opens an extracted file in the default application for its type.
+func (a *App) openFlight(path string) {
+	if !a.flightExists(path) {
+		return
+	}
+	u, err := url.Parse(storage.NewFileURI(path).String())
+	if err == nil {
+		err = a.Fyne.OpenURL(u)
+	}
+	if err != nil {
+		a.Logger.Error("Failed to open file", "path", path, logger.KeyError, err)
+		a.notifyError("Open: " + err.Error())
+	}
+}
+
+// revealFlight shows an extracted file in the file manager.
+func (a *App) revealFlight(path string) {
+	if !a.flightExists(path) {
+		return
+	}
+	if err := revealFile(path); err != nil {
+		a.Logger.Error("Failed to show file in folder", "path", path, logger.KeyError, err)
+		a.notifyError("Show in folder: " + err.Error())
+	}
+}
+
+func (a *App) copyFlightPath(path string) {
+	a.Win.Clipboard().SetContent(path)
+}
+
+// flightExists reports whether the file is still there, notifying the user if not.
+func (a *App) flightExists(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		a.notifyError("File no longer exists: " + path)
+		return false
+	}
+	return true
+}
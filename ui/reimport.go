@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+)
+
+// showReimportDialog asks for a date range and re-imports the messages received in it,
+// without changing the saved UID state.
+func (a *App) showReimportDialog() {
+	fromEntry := widget.NewEntry()
+	fromEntry.SetPlaceHolder("YYYY-MM-DD")
+	fromEntry.SetText(time.Now().AddDate(0, 0, -7).Format(config.DateFormat))
+	toEntry := widget.NewEntry()
+	toEntry.SetPlaceHolder("YYYY-MM-DD (empty = today)")
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("From", fromEntry),
+		widget.NewFormItem("To", toEntry),
+	}
+	dialog.ShowForm("Re-import range", "Import", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		since, err := time.ParseInLocation(config.DateFormat, strings.TrimSpace(fromEntry.Text), time.Local)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid From date, use YYYY-MM-DD"), a.Win)
+			return
+		}
+		var before time.Time
+		if to := strings.TrimSpace(toEntry.Text); to != "" {
+			t, err := time.ParseInLocation(config.DateFormat, to, time.Local)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("invalid To date, use YYYY-MM-DD"), a.Win)
+				return
+			}
+			before = t.AddDate(0, 0, 1) // IMAP BEFORE is exclusive; include the To day
+		}
+		go a.reimport(since, before)
+	}, a.Win)
+}
+
+// reimport runs a re-import in the engine and reports the outcome.
+func (a *App) reimport(since, before time.Time) {
+	n, err := a.engine.Reimport(a.ctx, since, before)
+	if err != nil {
+		a.notifyError("Re-import: " + err.Error())
+		return
+	}
+	a.loadRecentFlights()
+	a.updateTrayMenu()
+	a.notifyInfo(fmt.Sprintf("Re-import finished: %d messages processed", n))
+}
@@ -0,0 +1,10 @@
+//go:build darwin
+
+package ui
+
+import "os/exec"
+
+// revealFile shows the file selected in a Finder window.
+func revealFile(path string) error {
+	return exec.Command("open", "-R", path).Start()
+}
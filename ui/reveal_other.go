@@ -0,0 +1,14 @@
+//go:build !windows && !darwin
+
+package ui
+
+import (
+	"os/exec"
+	"path/filepath"
+)
+
+// revealFile opens the folder holding the file in the file manager (there is no portable way
+// to select the file itself).
+func revealFile(path string) error {
+	return exec.Command("xdg-open", filepath.Dir(path)).Start()
+}
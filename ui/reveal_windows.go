@@ -0,0 +1,10 @@
+//go:build windows
+
+package ui
+
+import "os/exec"
+
+// revealFile opens Explorer with the file selected.
+func revealFile(path string) error {
+	return exec.Command("explorer", "/select,"+path).Start()
+}
@@ -0,0 +1,133 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/driver/desktop"
+	"igcmailimap/engine"
+)
+
+// Tray icon variants, shown as a coloured badge on the application icon.
+const (
+	trayIdle    = "idle"    // polling stopped
+	trayPolling = "polling" // polling, last fetch succeeded
+	trayError   = "error"   // polling, but the server is failing
+)
+
+var trayBadges = map[string]color.RGBA{
+	trayIdle:    {R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff},
+	trayPolling: {R: 0x2e, G: 0xa0, B: 0x43, A: 0xff},
+	trayError:   {R: 0xd3, G: 0x2f, B: 0x2f, A: 0xff},
+}
+
+// updateStatus shows the poll status in the tray icon and menu. It is the engine's Status hook.
+func (a *App) updateStatus(st engine.Status) {
+	variant := trayIdle
+	switch {
+	case st.Running && st.Health != engine.Healthy:
+		variant = trayError
+	case st.Running:
+		variant = trayPolling
+	}
+	a.mu.Lock()
+	checked := !st.LastCheck.Equal(a.status.LastCheck)
+	a.status = st
+	changed := variant != a.trayVariant
+	a.trayVariant = variant
+	a.mu.Unlock()
+
+	if checked && st.NewFlights > 0 {
+		a.loadRecentFlights()
+	}
+
+	if desk, ok := a.Fyne.(desktop.App); ok && changed {
+		if icon := trayIcon(variant); icon != nil {
+			desk.SetSystemTrayIcon(icon)
+		}
+	}
+	a.updateTrayMenu()
+}
+
+// statusLines returns the disabled tray menu lines describing the poll status.
+func statusLines(st engine.Status) []*fyne.MenuItem {
+	var lines []string
+	switch {
+	case st.Fetching:
+		lines = append(lines, "Checking for new mail...")
+	case st.LastCheck.IsZero():
+		lines = append(lines, "Not checked yet")
+	default:
+		lines = append(lines, fmt.Sprintf("Last check %s — %s", st.LastCheck.Format("15:04"), flights(st.NewFlights)))
+	}
+	if !st.NextCheck.IsZero() && !st.Fetching {
+		lines = append(lines, "Next check "+st.NextCheck.Format("15:04"))
+	}
+	if st.LastError != "" {
+		lines = append(lines, "Error: "+truncate(st.LastError, 60))
+	}
+	items := make([]*fyne.MenuItem, len(lines))
+	for i, l := range lines {
+		items[i] = fyne.NewMenuItem(l, nil)
+		items[i].Disabled = true
+	}
+	return items
+}
+
+func flights(n int) string {
+	switch n {
+	case 0:
+		return "no new flights"
+	case 1:
+		return "1 new flight"
+	}
+	return fmt.Sprintf("%d new flights", n)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// trayIcon returns the application icon with the badge of the variant, or nil if the
+// embedded icon cannot be decoded.
+func trayIcon(variant string) fyne.Resource {
+	src, err := png.Decode(bytes.NewReader(iconPNG))
+	if err != nil {
+		return nil
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(b)
+	draw.Draw(img, b, src, b.Min, draw.Src)
+
+	// Filled circle with a white ring in the bottom right corner
+	r := b.Dx() / 5
+	cx, cy := b.Max.X-r-1, b.Max.Y-r-1
+	badge := trayBadges[variant]
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			d := (x-cx)*(x-cx) + (y-cy)*(y-cy)
+			switch {
+			case d <= (r-2)*(r-2):
+				img.Set(x, y, badge)
+			case d <= r*r:
+				img.Set(x, y, white)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil
+	}
+	return fyne.NewStaticResource("tray-"+variant+".png", buf.Bytes())
+}
@@ -0,0 +1,53 @@
+package ui
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+	"igcmailimap/engine"
+	"igcmailimap/logger"
+)
+
+// formAccount returns the config with the account fields as currently entered in the form,
+// saved or not.
+func (a *App) formAccount() *config.Config {
+	a.mu.Lock()
+	cfg := *a.Config
+	a.mu.Unlock()
+	cfg.IMAPServer = a.serverEntry.Text
+	cfg.Protocol = protocol(strings.ToLower(a.protocolSelect.Selected))
+	cfg.Security = security(strings.ToLower(a.securitySelect.Selected))
+	cfg.IMAPUser = a.userEntry.Text
+	cfg.IMAPPassword = a.passEntry.Text
+	return &cfg
+}
+
+// testConnection runs a connection test with the account in the form and shows the report.
+func (a *App) testConnection(btn *widget.Button) {
+	cfg := a.formAccount()
+	btn.Disable()
+	btn.SetText("Testing...")
+	go func() {
+		d := engine.Diagnose(a.ctx, cfg)
+		report := engine.Report(cfg, d)
+		btn.SetText("Test connection")
+		btn.Enable()
+		if failed := d.Failed(); failed != nil {
+			a.Logger.Warning("Connection test failed", logger.KeyAccount, cfg.IMAPUser, "step", failed.Name, logger.KeyError, failed.Err)
+		} else {
+			a.Logger.Info("Connection test succeeded", logger.KeyAccount, cfg.IMAPUser, "server", cfg.IMAPServer)
+		}
+
+		text := widget.NewLabel(report)
+		text.Wrapping = fyne.TextWrapWord
+		copyBtn := widget.NewButton("Copy", func() { a.Win.Clipboard().SetContent(report) })
+		dlg := dialog.NewCustom("Connection test", "Close",
+			container.NewBorder(nil, container.NewHBox(copyBtn), nil, nil, container.NewVScroll(text)), a.Win)
+		dlg.Resize(fyne.NewSize(560, 460))
+		dlg.Show()
+	}()
+}
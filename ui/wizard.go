@@ -0,0 +1,297 @@
+package ui
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"igcmailimap/config"
+	"igcmailimap/engine"
+	"igcmailimap/logger"
+	"igcmailimap/pilots"
+)
+
+// outputLayouts are the wizard's choices for arranging extracted files, with their filename
+// templates; the pilot layout also turns on the pilot directory.
+var outputLayouts = []struct{ label, template string }{
+	{"All files in the output folder", ""},
+	{"One folder per year (2024/flight.igc)", "{year}/{filename}"},
+	{"One folder per flight date (2024-06-01/flight.igc)", "{date}/{filename}"},
+	{"One folder per sender (pilot@example.com/flight.igc)", "{sender}/{filename}"},
+	{"One folder per pilot, using the pilot directory", pilots.DefaultTemplate},
+}
+
+const pilotLayout = 4 // index of the per-pilot layout in outputLayouts
+
+// showSetupWizard walks through the settings needed to start: provider, account, output folder
+// and layout, a connection test and the initial import. Finishing saves the settings and
+// starts polling.
+func (a *App) showSetupWizard() {
+	w := a.Fyne.NewWindow("Setup")
+
+	a.mu.Lock()
+	cfg := *a.Config
+	a.mu.Unlock()
+
+	// Provider
+	var names []string
+	for _, p := range config.Providers {
+		names = append(names, p.Name)
+	}
+	providerRadio := widget.NewRadioGroup(names, nil)
+	providerRadio.SetSelected(config.ProviderForServer(cfg.IMAPServer).Name)
+
+	// Account
+	serverEntry := widget.NewEntry()
+	serverEntry.SetText(cfg.IMAPServer)
+	protocolSelect := widget.NewSelect([]string{"IMAP", "POP3", "JMAP"}, nil)
+	protocolSelect.SetSelected(strings.ToUpper(protocol(cfg.Protocol)))
+	securitySelect := widget.NewSelect([]string{"TLS", "STARTTLS"}, nil)
+	securitySelect.SetSelected(strings.ToUpper(security(cfg.Security)))
+	userEntry := widget.NewEntry()
+	userEntry.SetText(cfg.IMAPUser)
+	passEntry := widget.NewPasswordEntry()
+	passEntry.SetText(cfg.IMAPPassword)
+	help := widget.NewLabel("")
+	help.Wrapping = fyne.TextWrapWord
+	helpLink := widget.NewHyperlink("", nil)
+
+	// Output
+	outputEntry := widget.NewEntry()
+	outputEntry.SetText(cfg.OutputFolder)
+	browseBtn := widget.NewButton("Browse...", func() {
+		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
+			if err == nil && uri != nil {
+				outputEntry.SetText(uri.Path())
+			}
+		}, w)
+	})
+	var layoutLabels []string
+	for _, l := range outputLayouts {
+		layoutLabels = append(layoutLabels, l.label)
+	}
+	layoutRadio := widget.NewRadioGroup(layoutLabels, nil)
+	layoutRadio.SetSelected(outputLayouts[layoutIndex(&cfg)].label)
+
+	// Connection test
+	testResult := widget.NewLabel("Not tested yet. The test connects, signs in and lists your folders without changing anything.")
+	testResult.Wrapping = fyne.TextWrapWord
+	var testBtn *widget.Button
+	testBtn = widget.NewButton("Test connection", func() {
+		test := cfg
+		wizardAccount(&test, serverEntry, protocolSelect, securitySelect, userEntry, passEntry)
+		testBtn.Disable()
+		testResult.SetText("Testing...")
+		go func() {
+			d := engine.Diagnose(a.ctx, &test)
+			if failed := d.Failed(); failed != nil {
+				a.Logger.Warning("Connection test failed", logger.KeyAccount, test.IMAPUser, "step", failed.Name, logger.KeyError, failed.Err)
+			} else {
+				a.Logger.Info("Connection test succeeded", logger.KeyAccount, test.IMAPUser, "server", test.IMAPServer)
+			}
+			testResult.SetText(engine.Report(&test, d))
+			testBtn.Enable()
+		}()
+	})
+
+	// Initial import
+	backfillSelect := widget.NewSelect(backfillLabels, nil)
+	backfillDate := widget.NewEntry()
+	backfillDate.SetPlaceHolder("YYYY-MM-DD")
+	backfillDate.SetText(cfg.BackfillSince)
+	backfillSelect.OnChanged = func(label string) {
+		if backfillMode(label) == config.BackfillSince {
+			backfillDate.Enable()
+		} else {
+			backfillDate.Disable()
+		}
+	}
+	backfillSelect.SetSelected(backfillLabel(cfg.BackfillMode))
+	startCheck := widget.NewCheck("Start polling now", nil)
+	startCheck.SetChecked(true)
+
+	providerRadio.OnChanged = func(name string) {
+		p := config.ProviderByName(name)
+		if p.Server != "" {
+			serverEntry.SetText(p.Server)
+			protocolSelect.SetSelected(strings.ToUpper(p.Protocol))
+			securitySelect.SetSelected(strings.ToUpper(p.Security))
+		}
+		userEntry.SetPlaceHolder(p.UserHint)
+		help.SetText(p.Help)
+		if u, err := url.Parse(p.HelpURL); err == nil && p.HelpURL != "" {
+			helpLink.SetText("Open " + u.Host)
+			helpLink.SetURL(u)
+			helpLink.Show()
+		} else {
+			helpLink.Hide()
+		}
+	}
+	providerRadio.OnChanged(providerRadio.Selected)
+
+	steps := []struct {
+		title   string
+		content fyne.CanvasObject
+		check   func() error // validates the step before moving on
+	}{
+		{"Which mail provider receives your flights?", container.NewVScroll(providerRadio), nil},
+		{"Account", container.NewVBox(
+			widget.NewForm(
+				widget.NewFormItem("Mail server", serverEntry),
+				widget.NewFormItem("Protocol", container.NewGridWithColumns(2, protocolSelect, securitySelect)),
+				widget.NewFormItem("User", userEntry),
+				widget.NewFormItem("Password", passEntry),
+			),
+			help, helpLink,
+		), func() error {
+			if strings.TrimSpace(serverEntry.Text) == "" || passEntry.Text == "" {
+				return fmt.Errorf("enter the mail server and password")
+			}
+			if userEntry.Text == "" && strings.ToLower(protocolSelect.Selected) != config.ProtocolJMAP {
+				return fmt.Errorf("enter the user name")
+			}
+			return nil
+		}},
+		{"Where should flights be saved?", widget.NewForm(
+			widget.NewFormItem("Output folder", container.NewBorder(nil, nil, nil, browseBtn, outputEntry)),
+			widget.NewFormItem("Layout", layoutRadio),
+		), func() error {
+			if strings.TrimSpace(outputEntry.Text) == "" {
+				return fmt.Errorf("choose an output folder")
+			}
+			return nil
+		}},
+		{"Test the connection", container.NewBorder(container.NewHBox(testBtn), nil, nil, nil, container.NewVScroll(testResult)), nil},
+		{"Which existing mail should be imported?", container.NewVBox(
+			widget.NewForm(widget.NewFormItem("Initial import", container.NewGridWithColumns(2, backfillSelect, backfillDate))),
+			widget.NewLabel("Later polls only fetch mail that arrives after the first one."),
+			startCheck,
+		), func() error {
+			if backfillMode(backfillSelect.Selected) == config.BackfillSince {
+				if _, err := (&config.Config{BackfillSince: strings.TrimSpace(backfillDate.Text)}).BackfillSinceDate(); err != nil {
+					return fmt.Errorf("the import date must be YYYY-MM-DD")
+				}
+			}
+			return nil
+		}},
+	}
+
+	title := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	body := container.NewStack()
+	backBtn := widget.NewButton("Back", nil)
+	nextBtn := widget.NewButton("Next", nil)
+	nextBtn.Importance = widget.HighImportance
+	cancelBtn := widget.NewButton("Cancel", func() { w.Close() })
+
+	step := 0
+	show := func() {
+		title.SetText(fmt.Sprintf("Step %d of %d: %s", step+1, len(steps), steps[step].title))
+		body.Objects = []fyne.CanvasObject{steps[step].content}
+		body.Refresh()
+		if step == 0 {
+			backBtn.Disable()
+		} else {
+			backBtn.Enable()
+		}
+		if step == len(steps)-1 {
+			nextBtn.SetText("Finish")
+		} else {
+			nextBtn.SetText("Next")
+		}
+	}
+	backBtn.OnTapped = func() {
+		step--
+		show()
+	}
+	nextBtn.OnTapped = func() {
+		if check := steps[step].check; check != nil {
+			if err := check(); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+		}
+		if step < len(steps)-1 {
+			step++
+			show()
+			return
+		}
+
+		wizardAccount(&cfg, serverEntry, protocolSelect, securitySelect, userEntry, passEntry)
+		cfg.OutputFolder = strings.TrimSpace(outputEntry.Text)
+		cfg.BackfillMode = backfillMode(backfillSelect.Selected)
+		cfg.BackfillSince = strings.TrimSpace(backfillDate.Text)
+		layout := 0
+		for i, l := range outputLayouts {
+			if l.label == layoutRadio.Selected {
+				layout = i
+			}
+		}
+		cfg.FilenameTemplate = outputLayouts[layout].template
+		cfg.PilotDirectory = ""
+		if layout == pilotLayout {
+			cfg.PilotDirectory, _ = config.PilotsPath()
+		}
+		a.applySetup(&cfg, startCheck.Checked, w.Close)
+	}
+	show()
+
+	w.SetContent(container.NewBorder(title, container.NewHBox(cancelBtn, backBtn, nextBtn), nil, nil, body))
+	w.Resize(fyne.NewSize(600, 460))
+	w.CenterOnScreen()
+	w.Show()
+}
+
+// wizardAccount copies the account fields of the wizard into cfg.
+func wizardAccount(cfg *config.Config, server *widget.Entry, proto, sec *widget.Select, user, pass *widget.Entry) {
+	cfg.Protocol = protocol(strings.ToLower(proto.Selected))
+	cfg.Security = security(strings.ToLower(sec.Selected))
+	cfg.IMAPServer = strings.TrimSpace(server.Text)
+	if !cfg.IsJMAP() {
+		cfg.IMAPServer = config.WithDefaultPort(cfg.IMAPServer, cfg.Protocol, cfg.Security)
+	}
+	cfg.IMAPUser = strings.TrimSpace(user.Text)
+	cfg.IMAPPassword = pass.Text
+}
+
+// layoutIndex returns the outputLayouts entry matching the config, or the flat layout.
+func layoutIndex(cfg *config.Config) int {
+	if cfg.PilotDirectory != "" {
+		return pilotLayout
+	}
+	for i, l := range outputLayouts {
+		if i != pilotLayout && l.template == cfg.FilenameTemplate {
+			return i
+		}
+	}
+	return 0
+}
+
+// applySetup puts the wizard's settings into the main form and saves them the usual way, then
+// starts polling if asked and calls done.
+func (a *App) applySetup(cfg *config.Config, start bool, done func()) {
+	a.serverEntry.SetText(cfg.IMAPServer)
+	a.protocolSelect.SetSelected(strings.ToUpper(cfg.Protocol))
+	a.securitySelect.SetSelected(strings.ToUpper(cfg.Security))
+	a.userEntry.SetText(cfg.IMAPUser)
+	a.passEntry.SetText(cfg.IMAPPassword)
+	a.outputEntry.SetText(cfg.OutputFolder)
+	a.backfillSelect.SetSelected(backfillLabel(cfg.BackfillMode))
+	a.backfillDateEntry.SetText(cfg.BackfillSince)
+
+	a.mu.Lock()
+	a.Config.PilotDirectory = cfg.PilotDirectory
+	a.Config.FilenameTemplate = cfg.FilenameTemplate
+	a.mu.Unlock()
+	// save always writes the file, so a first run is not detected again
+	a.save(func() {
+		a.Logger.Info("Setup completed", logger.KeyAccount, cfg.IMAPUser, "server", cfg.IMAPServer)
+		if start {
+			a.StartPolling() // no-op if already polling; the engine has the new settings
+		}
+		done()
+	})
+}